@@ -0,0 +1,458 @@
+package ahx
+
+import (
+    "io"
+    "bytes"
+    "fmt"
+    "log"
+    "encoding/binary"
+)
+
+const (
+    // ahx songs always have 4 channels, hively tracker songs have up to 16
+    AHXChannels = 4
+    MaxChannels = 16
+    // notes 1 to 60 are C-1 to B-5
+    MaxNote = 60
+)
+
+type Step struct {
+    Note int // 1-60, 0 for no note
+    Instrument int // 0 for no instrument
+    Effect int
+    EffectParameter int
+    // hively tracker steps have a second effect
+    Effect2 int
+    Effect2Parameter int
+    // true if the step comes from a hively tracker song
+    Hively bool
+}
+
+// the position and name of a note are counted from C-1, like the notes of the other formats
+func (step *Step) GetNotePosition() int {
+    if step.Note > 0 {
+        return step.Note - 1 + 12
+    }
+
+    return 0
+}
+
+func (step *Step) GetName() string {
+    if step.Note > 0 {
+        names := []string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+        return fmt.Sprintf("%v%v", names[(step.Note - 1) % 12], (step.Note - 1) / 12 + 1)
+    }
+
+    return "..."
+}
+
+func (step *Step) GetSampleName() string {
+    if step.Instrument > 0 {
+        return fmt.Sprintf("%02d", step.Instrument)
+    }
+
+    return ".."
+}
+
+// ahx does not have a volume column
+func (step *Step) GetVolumeName() string {
+    return ""
+}
+
+func (step *Step) GetEffectName() string {
+    first := "..."
+    if step.Effect > 0 || step.EffectParameter > 0 {
+        first = fmt.Sprintf("%X%02X", step.Effect, step.EffectParameter)
+    }
+
+    if !step.Hively {
+        return first
+    }
+
+    second := "..."
+    if step.Effect2 > 0 || step.Effect2Parameter > 0 {
+        second = fmt.Sprintf("%X%02X", step.Effect2, step.Effect2Parameter)
+    }
+
+    return first + " " + second
+}
+
+// the volume envelope of an instrument, the volumes are 0-64
+type Envelope struct {
+    AttackFrames int
+    AttackVolume int
+    DecayFrames int
+    DecayVolume int
+    SustainFrames int
+    ReleaseFrames int
+    ReleaseVolume int
+}
+
+// a line of the performance list of an instrument, which is played one line every few frames
+type PerformanceEntry struct {
+    // 0 keeps the note, otherwise the note is relative to the note of the step unless it is fixed
+    Note int
+    Fixed bool
+    // 0 keeps the waveform, then triangle, sawtooth, square and noise
+    Waveform int
+    Commands [2]int
+    Parameters [2]int
+}
+
+type Instrument struct {
+    Name string
+    Volume int // 0-64
+    // the waveforms are 4 << WaveLength samples long, so a longer wave plays a lower note
+    WaveLength int
+    Envelope Envelope
+
+    FilterSpeed int
+    FilterLowerLimit int
+    FilterUpperLimit int
+
+    SquareLowerLimit int
+    SquareUpperLimit int
+    SquareSpeed int
+
+    VibratoDelay int
+    VibratoDepth int
+    VibratoSpeed int
+
+    // the note is cut this many frames before the next instrument plays
+    HardCutReleaseFrames int
+    // the note fades out with the release of the envelope rather than stopping when it is cut
+    HardCutRelease bool
+
+    PerformanceSpeed int
+    Performance []PerformanceEntry
+}
+
+type Position struct {
+    // the track and transpose of each channel
+    Tracks []int
+    Transposes []int
+}
+
+type AHXFile struct {
+    Name string
+    // true for hively tracker songs, which have more channels and effects
+    Hively bool
+    Version int
+    Channels int
+    // the song plays this many frames for every frame of a 50hz amiga
+    SpeedMultiplier int
+    // the position that the song continues from once it ends
+    Restart int
+    // the number of steps in every track
+    TrackLength int
+    Tracks [][]Step
+    Positions []Position
+    // instrument 0 is empty so that the steps can refer to instruments by number
+    Instruments []Instrument
+    // the position that each sub song starts at
+    Subsongs []int
+    // the volume of the mix in percent
+    MixGain int
+    // 0-4, how far apart the left and right channels are
+    Stereo int
+}
+
+// the panning of the left and right channels, and the mix gain, for each stereo setting
+var stereoLeft = []int{128, 96, 64, 32, 0}
+var stereoRight = []int{128, 160, 193, 225, 255}
+var stereoGain = []int{71, 72, 76, 85, 100}
+
+// ahx songs don't store a stereo setting, so they use the usual default
+const defaultStereo = 2
+
+// reads the song data in order, remembering the first error
+type songReader struct {
+    data []byte
+    position int
+    err error
+}
+
+func (reader *songReader) read(length int) []byte {
+    if reader.err != nil {
+        return make([]byte, length)
+    }
+
+    if reader.position + length > len(reader.data) {
+        reader.err = fmt.Errorf("song is cut off at offset %v", reader.position)
+        return make([]byte, length)
+    }
+
+    out := reader.data[reader.position:reader.position + length]
+    reader.position += length
+    return out
+}
+
+// read an ahx instrument, or a hively tracker instrument which stores its performance list differently
+func readInstrument(reader *songReader, hively bool, version int) Instrument {
+    data := reader.read(22)
+
+    instrument := Instrument{
+        Volume: int(data[0]),
+        FilterSpeed: int(data[1] >> 3 & 0x1f) | int(data[12] >> 2 & 0x20),
+        WaveLength: int(data[1] & 7),
+        Envelope: Envelope{
+            AttackFrames: int(data[2]),
+            AttackVolume: int(data[3]),
+            DecayFrames: int(data[4]),
+            DecayVolume: int(data[5]),
+            SustainFrames: int(data[6]),
+            ReleaseFrames: int(data[7]),
+            ReleaseVolume: int(data[8]),
+        },
+        FilterLowerLimit: int(data[12] & 0x7f),
+        VibratoDelay: int(data[13]),
+        HardCutReleaseFrames: int(data[14] >> 4 & 7),
+        HardCutRelease: data[14] & 0x80 != 0,
+        VibratoDepth: int(data[14] & 0xf),
+        VibratoSpeed: int(data[15]),
+        SquareLowerLimit: int(data[16]),
+        SquareUpperLimit: int(data[17]),
+        SquareSpeed: int(data[18]),
+        FilterUpperLimit: int(data[19] & 0x3f),
+        PerformanceSpeed: int(data[20]),
+    }
+
+    // the wave lengths go up to 128 samples
+    instrument.WaveLength = min(instrument.WaveLength, 5)
+
+    length := int(data[21])
+
+    for range length {
+        var entry PerformanceEntry
+
+        if hively {
+            line := reader.read(5)
+            entry.Commands[0] = int(line[0] & 0xf)
+            entry.Commands[1] = int(line[1] >> 3 & 0xf)
+            entry.Waveform = int(line[1] & 7)
+            entry.Fixed = line[2] >> 6 & 1 != 0
+            entry.Note = int(line[2] & 0x3f)
+            entry.Parameters[0] = int(line[3])
+            entry.Parameters[1] = int(line[4])
+        } else {
+            // ccc ddd ww wfnnnnnn pppppppp pppppppp, where the command numbers 6 and 7 are 12 and 15
+            line := reader.read(4)
+
+            command := func(value byte) int {
+                switch value {
+                    case 6: return 12
+                    case 7: return 15
+                }
+                return int(value)
+            }
+
+            entry.Commands[1] = command(line[0] >> 5 & 7)
+            entry.Commands[0] = command(line[0] >> 2 & 7)
+            entry.Waveform = int(line[0] << 1 & 6) | int(line[1] >> 7)
+            entry.Fixed = line[1] >> 6 & 1 != 0
+            entry.Note = int(line[1] & 0x3f)
+            entry.Parameters[0] = int(line[2])
+            entry.Parameters[1] = int(line[3])
+
+            // version 0 had no filter, so the filter half of the modulation command is ignored
+            if version == 0 {
+                for i := range 2 {
+                    if entry.Commands[i] == 4 {
+                        entry.Parameters[i] &= 0xf
+                    }
+                }
+            }
+        }
+
+        entry.Waveform = min(entry.Waveform, 4)
+        instrument.Performance = append(instrument.Performance, entry)
+    }
+
+    return instrument
+}
+
+// read a track of hively tracker steps, where an empty step is a single byte
+func readHivelyTrack(reader *songReader, length int) []Step {
+    steps := make([]Step, length)
+
+    for i := range steps {
+        steps[i].Hively = true
+
+        if reader.position < len(reader.data) && reader.data[reader.position] == 0x3f {
+            reader.position += 1
+            continue
+        }
+
+        data := reader.read(5)
+        // notes past the end of the period table are played as the highest note
+        steps[i].Note = min(int(data[0]), MaxNote)
+        steps[i].Instrument = int(data[1])
+        steps[i].Effect = int(data[2] >> 4)
+        steps[i].EffectParameter = int(data[3])
+        steps[i].Effect2 = int(data[2] & 0xf)
+        steps[i].Effect2Parameter = int(data[4])
+    }
+
+    return steps
+}
+
+func readAHXTrack(reader *songReader, length int) []Step {
+    steps := make([]Step, length)
+
+    for i := range steps {
+        // nnnnnnii iiiieeee pppppppp
+        data := reader.read(3)
+        steps[i].Note = min(int(data[0] >> 2 & 0x3f), MaxNote)
+        steps[i].Instrument = int(data[0] & 3) << 4 | int(data[1] >> 4)
+        steps[i].Effect = int(data[1] & 0xf)
+        steps[i].EffectParameter = int(data[2])
+    }
+
+    return steps
+}
+
+// read a string that ends with a zero
+func readString(data []byte, offset int) (string, int) {
+    if offset >= len(data) {
+        return "", offset
+    }
+
+    end := bytes.IndexByte(data[offset:], 0)
+    if end == -1 {
+        return string(data[offset:]), len(data)
+    }
+
+    return string(data[offset:offset + end]), offset + end + 1
+}
+
+func Load(reader_ io.Reader, logger *log.Logger) (*AHXFile, error) {
+    data, err := io.ReadAll(reader_)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(data) < 16 {
+        return nil, fmt.Errorf("Not an ahx file, too short")
+    }
+
+    var file AHXFile
+
+    switch string(data[:3]) {
+        case "THX":
+            if data[3] > 1 {
+                return nil, fmt.Errorf("Unknown ahx version %v", data[3])
+            }
+        case "HVL":
+            if data[3] > 1 {
+                return nil, fmt.Errorf("Unknown hively tracker version %v", data[3])
+            }
+            file.Hively = true
+        default:
+            return nil, fmt.Errorf("Not an ahx file, signature was %v", data[:3])
+    }
+
+    file.Version = int(data[3])
+
+    namesOffset := int(binary.BigEndian.Uint16(data[4:]))
+    // track 0 is not stored when it is empty
+    track0Empty := data[6] & 0x80 != 0
+    file.SpeedMultiplier = int(data[6] >> 5 & 3) + 1
+    positionCount := int(data[6] & 0xf) << 8 | int(data[7])
+    file.TrackLength = int(data[10])
+    trackCount := int(data[11]) + 1
+    instrumentCount := int(data[12])
+    subsongCount := int(data[13])
+
+    reader := songReader{data: data}
+
+    if file.Hively {
+        file.Channels = int(data[8] >> 2) + 4
+        file.Restart = int(data[8] & 3) << 8 | int(data[9])
+        file.MixGain = int(data[14])
+        file.Stereo = min(int(data[15]), len(stereoLeft) - 1)
+        reader.position = 16
+    } else {
+        file.Channels = AHXChannels
+        file.Restart = int(data[8]) << 8 | int(data[9])
+        file.Stereo = defaultStereo
+        file.MixGain = stereoGain[defaultStereo]
+        reader.position = 14
+    }
+
+    logger.Printf("AHX hively %v version %v channels %v positions %v tracks %v track length %v instruments %v subsongs %v speed multiplier %v", file.Hively, file.Version, file.Channels, positionCount, trackCount, file.TrackLength, instrumentCount, subsongCount, file.SpeedMultiplier)
+
+    if file.Channels > MaxChannels {
+        return nil, fmt.Errorf("Invalid number of channels %v", file.Channels)
+    }
+
+    if positionCount == 0 || file.TrackLength == 0 || file.TrackLength > 64 {
+        return nil, fmt.Errorf("Not an ahx file, invalid header")
+    }
+
+    if file.Restart >= positionCount {
+        file.Restart = 0
+    }
+
+    for range subsongCount {
+        file.Subsongs = append(file.Subsongs, int(binary.BigEndian.Uint16(reader.read(2))))
+    }
+
+    for range positionCount {
+        var position Position
+        for range file.Channels {
+            entry := reader.read(2)
+            position.Tracks = append(position.Tracks, int(entry[0]))
+            position.Transposes = append(position.Transposes, int(int8(entry[1])))
+        }
+        file.Positions = append(file.Positions, position)
+    }
+
+    for i := range trackCount {
+        if i == 0 && track0Empty {
+            file.Tracks = append(file.Tracks, make([]Step, file.TrackLength))
+            for step := range file.Tracks[0] {
+                file.Tracks[0][step].Hively = file.Hively
+            }
+            continue
+        }
+
+        if file.Hively {
+            file.Tracks = append(file.Tracks, readHivelyTrack(&reader, file.TrackLength))
+        } else {
+            file.Tracks = append(file.Tracks, readAHXTrack(&reader, file.TrackLength))
+        }
+    }
+
+    file.Instruments = append(file.Instruments, Instrument{})
+    for range instrumentCount {
+        file.Instruments = append(file.Instruments, readInstrument(&reader, file.Hively, file.Version))
+    }
+
+    if reader.err != nil {
+        return nil, reader.err
+    }
+
+    // positions can refer to tracks past the last one, which are empty
+    for i := range file.Positions {
+        for channel, track := range file.Positions[i].Tracks {
+            if track >= len(file.Tracks) {
+                logger.Printf("Position %v refers to missing track %v", i, track)
+                file.Positions[i].Tracks[channel] = 0
+            }
+        }
+    }
+
+    // the names come after the song, first the name of the song and then each instrument
+    offset := namesOffset
+    file.Name, offset = readString(data, offset)
+    for i := 1; i < len(file.Instruments); i++ {
+        file.Instruments[i].Name, offset = readString(data, offset)
+    }
+
+    logger.Printf("Song name '%v'", file.Name)
+    for i, instrument := range file.Instruments[1:] {
+        logger.Printf("Instrument %v: Name='%v', Volume=%v, WaveLength=%v, Performance=%v", i + 1, instrument.Name, instrument.Volume, instrument.WaveLength, len(instrument.Performance))
+    }
+
+    return &file, nil
+}
@@ -0,0 +1,117 @@
+package ahx
+
+import (
+    "testing"
+    "bytes"
+    "encoding/binary"
+    "io"
+    "log"
+)
+
+func TestReadTracks(test *testing.T) {
+    // C-1 of instrument 1 with effect C40, then note 63 of instrument 33 which is past the table
+    ahx := songReader{data: []byte{1 << 2, 0x1c, 0x40, 63 << 2 | 2, 0x10, 0}}
+    steps := readAHXTrack(&ahx, 2)
+
+    if ahx.err != nil {
+        test.Fatalf("%v", ahx.err)
+    }
+
+    expected := []Step{
+        {Note: 1, Instrument: 1, Effect: 0xc, EffectParameter: 0x40},
+        {Note: MaxNote, Instrument: 33},
+    }
+
+    for i := range expected {
+        if steps[i] != expected[i] {
+            test.Errorf("ahx step %v: expected %+v, got %+v", i, expected[i], steps[i])
+        }
+    }
+
+    // an empty step, then a step with two effects
+    hively := songReader{data: []byte{0x3f, 70, 2, 0x4c, 0x12, 0x34}}
+    steps = readHivelyTrack(&hively, 2)
+
+    if hively.err != nil {
+        test.Fatalf("%v", hively.err)
+    }
+
+    expected = []Step{
+        {Hively: true},
+        {Note: MaxNote, Instrument: 2, Effect: 4, EffectParameter: 0x12, Effect2: 0xc, Effect2Parameter: 0x34, Hively: true},
+    }
+
+    for i := range expected {
+        if steps[i] != expected[i] {
+            test.Errorf("hively step %v: expected %+v, got %+v", i, expected[i], steps[i])
+        }
+    }
+
+    // a track that is cut off is an error
+    cut := songReader{data: []byte{1 << 2, 0x1c}}
+    readAHXTrack(&cut, 1)
+    if cut.err == nil {
+        test.Errorf("expected an error for a track that is cut off")
+    }
+}
+
+// an ahx song with one position, one track of 4 steps and one instrument, followed by the names
+func makeSong() []byte {
+    song := []byte("THX\x00")
+    song = append(song, 0, 0, 0, 1, 0, 0, 4, 0, 1, 0)
+
+    for range AHXChannels {
+        song = append(song, 0, 0)
+    }
+
+    for range 4 {
+        song = append(song, 24 << 2, 0x10, 0)
+    }
+
+    instrument := make([]byte, 22)
+    instrument[0] = 64
+    instrument[1] = 3
+    instrument[21] = 1
+    song = append(song, instrument...)
+    // triangle at the note of the step
+    song = append(song, 0, 0x80, 0, 0)
+
+    binary.BigEndian.PutUint16(song[4:], uint16(len(song)))
+    return append(song, "song\x00instrument\x00"...)
+}
+
+func TestLoad(test *testing.T) {
+    logger := log.New(io.Discard, "", 0)
+
+    data := makeSong()
+    file, err := Load(bytes.NewReader(data), logger)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    if file.Name != "song" || len(file.Positions) != 1 || len(file.Tracks) != 1 || file.TrackLength != 4 {
+        test.Errorf("unexpected song '%v' positions %v tracks %v track length %v", file.Name, len(file.Positions), len(file.Tracks), file.TrackLength)
+    }
+
+    // instrument 0 is the empty instrument
+    if len(file.Instruments) != 2 {
+        test.Fatalf("expected 2 instruments, got %v", len(file.Instruments))
+    }
+
+    instrument := file.Instruments[1]
+    if instrument.Name != "instrument" || instrument.Volume != 64 || instrument.WaveLength != 3 || len(instrument.Performance) != 1 || instrument.Performance[0].Waveform != 1 {
+        test.Errorf("unexpected instrument %+v", instrument)
+    }
+
+    // the names are optional, but a song that is cut off before them is an error
+    namesOffset := int(binary.BigEndian.Uint16(data[4:]))
+    for length := range len(data) {
+        _, err := Load(bytes.NewReader(data[:length]), logger)
+        if length < namesOffset && err == nil {
+            test.Errorf("expected an error for a song cut off at %v bytes", length)
+        }
+        if length >= namesOffset && err != nil {
+            test.Errorf("song cut off in the names at %v bytes: %v", length, err)
+        }
+    }
+}
@@ -0,0 +1,1235 @@
+package ahx
+
+import (
+    "io"
+    "math"
+    "runtime"
+
+    "github.com/kazzmir/tracker/common"
+)
+
+// the amiga plays one sample of a waveform every period of this clock
+const amigaClock = 3546897
+
+// each channel plays its waveform over and over from a buffer of this many samples
+const voiceBufferLength = 0x280
+
+// the periods of the notes, index 0 is unused and 1 is C-1
+var periods = []int{
+    0x0000, 0x0d60, 0x0ca0, 0x0be8, 0x0b40, 0x0a98, 0x0a00, 0x0970,
+    0x08e8, 0x0868, 0x07f0, 0x0780, 0x0714, 0x06b0, 0x0650, 0x05f4,
+    0x05a0, 0x054c, 0x0500, 0x04b8, 0x0474, 0x0434, 0x03f8, 0x03c0,
+    0x038a, 0x0358, 0x0328, 0x02fa, 0x02d0, 0x02a6, 0x0280, 0x025c,
+    0x023a, 0x021a, 0x01fc, 0x01e0, 0x01c5, 0x01ac, 0x0194, 0x017d,
+    0x0168, 0x0153, 0x0140, 0x012e, 0x011d, 0x010d, 0x00fe, 0x00f0,
+    0x00e2, 0x00d6, 0x00ca, 0x00be, 0x00b4, 0x00aa, 0x00a0, 0x0097,
+    0x008f, 0x0087, 0x007f, 0x0078, 0x0071,
+}
+
+var vibratoTable = []int{
+    0, 24, 49, 74, 97, 120, 141, 161, 180, 197, 212, 224, 235, 244, 250, 253,
+    255, 253, 250, 244, 235, 224, 212, 197, 180, 161, 141, 120, 97, 74, 49, 24,
+    0, -24, -49, -74, -97, -120, -141, -161, -180, -197, -212, -224, -235, -244, -250, -253,
+    -255, -253, -250, -244, -235, -224, -212, -197, -180, -161, -141, -120, -97, -74, -49, -24,
+}
+
+const (
+    WaveformTriangle = 0
+    WaveformSawtooth = 1
+    WaveformSquare = 2
+    WaveformNoise = 3
+)
+
+// the envelope as it plays, where the volumes are the change per frame in 1/256ths
+type envelopeState struct {
+    attackFrames int
+    attackVolume int
+    decayFrames int
+    decayVolume int
+    sustainFrames int
+    releaseFrames int
+    releaseVolume int
+}
+
+type Channel struct {
+    Player *Player
+    AudioBuffer *common.AudioBuffer
+    ScopeBuffer *common.AudioBuffer
+    Channel int
+    Volume float32
+    buffer []float32 // used for reading audio data
+    Mute bool
+
+    Pan int // 0-255, 0 is left and 255 is right
+    // the pan that a new instrument goes back to
+    SetPan int
+
+    Instrument *Instrument
+    Track int
+    Transpose int
+    NextTrack int
+
+    // the note of the track and of the performance list
+    TrackPeriod int
+    InstrumentPeriod int
+    FixedNote bool
+
+    envelope envelopeState
+    // the volume of the envelope in 1/256ths
+    EnvelopeVolume int
+    NoteMaxVolume int
+    PerformanceSubVolume int
+    TrackMasterVolume int
+    VolumeSlideUp int
+    VolumeSlideDown int
+
+    // E0x and the hard cut of the instrument stop the note early
+    NoteCutOn bool
+    NoteCutWait int
+    HardCut int
+    HardCutRelease bool
+    HardCutReleaseFrames int
+
+    NoteDelayOn bool
+    NoteDelayWait int
+
+    PeriodSlideOn bool
+    PeriodSlideSpeed int
+    PeriodSlidePeriod int
+    PeriodSlideLimit int
+    PeriodSlideWithLimit bool
+
+    PeriodPerformanceSlideOn bool
+    PeriodPerformanceSlideSpeed int
+    PeriodPerformanceSlidePeriod int
+
+    VibratoDelay int
+    VibratoDepth int
+    VibratoSpeed int
+    VibratoCurrent int
+    VibratoPeriod int
+
+    // the performance list of the instrument
+    PerformanceCurrent int
+    PerformanceSpeed int
+    PerformanceWait int
+
+    Waveform int
+    WaveLength int
+    newWaveform bool
+    plantPeriod bool
+
+    // square modulation moves the width of the square wave between the limits
+    SquareOn bool
+    SquareInit bool
+    SquareWait int
+    SquareLowerLimit int
+    SquareUpperLimit int
+    SquarePosition int
+    SquareSign int
+    SquareSlidingIn bool
+    IgnoreSquare bool
+    plantSquare bool
+    squareBuffer []int8
+
+    // the filter sweep moves the filter position between the limits
+    FilterOn bool
+    FilterInit bool
+    FilterWait int
+    FilterSpeed int
+    FilterLowerLimit int
+    FilterUpperLimit int
+    FilterPosition int
+    FilterSign int
+    FilterSlidingIn bool
+    IgnoreFilter int
+
+    noiseRandom uint32
+    // the waveform that the voice buffer is filled from
+    audioSource []int8
+    AudioPeriod int
+    AudioVolume int
+
+    // what the mixer plays, the waveform repeated to fill the buffer
+    voiceBuffer []int8
+    voiceVolume int
+    samplePosition float64
+    delta float64
+}
+
+func (channel *Channel) reset() {
+    channel.TrackMasterVolume = 0x40
+    channel.noiseRandom = 0x280
+    channel.squareBuffer = make([]int8, 0x80)
+    channel.voiceBuffer = make([]int8, voiceBufferLength + 1)
+    channel.audioSource = channel.squareBuffer
+    channel.FilterPosition = unfilteredPosition
+}
+
+// the step that the channel plays on the given row of the current position
+func (channel *Channel) getStep(row int) *Step {
+    player := channel.Player
+    track := player.File.Positions[player.CurrentOrder].Tracks[channel.Channel]
+    return &player.File.Tracks[track][row]
+}
+
+// effects that happen before the instrument of the step starts
+func (channel *Channel) stepEffect1(effect int, parameter int) {
+    player := channel.Player
+
+    switch effect {
+        case 0x0:
+            // the high digits of the next position jump
+            if parameter & 0xf > 0 && parameter & 0xf <= 9 {
+                player.positionJump = parameter & 0xf
+            }
+        case 0x5, 0xa:
+            channel.VolumeSlideDown = parameter & 0xf
+            channel.VolumeSlideUp = parameter >> 4
+        case 0x7:
+            channel.Pan = int(int8(parameter)) + 128
+            channel.SetPan = channel.Pan
+        case 0xb:
+            player.positionJump = player.positionJump * 100 + parameter & 0xf + (parameter >> 4) * 10
+            player.patternBreak = true
+            if player.positionJump <= player.CurrentOrder {
+                player.SongLooped = true
+            }
+        case 0xd:
+            player.positionJump = player.CurrentOrder + 1
+            player.positionJumpRow = parameter & 0xf + (parameter >> 4) * 10
+            player.patternBreak = true
+            if player.positionJumpRow >= player.File.TrackLength {
+                player.positionJumpRow = 0
+            }
+        case 0xe:
+            // ECx cuts the note
+            if parameter >> 4 == 0xc && parameter & 0xf < player.Speed {
+                channel.NoteCutWait = parameter & 0xf
+                if channel.NoteCutWait > 0 {
+                    channel.NoteCutOn = true
+                    channel.HardCutRelease = false
+                }
+            }
+        case 0xf:
+            player.Speed = parameter
+            if parameter == 0 {
+                player.SongLooped = true
+            }
+            if player.OnChangeSpeed != nil {
+                player.OnChangeSpeed(player.Speed, player.GetBPM())
+            }
+    }
+}
+
+// effects that change the note of the step, returning the note
+func (channel *Channel) stepEffect2(effect int, parameter int, note int) int {
+    switch effect {
+        case 0x9:
+            channel.SquarePosition = parameter >> (5 - channel.WaveLength)
+            channel.IgnoreSquare = true
+        case 0x3, 0x5:
+            if effect == 0x3 && parameter != 0 {
+                channel.PeriodSlideSpeed = parameter
+            }
+
+            // slide from the current note to the note of the step
+            if note > 0 {
+                difference := periods[channel.TrackPeriod] - periods[note]
+                if difference + channel.PeriodSlidePeriod != 0 {
+                    channel.PeriodSlideLimit = -difference
+                }
+            }
+
+            channel.PeriodSlideOn = true
+            channel.PeriodSlideWithLimit = true
+            note = 0
+    }
+
+    return note
+}
+
+// effects that happen after the note of the step starts
+func (channel *Channel) stepEffect3(effect int, parameter int) {
+    switch effect {
+        case 0x1:
+            channel.PeriodSlideSpeed = -parameter
+            channel.PeriodSlideOn = true
+            channel.PeriodSlideWithLimit = false
+        case 0x2:
+            channel.PeriodSlideSpeed = parameter
+            channel.PeriodSlideOn = true
+            channel.PeriodSlideWithLimit = false
+        case 0x4:
+            // override the filter position of the performance list
+            if parameter == 0 || parameter == 0x40 || parameter > 0x7f {
+                break
+            }
+            if parameter < 0x40 {
+                channel.IgnoreFilter = parameter
+            } else {
+                channel.FilterPosition = parameter - 0x40
+            }
+        case 0xc:
+            channel.setVolume(parameter, true)
+        case 0xe:
+            value := parameter & 0xf
+            switch parameter >> 4 {
+                case 0x1:
+                    channel.PeriodSlidePeriod -= value
+                    channel.plantPeriod = true
+                case 0x2:
+                    channel.PeriodSlidePeriod += value
+                    channel.plantPeriod = true
+                case 0x4:
+                    channel.VibratoDepth = value
+                case 0xa:
+                    channel.NoteMaxVolume = min(channel.NoteMaxVolume + value, 0x40)
+                case 0xb:
+                    channel.NoteMaxVolume = max(channel.NoteMaxVolume - value, 0)
+            }
+    }
+}
+
+// 0-0x40 sets the volume of the note, 0x50-0x90 sets the volume of every track or of the
+// performance list, and 0xa0-0xe0 sets the volume of this track
+func (channel *Channel) setVolume(parameter int, step bool) {
+    switch {
+        case parameter <= 0x40:
+            channel.NoteMaxVolume = parameter
+        case parameter >= 0x50 && parameter <= 0x90:
+            if step {
+                for _, other := range channel.Player.Channels {
+                    other.TrackMasterVolume = parameter - 0x50
+                }
+            } else {
+                channel.PerformanceSubVolume = parameter - 0x50
+            }
+        case parameter >= 0xa0 && parameter <= 0xe0:
+            channel.TrackMasterVolume = parameter - 0xa0
+    }
+}
+
+// start playing the given instrument
+func (channel *Channel) startInstrument(instrument *Instrument) {
+    channel.Pan = channel.SetPan
+
+    channel.PeriodSlideSpeed = 0
+    channel.PeriodSlidePeriod = 0
+    channel.PeriodSlideLimit = 0
+
+    channel.PerformanceSubVolume = 0x40
+    channel.EnvelopeVolume = 0
+    channel.Instrument = instrument
+    channel.samplePosition = 0
+
+    // the envelope moves by a fixed amount each frame to reach each volume in time
+    envelope := instrument.Envelope
+    slope := func(frames int, from int, to int) int {
+        if frames == 0 {
+            return to * 256
+        }
+        return (to - from) * 256 / frames
+    }
+
+    channel.envelope = envelopeState{
+        attackFrames: envelope.AttackFrames,
+        attackVolume: slope(envelope.AttackFrames, 0, envelope.AttackVolume),
+        decayFrames: envelope.DecayFrames,
+        decayVolume: slope(envelope.DecayFrames, envelope.AttackVolume, envelope.DecayVolume),
+        sustainFrames: envelope.SustainFrames,
+        releaseFrames: envelope.ReleaseFrames,
+        releaseVolume: slope(envelope.ReleaseFrames, envelope.DecayVolume, envelope.ReleaseVolume),
+    }
+
+    channel.WaveLength = instrument.WaveLength
+    channel.NoteMaxVolume = instrument.Volume
+
+    channel.VibratoCurrent = 0
+    channel.VibratoDelay = instrument.VibratoDelay
+    channel.VibratoDepth = instrument.VibratoDepth
+    channel.VibratoSpeed = instrument.VibratoSpeed
+    channel.VibratoPeriod = 0
+
+    channel.HardCutRelease = instrument.HardCutRelease
+    channel.HardCut = instrument.HardCutReleaseFrames
+
+    channel.IgnoreSquare = false
+    channel.SquareSlidingIn = false
+    channel.SquareWait = 0
+    channel.SquareOn = false
+
+    // the limits are for 128 sample waves, so shorter waves have smaller limits
+    shift := 5 - channel.WaveLength
+    channel.SquareLowerLimit = min(instrument.SquareLowerLimit, instrument.SquareUpperLimit) >> shift
+    channel.SquareUpperLimit = max(instrument.SquareLowerLimit, instrument.SquareUpperLimit) >> shift
+
+    channel.IgnoreFilter = 0
+    channel.FilterWait = 0
+    channel.FilterOn = false
+    channel.FilterSlidingIn = false
+    channel.FilterSpeed = instrument.FilterSpeed
+    channel.FilterLowerLimit = min(instrument.FilterLowerLimit, instrument.FilterUpperLimit)
+    channel.FilterUpperLimit = max(instrument.FilterLowerLimit, instrument.FilterUpperLimit)
+    channel.FilterPosition = unfilteredPosition
+
+    channel.PerformanceWait = 0
+    channel.PerformanceCurrent = 0
+    channel.PerformanceSpeed = instrument.PerformanceSpeed
+}
+
+// start the step of the current row
+func (channel *Channel) processStep() {
+    player := channel.Player
+
+    channel.VolumeSlideUp = 0
+    channel.VolumeSlideDown = 0
+
+    step := channel.getStep(player.CurrentRow)
+    note := step.Note
+
+    type effect struct {
+        number int
+        parameter int
+    }
+
+    effects := []effect{{step.Effect, step.EffectParameter}}
+    if step.Hively {
+        effects = append(effects, effect{step.Effect2, step.Effect2Parameter})
+    }
+
+    // EDx delays the whole step by x frames
+    for _, effect := range effects {
+        if effect.number == 0xe && effect.parameter & 0xf0 == 0xd0 {
+            if channel.NoteDelayOn {
+                // the delay is over, so play the step now
+                channel.NoteDelayOn = false
+                break
+            }
+
+            if effect.parameter & 0xf < player.Speed {
+                channel.NoteDelayWait = effect.parameter & 0xf
+                if channel.NoteDelayWait > 0 {
+                    channel.NoteDelayOn = true
+                    return
+                }
+            }
+        }
+    }
+
+    for _, effect := range effects {
+        channel.stepEffect1(effect.number, effect.parameter)
+    }
+
+    if step.Instrument > 0 && step.Instrument < len(player.File.Instruments) {
+        channel.startInstrument(&player.File.Instruments[step.Instrument])
+    }
+
+    channel.PeriodSlideOn = false
+
+    for _, effect := range effects {
+        note = channel.stepEffect2(effect.number, effect.parameter, note)
+    }
+
+    if note > 0 {
+        channel.TrackPeriod = note
+        channel.plantPeriod = true
+    }
+
+    for _, effect := range effects {
+        channel.stepEffect3(effect.number, effect.parameter)
+    }
+}
+
+// a command from the performance list of the instrument
+func (channel *Channel) performanceCommand(command int, parameter int) {
+    switch command {
+        case 0:
+            // set the filter position
+            if parameter > 0 && parameter < 0x40 {
+                if channel.IgnoreFilter > 0 {
+                    channel.FilterPosition = channel.IgnoreFilter
+                    channel.IgnoreFilter = 0
+                } else {
+                    channel.FilterPosition = parameter
+                }
+                channel.newWaveform = true
+            }
+        case 1:
+            channel.PeriodPerformanceSlideSpeed = parameter
+            channel.PeriodPerformanceSlideOn = true
+        case 2:
+            channel.PeriodPerformanceSlideSpeed = -parameter
+            channel.PeriodPerformanceSlideOn = true
+        case 3:
+            // set the width of the square wave, unless the step already did
+            if !channel.IgnoreSquare {
+                channel.SquarePosition = parameter >> (5 - channel.WaveLength)
+            } else {
+                channel.IgnoreSquare = false
+            }
+        case 4:
+            // turn the square modulation and the filter sweep on and off, where f in a nibble
+            // starts the sweep going down
+            if parameter == 0 || parameter & 0xf > 0 {
+                channel.SquareOn = !channel.SquareOn
+                channel.SquareInit = channel.SquareOn
+                channel.SquareSign = 1
+                if parameter & 0xf == 0xf {
+                    channel.SquareSign = -1
+                }
+            }
+
+            if parameter & 0xf0 > 0 {
+                channel.FilterOn = !channel.FilterOn
+                channel.FilterInit = channel.FilterOn
+                channel.FilterSign = 1
+                if parameter & 0xf0 == 0xf0 {
+                    channel.FilterSign = -1
+                }
+            }
+        case 5:
+            // jump to a line of the performance list
+            channel.PerformanceCurrent = parameter
+        case 9:
+            channel.Pan = int(int8(parameter)) + 128
+        case 12:
+            channel.setVolume(parameter, false)
+        case 15:
+            channel.PerformanceSpeed = parameter
+            channel.PerformanceWait = parameter
+        // hively tracker's ring modulation, commands 6 and 7, is not played
+    }
+}
+
+// move a sweep one step towards its limit, turning around at the limits
+func sweep(position int, lower int, upper int, sign *int, slidingIn *bool) int {
+    if position == lower || position == upper {
+        if *slidingIn {
+            *slidingIn = false
+        } else {
+            *sign = -*sign
+        }
+    }
+
+    return position + *sign
+}
+
+// a sweep that starts outside of its limits moves into them before it turns around
+func startSweep(position int, lower int, upper int, sign *int, slidingIn *bool) {
+    if position <= lower {
+        *slidingIn = true
+        *sign = 1
+    } else if position >= upper {
+        *slidingIn = true
+        *sign = -1
+    }
+}
+
+// update the instrument for one frame
+func (channel *Channel) processFrame() {
+    player := channel.Player
+
+    if channel.NoteDelayOn {
+        if channel.NoteDelayWait <= 0 {
+            channel.processStep()
+        } else {
+            channel.NoteDelayWait -= 1
+        }
+    }
+
+    // the hard cut stops the note a few frames before the next step with an instrument
+    if channel.HardCut > 0 {
+        var next *Step
+        if player.CurrentRow + 1 < player.File.TrackLength {
+            next = channel.getStep(player.CurrentRow + 1)
+        } else {
+            next = &player.File.Tracks[channel.NextTrack][0]
+        }
+
+        if next.Instrument > 0 {
+            wait := max(0, player.Speed - channel.HardCut)
+
+            if !channel.NoteCutOn {
+                channel.NoteCutOn = true
+                channel.NoteCutWait = wait
+                channel.HardCutReleaseFrames = player.Speed - wait
+            } else {
+                channel.HardCut = 0
+            }
+        }
+    }
+
+    if channel.NoteCutOn {
+        if channel.NoteCutWait <= 0 {
+            channel.NoteCutOn = false
+
+            if channel.HardCutRelease && channel.Instrument != nil && channel.HardCutReleaseFrames > 0 {
+                // fade out to the release volume
+                channel.envelope.releaseVolume = -(channel.EnvelopeVolume - channel.Instrument.Envelope.ReleaseVolume << 8) / channel.HardCutReleaseFrames
+                channel.envelope.releaseFrames = channel.HardCutReleaseFrames
+                channel.envelope.attackFrames = 0
+                channel.envelope.decayFrames = 0
+                channel.envelope.sustainFrames = 0
+            } else {
+                channel.NoteMaxVolume = 0
+            }
+        } else {
+            channel.NoteCutWait -= 1
+        }
+    }
+
+    if channel.Instrument != nil {
+        envelope := &channel.envelope
+        target := channel.Instrument.Envelope
+
+        if envelope.attackFrames > 0 {
+            channel.EnvelopeVolume += envelope.attackVolume
+            envelope.attackFrames -= 1
+            if envelope.attackFrames <= 0 {
+                channel.EnvelopeVolume = target.AttackVolume << 8
+            }
+        } else if envelope.decayFrames > 0 {
+            channel.EnvelopeVolume += envelope.decayVolume
+            envelope.decayFrames -= 1
+            if envelope.decayFrames <= 0 {
+                channel.EnvelopeVolume = target.DecayVolume << 8
+            }
+        } else if envelope.sustainFrames > 0 {
+            envelope.sustainFrames -= 1
+        } else if envelope.releaseFrames > 0 {
+            channel.EnvelopeVolume += envelope.releaseVolume
+            envelope.releaseFrames -= 1
+            if envelope.releaseFrames <= 0 {
+                channel.EnvelopeVolume = target.ReleaseVolume << 8
+            }
+        }
+    }
+
+    channel.NoteMaxVolume = max(0, min(channel.NoteMaxVolume + channel.VolumeSlideUp - channel.VolumeSlideDown, 0x40))
+
+    if channel.PeriodSlideOn {
+        if channel.PeriodSlideWithLimit {
+            // slide towards the limit without going past it
+            distance := channel.PeriodSlidePeriod - channel.PeriodSlideLimit
+            speed := channel.PeriodSlideSpeed
+            if distance > 0 {
+                speed = -speed
+            }
+
+            if distance != 0 {
+                if (distance + speed) ^ distance >= 0 {
+                    channel.PeriodSlidePeriod += speed
+                } else {
+                    channel.PeriodSlidePeriod = channel.PeriodSlideLimit
+                }
+                channel.plantPeriod = true
+            }
+        } else {
+            channel.PeriodSlidePeriod += channel.PeriodSlideSpeed
+            channel.plantPeriod = true
+        }
+    }
+
+    if channel.VibratoDepth > 0 {
+        if channel.VibratoDelay <= 0 {
+            channel.VibratoPeriod = vibratoTable[channel.VibratoCurrent] * channel.VibratoDepth >> 7
+            channel.plantPeriod = true
+            channel.VibratoCurrent = (channel.VibratoCurrent + channel.VibratoSpeed) & 0x3f
+        } else {
+            channel.VibratoDelay -= 1
+        }
+    }
+
+    if channel.Instrument != nil {
+        performance := channel.Instrument.Performance
+
+        if channel.PerformanceCurrent < len(performance) {
+            channel.PerformanceWait -= 1
+            if channel.PerformanceWait <= 0 {
+                entry := performance[channel.PerformanceCurrent]
+                channel.PerformanceCurrent += 1
+                channel.PerformanceWait = channel.PerformanceSpeed
+
+                if entry.Waveform > 0 {
+                    channel.Waveform = entry.Waveform - 1
+                    channel.newWaveform = true
+                    channel.PeriodPerformanceSlideSpeed = 0
+                    channel.PeriodPerformanceSlidePeriod = 0
+                }
+
+                channel.PeriodPerformanceSlideOn = false
+
+                for i := range 2 {
+                    channel.performanceCommand(entry.Commands[i], entry.Parameters[i])
+                }
+
+                if entry.Note > 0 {
+                    channel.InstrumentPeriod = entry.Note
+                    channel.plantPeriod = true
+                    channel.FixedNote = entry.Fixed
+                }
+            }
+        } else {
+            if channel.PerformanceWait > 0 {
+                channel.PerformanceWait -= 1
+            } else {
+                channel.PeriodPerformanceSlideSpeed = 0
+            }
+        }
+    }
+
+    if channel.PeriodPerformanceSlideOn {
+        channel.PeriodPerformanceSlidePeriod -= channel.PeriodPerformanceSlideSpeed
+        if channel.PeriodPerformanceSlidePeriod != 0 {
+            channel.plantPeriod = true
+        }
+    }
+
+    if channel.Waveform == WaveformSquare && channel.SquareOn && channel.Instrument != nil {
+        channel.SquareWait -= 1
+        if channel.SquareWait <= 0 {
+            if channel.SquareInit {
+                channel.SquareInit = false
+                startSweep(channel.SquarePosition, channel.SquareLowerLimit, channel.SquareUpperLimit, &channel.SquareSign, &channel.SquareSlidingIn)
+            }
+
+            channel.SquarePosition = sweep(channel.SquarePosition, channel.SquareLowerLimit, channel.SquareUpperLimit, &channel.SquareSign, &channel.SquareSlidingIn)
+            channel.plantSquare = true
+            channel.SquareWait = channel.Instrument.SquareSpeed
+        }
+    }
+
+    if channel.FilterOn {
+        channel.FilterWait -= 1
+        if channel.FilterWait <= 0 {
+            if channel.FilterInit {
+                channel.FilterInit = false
+                startSweep(channel.FilterPosition, channel.FilterLowerLimit, channel.FilterUpperLimit, &channel.FilterSign, &channel.FilterSlidingIn)
+            }
+
+            // the slowest speeds move once every few frames, the fastest move several times a frame
+            steps := 1
+            if channel.FilterSpeed < 3 {
+                steps = 5 - channel.FilterSpeed
+            }
+
+            position := channel.FilterPosition
+            for range steps {
+                position = sweep(position, channel.FilterLowerLimit, channel.FilterUpperLimit, &channel.FilterSign, &channel.FilterSlidingIn)
+            }
+
+            channel.FilterPosition = max(1, min(position, filterPositions))
+            channel.newWaveform = true
+            channel.FilterWait = max(1, channel.FilterSpeed - 3)
+        }
+    }
+
+    if channel.Waveform == WaveformSquare || channel.plantSquare {
+        channel.makeSquare()
+    }
+
+    if channel.Waveform == WaveformNoise {
+        channel.newWaveform = true
+    }
+
+    if channel.newWaveform {
+        set := filterSet(channel.FilterPosition)
+
+        switch channel.Waveform {
+            case WaveformTriangle:
+                channel.audioSource = waves[set + triangleOffset + waveLengthOffsets[channel.WaveLength]:]
+            case WaveformSawtooth:
+                channel.audioSource = waves[set + sawtoothOffset + waveLengthOffsets[channel.WaveLength]:]
+            case WaveformSquare:
+                channel.audioSource = channel.squareBuffer
+            case WaveformNoise:
+                // start somewhere random in the noise so that it doesn't repeat
+                offset := int(channel.noiseRandom & (2 * voiceBufferLength - 1)) &^ 1
+                channel.audioSource = waves[set + noiseOffset + offset:]
+
+                channel.noiseRandom += 2239384
+                channel.noiseRandom = ((channel.noiseRandom >> 8 | channel.noiseRandom << 24) + 782323) ^ 75
+                channel.noiseRandom -= 6735
+        }
+    }
+
+    // the note of the performance list is relative to the note of the track unless it is fixed
+    note := channel.InstrumentPeriod
+    if !channel.FixedNote {
+        note += channel.Transpose + channel.TrackPeriod - 1
+    }
+    note = max(0, min(note, MaxNote))
+
+    period := periods[note]
+    if !channel.FixedNote {
+        period += channel.PeriodSlidePeriod
+    }
+    period += channel.PeriodPerformanceSlidePeriod + channel.VibratoPeriod
+
+    channel.AudioPeriod = max(0x71, min(period, 0xd60))
+
+    channel.AudioVolume = ((channel.EnvelopeVolume >> 8) * channel.NoteMaxVolume >> 6) * channel.PerformanceSubVolume >> 6 * channel.TrackMasterVolume >> 6
+}
+
+// pick the square wave with the width of the square position, taking every few samples of
+// it to make a wave of the instrument's length
+func (channel *Channel) makeSquare() {
+    width := channel.SquarePosition << (5 - channel.WaveLength)
+    if width > 0x20 {
+        width = 0x40 - width
+    }
+
+    start := filterSet(channel.FilterPosition) + squareOffset
+    if width > 0 {
+        start += (width - 1) << 7
+    }
+
+    step := 32 >> channel.WaveLength
+    for i := range 4 << channel.WaveLength {
+        channel.squareBuffer[i] = waves[start + i * step]
+    }
+
+    channel.newWaveform = true
+    channel.Waveform = WaveformSquare
+    channel.plantSquare = false
+}
+
+// give the mixer the period, volume and waveform of this frame
+func (channel *Channel) setAudio() {
+    channel.voiceVolume = channel.AudioVolume
+
+    if channel.plantPeriod {
+        channel.plantPeriod = false
+        channel.delta = amigaClock / float64(channel.AudioPeriod) / float64(channel.Player.SampleRate)
+    }
+
+    if channel.newWaveform {
+        channel.newWaveform = false
+
+        if channel.Waveform == WaveformNoise {
+            copy(channel.voiceBuffer, channel.audioSource[:voiceBufferLength])
+        } else {
+            length := 4 << channel.WaveLength
+            for i := 0; i < voiceBufferLength; i += length {
+                copy(channel.voiceBuffer[i:], channel.audioSource[:length])
+            }
+        }
+
+        channel.voiceBuffer[voiceBufferLength] = channel.voiceBuffer[0]
+    }
+}
+
+func (channel *Channel) Update(rate float32) {
+    samples := int(float32(channel.Player.SampleRate) * rate)
+
+    channel.AudioBuffer.Lock()
+    channel.ScopeBuffer.Lock()
+
+    volume := channel.Volume * float32(channel.voiceVolume) / 64 * float32(channel.Player.File.MixGain) / 100
+    leftPan, rightPan := channel.Player.PanLaw.Gains(float32(channel.Pan) / 255)
+
+    for range samples {
+        value := float32(channel.voiceBuffer[int(channel.samplePosition)]) / 128 * volume
+
+        channel.AudioBuffer.UnsafeWrite(value * leftPan)
+        channel.AudioBuffer.UnsafeWrite(value * rightPan)
+        channel.ScopeBuffer.UnsafeWrite(value * leftPan)
+        channel.ScopeBuffer.UnsafeWrite(value * rightPan)
+
+        channel.samplePosition += channel.delta
+        for channel.samplePosition >= voiceBufferLength {
+            channel.samplePosition -= voiceBufferLength
+        }
+    }
+
+    channel.AudioBuffer.Unlock()
+    channel.ScopeBuffer.Unlock()
+}
+
+func (channel *Channel) Read(data []byte) (int, error) {
+    if channel.Mute {
+        for i := 0; i < len(data); i++ {
+            data[i] = 0
+        }
+        channel.AudioBuffer.Clear()
+        return len(data), nil
+    }
+
+    samples := len(data) / 4
+
+    if samples > len(channel.buffer) {
+        samples = len(channel.buffer)
+    }
+
+    part := channel.buffer[:samples]
+    floatSamples := channel.AudioBuffer.Read(part)
+
+    i := 0
+    for sampleIndex := range floatSamples {
+        value := part[sampleIndex]
+        bits := math.Float32bits(value)
+        data[i*4+0] = byte(bits)
+        data[i*4+1] = byte(bits >> 8)
+        data[i*4+2] = byte(bits >> 16)
+        data[i*4+3] = byte(bits >> 24)
+
+        i += 1
+    }
+
+    i *= 4
+
+    // in a browser we have to return something, so we generate some silence
+    if i == 0 && runtime.GOOS == "js" {
+        for i < 8 {
+            data[i] = 0
+            i += 1
+        }
+        return 8, nil
+    } else {
+        // on a normal os we can just return 0 if necessary
+        return floatSamples * 4, nil
+    }
+}
+
+type Player struct {
+    File *AHXFile
+    Channels []*Channel
+    SampleRate int
+
+    // the number of frames for each row
+    Speed int
+
+    CurrentRow int
+    CurrentOrder int
+    OrdersPlayed int
+    // true once the song reaches its end or jumps back to an earlier position
+    SongLooped bool
+
+    // frames until the next row
+    stepWait int
+    newPosition bool
+    patternBreak bool
+    positionJump int
+    positionJumpRow int
+
+    ticks float32
+
+    PanLaw common.PanLaw
+
+    OnChangeRow func(row int)
+    OnChangeOrder func(order int, pattern int)
+    OnChangeSpeed func(speed int, bpm int)
+}
+
+func MakePlayer(file *AHXFile, sampleRate int) *Player {
+    player := &Player{
+        File: file,
+        SampleRate: sampleRate,
+        Speed: 6,
+        newPosition: true,
+    }
+
+    for i := range file.Channels {
+        // the amiga plays channels 0 and 3 on the left and 1 and 2 on the right
+        pan := stereoRight[file.Stereo]
+        if i % 4 == 0 || i % 4 == 3 {
+            pan = stereoLeft[file.Stereo]
+        }
+
+        channel := &Channel{
+            Player: player,
+            Channel: i,
+            AudioBuffer: common.MakeAudioBuffer(sampleRate * 2),
+            ScopeBuffer: common.MakeAudioBuffer(sampleRate * 2 / 10),
+            Volume: 1.0,
+            Pan: pan,
+            SetPan: pan,
+            buffer: make([]float32, sampleRate),
+        }
+        channel.reset()
+
+        player.Channels = append(player.Channels, channel)
+    }
+
+    return player
+}
+
+// play one frame of the song, which starts a new row every Speed frames
+func (player *Player) playFrame() {
+    oldRow := player.CurrentRow
+    oldOrder := player.CurrentOrder
+
+    if player.stepWait <= 0 {
+        if player.newPosition {
+            next := player.CurrentOrder + 1
+            if next >= len(player.File.Positions) {
+                next = 0
+            }
+
+            for i, channel := range player.Channels {
+                channel.Track = player.File.Positions[player.CurrentOrder].Tracks[i]
+                channel.Transpose = player.File.Positions[player.CurrentOrder].Transposes[i]
+                channel.NextTrack = player.File.Positions[next].Tracks[i]
+            }
+
+            player.newPosition = false
+        }
+
+        for _, channel := range player.Channels {
+            channel.processStep()
+        }
+
+        player.stepWait = player.Speed
+    }
+
+    for _, channel := range player.Channels {
+        channel.processFrame()
+    }
+
+    if player.Speed > 0 {
+        player.stepWait -= 1
+        if player.stepWait <= 0 {
+            if !player.patternBreak {
+                player.CurrentRow += 1
+                if player.CurrentRow >= player.File.TrackLength {
+                    player.positionJump = player.CurrentOrder + 1
+                    player.positionJumpRow = 0
+                    player.patternBreak = true
+                }
+            }
+
+            if player.patternBreak {
+                player.patternBreak = false
+                player.CurrentOrder = player.positionJump
+                player.CurrentRow = player.positionJumpRow
+                if player.CurrentOrder >= len(player.File.Positions) {
+                    player.SongLooped = true
+                    player.CurrentOrder = player.File.Restart
+                }
+
+                player.positionJump = 0
+                player.positionJumpRow = 0
+                player.newPosition = true
+                player.OrdersPlayed += 1
+            }
+        }
+    }
+
+    for _, channel := range player.Channels {
+        channel.setAudio()
+    }
+
+    if player.CurrentOrder != oldOrder && player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+    }
+
+    if player.CurrentRow != oldRow && player.OnChangeRow != nil {
+        player.OnChangeRow(player.CurrentRow)
+    }
+}
+
+func (player *Player) Update(timeDelta float32) {
+    // a frame is played at the start of every tick
+    for player.ticks <= 0 {
+        player.playFrame()
+        player.ticks += 1
+    }
+
+    player.ticks -= timeDelta * float32(player.GetBPM()) * 2 / 5
+
+    for _, channel := range player.Channels {
+        channel.Update(timeDelta)
+    }
+}
+
+// each position plays its own set of tracks, so the position is shown as the pattern
+func (player *Player) GetPattern() int {
+    return player.CurrentOrder
+}
+
+func (player *Player) GetSongLength() int {
+    return len(player.File.Positions)
+}
+
+func (player *Player) GetRowNoteInfo(channel int, row int) (common.NoteInfo, bool) {
+    step, ok := player.GetRowStep(channel, row)
+    if !ok {
+        return nil, false
+    }
+    return step, true
+}
+
+func (player *Player) GetRowStep(channel int, row int) (*Step, bool) {
+    if channel < 0 || channel >= len(player.Channels) || row < 0 || row >= player.File.TrackLength {
+        return nil, false
+    }
+
+    return player.Channels[channel].getStep(row), true
+}
+
+func (player *Player) SetOnChangeRow(callback func(row int)) {
+    player.OnChangeRow = callback
+}
+
+func (player *Player) SetOnChangeOrder(callback func(order int, pattern int)) {
+    player.OnChangeOrder = callback
+}
+
+func (player *Player) SetOnChangeSpeed(callback func(speed int, bpm int)) {
+    player.OnChangeSpeed = callback
+}
+
+func (player *Player) GetChannelReaders() []io.Reader {
+    readers := make([]io.Reader, len(player.Channels))
+    for i, channel := range player.Channels {
+        readers[i] = channel
+    }
+    return readers
+}
+
+func (player *Player) ToggleMuteChannel(channel int) bool {
+    if channel < 0 || channel >= len(player.Channels) {
+        return false
+    }
+
+    player.Channels[channel].Mute = !player.Channels[channel].Mute
+    return player.Channels[channel].Mute
+}
+
+// start playing from the beginning of a position
+func (player *Player) setPosition(order int) {
+    player.CurrentOrder = order
+    player.CurrentRow = 0
+    player.stepWait = 0
+    player.newPosition = true
+    player.patternBreak = false
+
+    if player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+    }
+}
+
+func (player *Player) NextOrder() {
+    order := player.CurrentOrder + 1
+    if order >= len(player.File.Positions) {
+        order = 0
+    }
+
+    player.setPosition(order)
+}
+
+func (player *Player) PreviousOrder() {
+    player.setPosition(max(0, player.CurrentOrder - 1))
+}
+
+func (player *Player) GetSpeed() int {
+    return player.Speed
+}
+
+// ahx plays at 50 frames a second, or a multiple of it, which is 125 bpm
+func (player *Player) GetBPM() int {
+    return 125 * player.File.SpeedMultiplier
+}
+
+func (player *Player) GetChannelCount() int {
+    return len(player.Channels)
+}
+
+func (player *Player) GetName() string {
+    return player.File.Name
+}
+
+func (player *Player) IsStereo() bool {
+    return true
+}
+
+func (player *Player) GetChannelData(channel int, data []float32) int {
+    if channel < len(player.Channels) {
+        return player.Channels[channel].ScopeBuffer.Peek(data)
+    }
+
+    return 0
+}
+
+func (player *Player) ResetRow() {
+    player.CurrentRow = 0
+}
+
+func (player *Player) GetCurrentOrder() int {
+    return player.CurrentOrder
+}
+
+func (player *Player) RenderToPCM() io.Reader {
+    // make a buffer to hold 1/100th of a second of audio data, which is 4-bytes per sample
+    // and 1 samples per channel
+    rate := 100
+    buffer := make([]float32, player.SampleRate * 2 / rate)
+    mix := make([]float32, player.SampleRate * 2 / rate)
+
+    fillMix := func() bool {
+        if player.SongLooped {
+            return false
+        }
+
+        player.Update(1.0 / float32(rate))
+
+        for i := range mix {
+            mix[i] = 0
+        }
+
+        for _, channel := range player.Channels {
+            amount := channel.AudioBuffer.Read(buffer)
+
+            if amount > 0 {
+                // copy the samples into the mix buffer
+                for i := range amount {
+                    mix[i] = mix[i] + buffer[i]
+                }
+            }
+        }
+
+        for i := range mix {
+            mix[i] = max(min(mix[i], 1), -1)
+        }
+
+        return true
+    }
+
+    mixPosition := len(mix)
+    reader := func(data []byte) (int, error) {
+        if len(data) == 0 {
+            return 0, nil
+        }
+
+        if player.SongLooped {
+            return 0, io.EOF
+        }
+
+        // wait for the music to be produced
+        if mixPosition < len(mix) {
+            part := mix[mixPosition:]
+
+            amount := common.CopyFloat32(data, part)
+            mixPosition += amount
+            return amount * 4, nil
+        }
+
+        mixPosition = 0
+
+        more := fillMix()
+        if !more {
+            return 0, io.EOF
+        }
+
+        // copy the mix into the data buffer
+        amount := common.CopyFloat32(data, mix)
+        mixPosition += amount
+
+        return amount * 4, nil
+    }
+
+    return &common.ReaderFunc{
+        Func: reader,
+    }
+}
@@ -0,0 +1,192 @@
+package ahx
+
+import (
+    "math/bits"
+)
+
+// ahx doesn't filter the sound as it plays, instead every waveform is made ahead of time with
+// 31 strengths of a low pass filter and 31 strengths of a high pass filter. filter position 32 is
+// the unfiltered waveform, 1 to 31 are the low passes and 33 to 63 are the high passes
+
+const (
+    // the triangles and sawtooths are 4, 8, 16, 32, 64 and 128 samples long
+    triangleOffset = 0
+    sawtoothOffset = triangleOffset + 0xfc
+    // 32 square waves of 128 samples, each with a wider pulse than the last
+    squareOffset = sawtoothOffset + 0xfc
+    noiseOffset = squareOffset + 0x80 * 0x20
+    noiseLength = 0x280 * 3
+    // the size of the waveforms for one filter position
+    filterSetSize = noiseOffset + noiseLength
+
+    filterPositions = 63
+    unfilteredPosition = 32
+)
+
+// the start of each wave length in the triangles and sawtooths
+var waveLengthOffsets = []int{0x00, 0x04, 0x0c, 0x1c, 0x3c, 0x7c}
+
+var waves = makeWaves()
+
+// the start of the waveforms for a filter position
+func filterSet(position int) int {
+    position = max(1, min(position, filterPositions))
+    return (position - 1) * filterSetSize
+}
+
+func generateTriangle(buffer []int8, length int) {
+    quarter := length >> 2
+    step := 128 / quarter
+
+    position := 0
+    value := 0
+
+    // up from 0 to the peak
+    for range quarter {
+        buffer[position] = int8(value)
+        position += 1
+        value += step
+    }
+    buffer[position] = 0x7f
+    position += 1
+
+    // and back down to 0
+    if quarter != 1 {
+        value = 128
+        for range quarter - 1 {
+            value -= step
+            buffer[position] = int8(value)
+            position += 1
+        }
+    }
+
+    // the second half is the first half upside down
+    source := position - length >> 1
+    for range quarter * 2 {
+        value := buffer[source]
+        source += 1
+        if value == 0x7f {
+            value = -128
+        } else {
+            value = -value
+        }
+        buffer[position] = value
+        position += 1
+    }
+}
+
+func generateSawtooth(buffer []int8, length int) {
+    step := 256 / (length - 1)
+    value := -128
+
+    for i := range length {
+        buffer[i] = int8(value)
+        value += step
+    }
+}
+
+func generateSquares(buffer []int8) {
+    position := 0
+    for width := 1; width <= 0x20; width++ {
+        for range (0x40 - width) * 2 {
+            buffer[position] = -128
+            position += 1
+        }
+        for range width * 2 {
+            buffer[position] = 0x7f
+            position += 1
+        }
+    }
+}
+
+// the same noise that the amiga replayer makes, so songs sound the same every time
+func generateNoise(buffer []int8) {
+    var seed uint32 = 0x41595321
+
+    for i := range buffer {
+        value := int8(seed)
+        if seed & 0x100 != 0 {
+            value = -128
+            if int16(seed) >= 0 {
+                value = 0x7f
+            }
+        }
+
+        buffer[i] = value
+
+        seed = bits.RotateLeft32(seed, -5)
+        seed = seed & 0xffffff00 | (seed & 0xff) ^ 0x9a
+        low := uint16(seed)
+        seed = bits.RotateLeft32(seed, 2)
+        high := uint16(seed)
+        low += high
+        high ^= low
+        seed = seed & 0xffff0000 | uint32(high)
+        seed = bits.RotateLeft32(seed, -3)
+    }
+}
+
+func clip(value float64) float64 {
+    return max(-128, min(value, 127))
+}
+
+// filter every waveform of the unfiltered set with each of the 31 filter strengths
+func generateFilters(waves []int8) {
+    // the length of each waveform in the order they are stored
+    var lengths []int
+    for range 2 {
+        for _, length := range []int{4, 8, 16, 32, 64, 128} {
+            lengths = append(lengths, length)
+        }
+    }
+    for range 0x20 {
+        lengths = append(lengths, 0x80)
+    }
+    lengths = append(lengths, noiseLength)
+
+    unfiltered := waves[filterSet(unfilteredPosition):]
+
+    for strength := range 31 {
+        frequency := (8 + float64(strength) * 3) * 1.25 / 100
+
+        low := waves[filterSet(1 + strength):]
+        high := waves[filterSet(unfilteredPosition + 1 + strength):]
+
+        position := 0
+        for _, length := range lengths {
+            var highValue, middleValue, lowValue float64
+
+            // run the wave through the filter once to settle it, and then again to keep the output
+            for pass := range 2 {
+                for i := range length {
+                    highValue = clip(float64(unfiltered[position + i]) - middleValue - lowValue)
+                    middleValue = clip(middleValue + highValue * frequency)
+                    lowValue = clip(lowValue + middleValue * frequency)
+
+                    if pass == 1 {
+                        low[position + i] = int8(lowValue)
+                        high[position + i] = int8(highValue)
+                    }
+                }
+            }
+
+            position += length
+        }
+    }
+}
+
+func makeWaves() []int8 {
+    waves := make([]int8, filterSetSize * filterPositions)
+
+    unfiltered := waves[filterSet(unfilteredPosition):]
+    for i, offset := range waveLengthOffsets {
+        generateTriangle(unfiltered[triangleOffset + offset:], 4 << i)
+        generateSawtooth(unfiltered[sawtoothOffset + offset:], 4 << i)
+    }
+    generateSquares(unfiltered[squareOffset:])
+    generateNoise(unfiltered[noiseOffset:noiseOffset + noiseLength])
+
+    generateFilters(waves)
+
+    return waves
+}
@@ -0,0 +1,31 @@
+package common
+
+// remembers the places in a song that playback has reached, to tell when the song starts over.
+// a place is an order along with the row that playback entered it at, so a jump back into the
+// middle of an order that was played from the top is not mistaken for a loop
+type LoopDetector struct {
+    visited map[[2]int]bool
+}
+
+// playback starts on the first row of the first order
+func MakeLoopDetector() LoopDetector {
+    return LoopDetector{
+        visited: map[[2]int]bool{[2]int{0, 0}: true},
+    }
+}
+
+// record that playback entered the given order at the given row. returns true if it had
+// entered there before, which means the song is repeating itself
+func (detector *LoopDetector) Visit(order int, row int) bool {
+    if detector.visited == nil {
+        detector.visited = make(map[[2]int]bool)
+    }
+
+    place := [2]int{order, row}
+    if detector.visited[place] {
+        return true
+    }
+
+    detector.visited[place] = true
+    return false
+}
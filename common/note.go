@@ -4,5 +4,7 @@ type NoteInfo interface {
     GetNotePosition() int
     GetName() string
     GetSampleName() string
+    // the contents of the volume column, or an empty string if the format doesn't have one
+    GetVolumeName() string
     GetEffectName() string
 }
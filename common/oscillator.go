@@ -0,0 +1,68 @@
+package common
+
+import (
+    "math"
+    "math/rand/v2"
+)
+
+type Waveform int
+
+const (
+    WaveformSine Waveform = iota
+    WaveformRampDown
+    WaveformSquare
+    WaveformRandom
+)
+
+// the low frequency oscillator behind vibrato and tremolo. one cycle is 64 steps
+// and Speed steps are taken per tick
+type Oscillator struct {
+    Speed int
+    Depth int
+    Position int
+    Waveform Waveform
+    // if true then a new note does not restart the waveform
+    Continue bool
+    // the value of the random waveform, picked again each time the position moves
+    random float64
+}
+
+// set the waveform and retrigger mode from the parameter of a waveform control effect,
+// E4x/E7x in mod and xm, S3x/S4x in s3m. bits 0-1 are the waveform, bit 2 means continue
+func (oscillator *Oscillator) SetControl(value int) {
+    oscillator.Waveform = Waveform(value & 3)
+    oscillator.Continue = value & 4 != 0
+}
+
+// called when a new note is played
+func (oscillator *Oscillator) Retrigger() {
+    if !oscillator.Continue {
+        oscillator.Position = 0
+    }
+}
+
+func (oscillator *Oscillator) Update() {
+    oscillator.Position = (oscillator.Position + oscillator.Speed) & 63
+    if oscillator.Waveform == WaveformRandom {
+        oscillator.random = rand.Float64() * 2 - 1
+    }
+}
+
+// the value of the waveform at the current position, in the range -1 to 1
+func (oscillator *Oscillator) Value() float64 {
+    position := oscillator.Position & 63
+
+    switch oscillator.Waveform {
+        case WaveformRampDown:
+            return 1 - float64(position) / 32
+        case WaveformSquare:
+            if position < 32 {
+                return 1
+            }
+            return -1
+        case WaveformRandom:
+            return oscillator.random
+    }
+
+    return math.Sin(float64(position) * math.Pi * 2 / 64)
+}
@@ -0,0 +1,29 @@
+package common
+
+import (
+    "math"
+)
+
+// how a pan position is turned into left and right channel gains
+type PanLaw int
+
+const (
+    // gains add up to 1, so a centered channel plays at half volume on each side
+    PanLawLinear PanLaw = iota
+    // gains are sin/cos of the pan angle, so the total power is the same at every position.
+    // a centered channel plays at about 0.707 on each side
+    PanLawConstantPower
+)
+
+// pan is 0 for full left, 0.5 for center and 1 for full right
+func (law PanLaw) Gains(pan float32) (float32, float32) {
+    pan = max(0, min(1, pan))
+
+    switch law {
+        case PanLawConstantPower:
+            angle := float64(pan) * math.Pi / 2
+            return float32(math.Cos(angle)), float32(math.Sin(angle))
+    }
+
+    return 1 - pan, pan
+}
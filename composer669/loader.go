@@ -0,0 +1,286 @@
+package composer669
+
+import (
+    "io"
+    "bufio"
+    "bytes"
+    "fmt"
+    "log"
+    "encoding/binary"
+)
+
+const (
+    Channels = 8
+    Rows = 64
+    MaxSamples = 64
+    MaxPatterns = 128
+)
+
+const (
+    EffectPortamentoUp = 0
+    EffectPortamentoDown = 1
+    EffectTonePortamento = 2
+    // a slide up that only happens once
+    EffectFrequencyAdjust = 3
+    EffectVibrato = 4
+    EffectSetSpeed = 5
+    // the extended 669 format from unis adds balance and retrigger
+    EffectBalance = 6
+    EffectRetrigger = 7
+)
+
+type Note struct {
+    Note int // 0-63, 24 plays at the speed of the sample
+    HasNote bool
+    SampleNumber int // 1-64
+    Volume int // 0-15
+    HasVolume bool
+    Effect int
+    EffectParameter int // 0-15
+    HasEffect bool
+}
+
+func (note *Note) GetNotePosition() int {
+    if note.HasNote {
+        return note.Note + 36
+    }
+
+    return 0
+}
+
+func (note *Note) GetName() string {
+    if note.HasNote {
+        names := []string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+        return fmt.Sprintf("%v%v", names[note.Note % 12], note.Note / 12 + 3)
+    }
+
+    return "..."
+}
+
+func (note *Note) GetSampleName() string {
+    if note.HasNote {
+        return fmt.Sprintf("%02d", note.SampleNumber)
+    }
+
+    return ".."
+}
+
+func (note *Note) GetVolumeName() string {
+    if note.HasVolume {
+        return fmt.Sprintf("%02d", note.Volume)
+    }
+
+    return ".."
+}
+
+func (note *Note) GetEffectName() string {
+    if note.HasEffect {
+        return fmt.Sprintf("%c%02X", 'A' + note.Effect, note.EffectParameter)
+    }
+
+    return "..."
+}
+
+type Pattern struct {
+    Rows [][]Note
+    // the speed that the pattern starts with
+    Speed int
+    // the last row that is played
+    BreakRow int
+}
+
+type Sample struct {
+    Name string
+    Loop bool
+    LoopStart int
+    LoopEnd int
+    Data []float32
+}
+
+type File669 struct {
+    Name string
+    // three lines of 36 characters, the first is usually the name of the song
+    Message []string
+    // true for the extended format of unis 669
+    Extended bool
+    Samples []Sample
+    Patterns []Pattern
+    Orders []byte
+    // the order to continue from once the song reaches the end of the order list
+    RestartOrder int
+}
+
+func (file *File669) GetPattern(order int) *Pattern {
+    if order < 0 || order >= len(file.Orders) {
+        return nil
+    }
+
+    return &file.Patterns[file.Orders[order]]
+}
+
+// read a pattern of 64 rows of 8 notes, where each note is 3 bytes
+func readPattern(reader io.Reader) ([][]Note, error) {
+    data := make([]byte, Rows * Channels * 3)
+    _, err := io.ReadFull(reader, data)
+    if err != nil {
+        return nil, err
+    }
+
+    rows := make([][]Note, Rows)
+    for row := range rows {
+        rows[row] = make([]Note, Channels)
+        for channel := range Channels {
+            // nnnnnnss ssssvvvv eeeepppp
+            entry := data[(row * Channels + channel) * 3:]
+            note := &rows[row][channel]
+
+            switch entry[0] {
+                // only the volume
+                case 0xfe:
+                    note.HasVolume = true
+                    note.Volume = int(entry[1] & 0xf)
+                // nothing but the effect
+                case 0xff:
+                default:
+                    note.HasNote = true
+                    note.Note = int(entry[0] >> 2)
+                    note.SampleNumber = int(entry[0] & 3) << 4 | int(entry[1] >> 4) + 1
+                    note.HasVolume = true
+                    note.Volume = int(entry[1] & 0xf)
+            }
+
+            if entry[2] != 0xff {
+                note.HasEffect = true
+                note.Effect = int(entry[2] >> 4)
+                note.EffectParameter = int(entry[2] & 0xf)
+            }
+        }
+    }
+
+    return rows, nil
+}
+
+func Load(reader_ io.Reader, logger *log.Logger) (*File669, error) {
+    reader := bufio.NewReader(reader_)
+
+    header := make([]byte, 497)
+    _, err := io.ReadFull(reader, header)
+    if err != nil {
+        return nil, err
+    }
+
+    var file File669
+
+    switch string(header[:2]) {
+        case "if":
+        case "JN":
+            file.Extended = true
+        default:
+            return nil, fmt.Errorf("Not a 669 file, signature was %v", header[:2])
+    }
+
+    for line := range 3 {
+        text := bytes.TrimRight(header[2 + line * 36:2 + (line + 1) * 36], "\x00 ")
+        file.Message = append(file.Message, string(text))
+    }
+    file.Name = file.Message[0]
+
+    sampleCount := int(header[110])
+    patternCount := int(header[111])
+    file.RestartOrder = int(header[112])
+    orders := header[113:241]
+    tempos := header[241:369]
+    breaks := header[369:497]
+
+    logger.Printf("669 '%v' samples %v patterns %v restart %v extended %v", file.Name, sampleCount, patternCount, file.RestartOrder, file.Extended)
+
+    // the signature is short, so check that the rest of the header makes sense
+    if sampleCount > MaxSamples || patternCount == 0 || patternCount > MaxPatterns || file.RestartOrder >= 128 {
+        return nil, fmt.Errorf("Not a 669 file, invalid header")
+    }
+
+    for _, order := range orders {
+        if order == 0xff {
+            break
+        }
+        if int(order) >= patternCount {
+            return nil, fmt.Errorf("Order refers to missing pattern %v", order)
+        }
+        file.Orders = append(file.Orders, order)
+    }
+
+    if len(file.Orders) == 0 {
+        return nil, fmt.Errorf("Song has no orders")
+    }
+
+    if file.RestartOrder >= len(file.Orders) {
+        file.RestartOrder = 0
+    }
+
+    lengths := make([]int, sampleCount)
+
+    for i := range sampleCount {
+        data := make([]byte, 25)
+        _, err = io.ReadFull(reader, data)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading sample %v: %v", i, err)
+        }
+
+        lengths[i] = int(binary.LittleEndian.Uint32(data[13:]))
+        loopStart := int(binary.LittleEndian.Uint32(data[17:]))
+        loopEnd := int(binary.LittleEndian.Uint32(data[21:]))
+
+        sample := Sample{
+            Name: string(bytes.TrimRight(data[:13], "\x00")),
+        }
+
+        // samples that don't loop have a loop end past the end of the sample, usually 0xfffff
+        if loopEnd <= lengths[i] && loopStart < loopEnd {
+            sample.Loop = true
+            sample.LoopStart = loopStart
+            sample.LoopEnd = loopEnd
+        }
+
+        logger.Printf("Sample %v: Name='%v', Length=%v, LoopStart=%v, LoopEnd=%v", i, sample.Name, lengths[i], loopStart, loopEnd)
+
+        file.Samples = append(file.Samples, sample)
+    }
+
+    for i := range patternCount {
+        rows, err := readPattern(reader)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading pattern %v: %v", i, err)
+        }
+
+        if breaks[i] >= Rows {
+            return nil, fmt.Errorf("Invalid break row %v in pattern %v", breaks[i], i)
+        }
+
+        file.Patterns = append(file.Patterns, Pattern{
+            Rows: rows,
+            Speed: max(1, int(tempos[i])),
+            BreakRow: int(breaks[i]),
+        })
+    }
+
+    for i := range file.Samples {
+        // the length is 32 bits, so only what the file really has is read
+        data, err := io.ReadAll(io.LimitReader(reader, int64(lengths[i])))
+        if err != nil {
+            return nil, fmt.Errorf("Error reading data of sample %v: %v", i, err)
+        }
+        if len(data) < lengths[i] {
+            return nil, fmt.Errorf("Error reading data of sample %v: %v", i, io.ErrUnexpectedEOF)
+        }
+
+        // the samples are unsigned
+        floatData := make([]float32, len(data))
+        for j, value := range data {
+            floatData[j] = float32(int(value) - 128) / 128
+        }
+
+        file.Samples[i].Data = floatData
+    }
+
+    return &file, nil
+}
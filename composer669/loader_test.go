@@ -0,0 +1,90 @@
+package composer669
+
+import (
+    "testing"
+    "bytes"
+    "encoding/binary"
+    "io"
+    "log"
+)
+
+// a song with one sample of the given length and one pattern that plays it on the first
+// channel, followed by the given sample data
+func makeSong(sampleLength uint32, sampleData []byte) []byte {
+    header := make([]byte, 497)
+    copy(header, "if")
+    copy(header[2:], "test")
+    header[110] = 1
+    header[111] = 1
+    for i := range 128 {
+        header[113 + i] = 0xff
+    }
+    header[113] = 0
+    header[241] = 4
+    header[369] = 31
+
+    sample := make([]byte, 25)
+    copy(sample, "sample")
+    binary.LittleEndian.PutUint32(sample[13:], sampleLength)
+    binary.LittleEndian.PutUint32(sample[21:], 0xfffff)
+
+    pattern := make([]byte, Rows * Channels * 3)
+    for i := 0; i < len(pattern); i += 3 {
+        pattern[i] = 0xff
+        pattern[i + 2] = 0xff
+    }
+    // note 24 of sample 1 at volume 15, then an effect of frequency adjust 2
+    pattern[0] = 24 << 2
+    pattern[1] = 0x0f
+    pattern[2] = 0x32
+
+    song := append(header, sample...)
+    song = append(song, pattern...)
+    return append(song, sampleData...)
+}
+
+func TestLoad(test *testing.T) {
+    logger := log.New(io.Discard, "", 0)
+
+    data := makeSong(4, []byte{0x80, 0xc0, 0x40, 0xff})
+    file, err := Load(bytes.NewReader(data), logger)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    if file.Name != "test" || len(file.Orders) != 1 || len(file.Patterns) != 1 {
+        test.Fatalf("unexpected song '%v' orders %v patterns %v", file.Name, len(file.Orders), len(file.Patterns))
+    }
+
+    pattern := file.Patterns[0]
+    if pattern.Speed != 4 || pattern.BreakRow != 31 {
+        test.Errorf("unexpected speed %v and break row %v", pattern.Speed, pattern.BreakRow)
+    }
+
+    note := pattern.Rows[0][0]
+    if !note.HasNote || note.Note != 24 || note.SampleNumber != 1 || note.Volume != 15 || !note.HasEffect || note.Effect != EffectFrequencyAdjust || note.EffectParameter != 2 {
+        test.Errorf("unexpected note %+v", note)
+    }
+
+    if empty := pattern.Rows[63][7]; empty.HasNote || empty.HasVolume || empty.HasEffect {
+        test.Errorf("expected an empty note, got %+v", empty)
+    }
+
+    if sample := file.Samples[0].Data; len(sample) != 4 || sample[0] != 0 || sample[1] != 0.5 || sample[2] != -0.5 {
+        test.Errorf("unexpected sample data %v", sample)
+    }
+
+    // any file that is cut off is an error rather than a panic
+    for length := range len(data) {
+        _, err := Load(bytes.NewReader(data[:length]), logger)
+        if err == nil {
+            test.Errorf("expected an error for a file cut off at %v bytes", length)
+        }
+    }
+
+    // the length of a sample is not trusted, a huge one is an error instead of an allocation
+    _, err = Load(bytes.NewReader(makeSong(0xf0000000, []byte{1, 2, 3})), logger)
+    if err == nil {
+        test.Errorf("expected an error for a sample that is cut off")
+    }
+}
@@ -0,0 +1,608 @@
+package composer669
+
+import (
+    "io"
+    "log"
+    "math"
+    "runtime"
+
+    "github.com/kazzmir/tracker/common"
+)
+
+// composer 669 ticks at a fixed rate, only the number of ticks per row changes
+const BPM = 78
+
+// the period of a note is periodClock / frequency, which makes the middle note period 1712
+const periodClock = 14317056
+
+// the rate that the samples play at for the middle note, 24
+const middleSpeed = 8363
+
+// the period of a note, where note 24 plays the sample at its normal speed
+func notePeriod(note int) int {
+    frequency := middleSpeed * math.Pow(2, float64(note - 24) / 12)
+    return int(math.Round(periodClock / frequency))
+}
+
+type Channel struct {
+    Player *Player
+    AudioBuffer *common.AudioBuffer
+    ScopeBuffer *common.AudioBuffer
+    Channel int
+    Volume float32
+    buffer []float32 // used for reading audio data
+    Mute bool
+
+    Pan int // 0-255, 0 is left and 255 is right
+
+    CurrentSample int // -1 for no sample
+    CurrentPeriod int
+    CurrentVolume int // 0-64
+
+    // effects keep going on the following rows until the channel plays a new note or effect
+    CurrentEffect int
+    EffectParameter int
+    HasEffect bool
+
+    PortamentoTarget int
+    Vibrato common.Oscillator
+
+    // ticks since the start of the row
+    rowTick int
+
+    currentRow int
+    startPosition float32
+}
+
+func (channel *Channel) UpdateRow() {
+    channel.currentRow = channel.Player.CurrentRow
+    channel.rowTick = 0
+
+    note, ok := channel.Player.GetRowNote(channel.Channel, channel.currentRow)
+    if !ok {
+        return
+    }
+
+    if note.HasNote || note.HasEffect {
+        channel.HasEffect = false
+    }
+
+    if note.HasEffect {
+        channel.HasEffect = true
+        channel.CurrentEffect = note.Effect
+        channel.EffectParameter = note.EffectParameter
+    }
+
+    if note.HasVolume {
+        channel.CurrentVolume = (note.Volume * 64 + 8) / 15
+    }
+
+    if note.HasNote {
+        period := notePeriod(note.Note)
+
+        if channel.HasEffect && channel.CurrentEffect == EffectTonePortamento && channel.CurrentSample >= 0 && channel.CurrentPeriod > 0 {
+            // slide to the new note rather than playing it
+            channel.PortamentoTarget = period
+        } else {
+            channel.CurrentSample = note.SampleNumber - 1
+            channel.CurrentPeriod = period
+            channel.startPosition = 0
+            channel.Vibrato.Retrigger()
+        }
+    }
+
+    if !note.HasEffect {
+        return
+    }
+
+    parameter := channel.EffectParameter
+
+    switch channel.CurrentEffect {
+        case EffectPortamentoUp, EffectPortamentoDown, EffectTonePortamento:
+        case EffectFrequencyAdjust:
+            channel.CurrentPeriod = max(1, channel.CurrentPeriod - parameter)
+            channel.HasEffect = false
+        case EffectVibrato:
+            // the speed is always the same, the parameter is the depth
+            channel.Vibrato.Speed = 4
+            channel.Vibrato.Depth = parameter
+        case EffectSetSpeed:
+            if parameter > 0 {
+                channel.Player.Speed = parameter
+                if channel.Player.OnChangeSpeed != nil {
+                    channel.Player.OnChangeSpeed(channel.Player.Speed, channel.Player.BPM)
+                }
+            }
+            channel.HasEffect = false
+        case EffectBalance:
+            // 0 moves the channel to the left and 1 to the right
+            switch parameter {
+                case 0: channel.Pan = max(0, channel.Pan - 16)
+                case 1: channel.Pan = min(255, channel.Pan + 16)
+            }
+            channel.HasEffect = false
+        case EffectRetrigger:
+        default:
+            log.Printf("Channel %v unknown effect %v with parameter %v", channel.Channel, channel.CurrentEffect, parameter)
+            channel.HasEffect = false
+    }
+}
+
+// move the period towards the target of a tone portamento
+func (channel *Channel) doTonePortamento(amount int) {
+    if channel.PortamentoTarget == 0 {
+        return
+    }
+
+    if channel.CurrentPeriod < channel.PortamentoTarget {
+        channel.CurrentPeriod = min(channel.PortamentoTarget, channel.CurrentPeriod + amount)
+    } else if channel.CurrentPeriod > channel.PortamentoTarget {
+        channel.CurrentPeriod = max(channel.PortamentoTarget, channel.CurrentPeriod - amount)
+    }
+}
+
+func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
+    if !changeRow {
+        channel.rowTick += ticks
+    }
+
+    if !channel.HasEffect {
+        return
+    }
+
+    // the slides move the period by 4 per tick for each step of the parameter
+    amount := channel.EffectParameter * ticks * 4
+
+    switch channel.CurrentEffect {
+        case EffectPortamentoUp:
+            if !changeRow {
+                channel.CurrentPeriod = max(1, channel.CurrentPeriod - amount)
+            }
+        case EffectPortamentoDown:
+            if !changeRow {
+                channel.CurrentPeriod = min(0x7fff, channel.CurrentPeriod + amount)
+            }
+        case EffectTonePortamento:
+            if !changeRow {
+                channel.doTonePortamento(amount)
+            }
+        case EffectVibrato:
+            channel.Vibrato.Update()
+        case EffectRetrigger:
+            if !changeRow && channel.EffectParameter > 0 && channel.rowTick % channel.EffectParameter == 0 {
+                channel.startPosition = 0
+            }
+    }
+}
+
+// the playback rate of the sample for the current period, including vibrato
+func (channel *Channel) getFrequency() float32 {
+    period := channel.CurrentPeriod
+
+    if channel.HasEffect && channel.CurrentEffect == EffectVibrato {
+        period += int(float64(channel.Vibrato.Depth * 8) * channel.Vibrato.Value())
+    }
+
+    if period <= 0 {
+        return 0
+    }
+
+    return periodClock / float32(period)
+}
+
+func (channel *Channel) Update(rate float32) {
+    samples := int(float32(channel.Player.SampleRate) * rate)
+    samplesWritten := 0
+
+    channel.AudioBuffer.Lock()
+    channel.ScopeBuffer.Lock()
+
+    sample := channel.Player.GetSample(channel.CurrentSample)
+    if sample != nil && channel.CurrentPeriod > 0 {
+        incrementRate := channel.getFrequency() / float32(channel.Player.SampleRate)
+        volume := channel.Volume * float32(channel.CurrentVolume) / 64
+        leftPan, rightPan := channel.Player.PanLaw.Gains(float32(channel.Pan) / 255)
+
+        for range samples {
+            position := int(channel.startPosition)
+            if sample.Loop && position >= sample.LoopEnd {
+                channel.startPosition = float32(sample.LoopStart) + float32(math.Mod(float64(channel.startPosition) - float64(sample.LoopEnd), float64(sample.LoopEnd - sample.LoopStart)))
+                position = int(channel.startPosition)
+            }
+
+            if position >= len(sample.Data) {
+                break
+            }
+
+            value := sample.Data[position] * volume
+
+            channel.AudioBuffer.UnsafeWrite(value * leftPan)
+            channel.AudioBuffer.UnsafeWrite(value * rightPan)
+            channel.ScopeBuffer.UnsafeWrite(value * leftPan)
+            channel.ScopeBuffer.UnsafeWrite(value * rightPan)
+
+            channel.startPosition += incrementRate
+            samplesWritten += 1
+        }
+    }
+
+    for range (samples - samplesWritten) {
+        channel.AudioBuffer.UnsafeWrite(0.0)
+        channel.AudioBuffer.UnsafeWrite(0.0)
+        channel.ScopeBuffer.UnsafeWrite(0.0)
+        channel.ScopeBuffer.UnsafeWrite(0.0)
+    }
+
+    channel.AudioBuffer.Unlock()
+    channel.ScopeBuffer.Unlock()
+}
+
+func (channel *Channel) Read(data []byte) (int, error) {
+    if channel.Mute {
+        for i := 0; i < len(data); i++ {
+            data[i] = 0
+        }
+        channel.AudioBuffer.Clear()
+        return len(data), nil
+    }
+
+    samples := len(data) / 4
+
+    if samples > len(channel.buffer) {
+        samples = len(channel.buffer)
+    }
+
+    part := channel.buffer[:samples]
+    floatSamples := channel.AudioBuffer.Read(part)
+
+    i := 0
+    for sampleIndex := range floatSamples {
+        value := part[sampleIndex]
+        bits := math.Float32bits(value)
+        data[i*4+0] = byte(bits)
+        data[i*4+1] = byte(bits >> 8)
+        data[i*4+2] = byte(bits >> 16)
+        data[i*4+3] = byte(bits >> 24)
+
+        i += 1
+    }
+
+    i *= 4
+
+    // in a browser we have to return something, so we generate some silence
+    if i == 0 && runtime.GOOS == "js" {
+        for i < 8 {
+            data[i] = 0
+            i += 1
+        }
+        return 8, nil
+    } else {
+        // on a normal os we can just return 0 if necessary
+        return floatSamples * 4, nil
+    }
+}
+
+type Player struct {
+    File *File669
+    Channels []*Channel
+    SampleRate int
+
+    Speed int
+    BPM int
+
+    CurrentRow int
+    CurrentOrder int
+    OrdersPlayed int
+    // the orders and rows that playback has jumped or moved into
+    loops common.LoopDetector
+    // the song has looped, so there is nothing new left to play
+    SongLooped bool
+    ticks float32
+
+    PanLaw common.PanLaw
+
+    OnChangeRow func(row int)
+    OnChangeOrder func(order int, pattern int)
+    OnChangeSpeed func(speed int, bpm int)
+}
+
+func MakePlayer(file *File669, sampleRate int) *Player {
+    player := &Player{
+        File: file,
+        SampleRate: sampleRate,
+        BPM: BPM,
+        loops: common.MakeLoopDetector(),
+    }
+
+    player.Speed = file.GetPattern(0).Speed
+
+    for i := range Channels {
+        // the channels alternate between left and right, starting on the left
+        pan := 0x30
+        if i % 2 == 1 {
+            pan = 0xd0
+        }
+
+        player.Channels = append(player.Channels, &Channel{
+            Player: player,
+            Channel: i,
+            AudioBuffer: common.MakeAudioBuffer(sampleRate * 2),
+            ScopeBuffer: common.MakeAudioBuffer(sampleRate * 2 / 10),
+            Volume: 1.0,
+            Pan: pan,
+            CurrentSample: -1,
+            buffer: make([]float32, sampleRate),
+            currentRow: -1,
+        })
+    }
+
+    return player
+}
+
+func (player *Player) GetSample(index int) *Sample {
+    if index < 0 || index >= len(player.File.Samples) {
+        return nil
+    }
+
+    return &player.File.Samples[index]
+}
+
+func (player *Player) GetPattern() int {
+    return int(player.File.Orders[player.CurrentOrder])
+}
+
+func (player *Player) GetSongLength() int {
+    return len(player.File.Orders)
+}
+
+func (player *Player) GetRowNoteInfo(channel int, row int) (common.NoteInfo, bool) {
+    note, ok := player.GetRowNote(channel, row)
+    if !ok {
+        return nil, false
+    }
+    return note, true
+}
+
+func (player *Player) GetRowNote(channel int, row int) (*Note, bool) {
+    pattern := player.File.GetPattern(player.CurrentOrder)
+    if pattern == nil || row < 0 || row >= len(pattern.Rows) || channel < 0 || channel >= Channels {
+        return nil, false
+    }
+
+    return &pattern.Rows[row][channel], true
+}
+
+// each pattern starts at its own speed
+func (player *Player) startPattern() {
+    player.Speed = player.File.GetPattern(player.CurrentOrder).Speed
+
+    if player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+    }
+
+    if player.OnChangeSpeed != nil {
+        player.OnChangeSpeed(player.Speed, player.BPM)
+    }
+}
+
+// move on to the first row of the given order as the song plays. past the end of the order
+// list the song continues from the restart order
+func (player *Player) advanceOrder(order int) {
+    if order >= len(player.File.Orders) {
+        order = player.File.RestartOrder
+    }
+
+    if player.loops.Visit(order, 0) {
+        player.SongLooped = true
+    }
+
+    player.CurrentOrder = order
+    player.CurrentRow = 0
+    player.OrdersPlayed += 1
+    player.startPattern()
+}
+
+func (player *Player) Update(timeDelta float32) {
+    oldTicks := int(player.ticks)
+
+    // true if a new row starts, even if the next pattern starts on the same row number
+    rowChanged := false
+
+    if player.CurrentRow < 0 {
+        player.CurrentRow = 0
+        rowChanged = true
+    }
+
+    player.ticks += timeDelta * float32(player.BPM) * 2 / 5
+    newTicks := int(player.ticks)
+
+    if player.ticks >= float32(player.Speed) {
+        player.ticks -= float32(player.Speed)
+
+        player.CurrentRow += 1
+
+        // the pattern ends after its break row
+        if player.CurrentRow > player.File.GetPattern(player.CurrentOrder).BreakRow {
+            player.advanceOrder(player.CurrentOrder + 1)
+        }
+
+        rowChanged = true
+
+        if player.OnChangeRow != nil {
+            player.OnChangeRow(player.CurrentRow)
+        }
+    }
+
+    for _, channel := range player.Channels {
+        changeRow := false
+        if rowChanged || player.CurrentRow != channel.currentRow {
+            channel.UpdateRow()
+            changeRow = true
+        }
+
+        if newTicks != oldTicks {
+            channel.UpdateTick(changeRow, newTicks - oldTicks)
+        }
+
+        channel.Update(timeDelta)
+    }
+}
+
+func (player *Player) SetOnChangeRow(callback func(row int)) {
+    player.OnChangeRow = callback
+}
+
+func (player *Player) SetOnChangeOrder(callback func(order int, pattern int)) {
+    player.OnChangeOrder = callback
+}
+
+func (player *Player) SetOnChangeSpeed(callback func(speed int, bpm int)) {
+    player.OnChangeSpeed = callback
+}
+
+func (player *Player) GetChannelReaders() []io.Reader {
+    readers := make([]io.Reader, len(player.Channels))
+    for i, channel := range player.Channels {
+        readers[i] = channel
+    }
+    return readers
+}
+
+func (player *Player) ToggleMuteChannel(channel int) bool {
+    if channel < 0 || channel >= len(player.Channels) {
+        return false
+    }
+
+    player.Channels[channel].Mute = !player.Channels[channel].Mute
+    return player.Channels[channel].Mute
+}
+
+func (player *Player) NextOrder() {
+    player.CurrentOrder += 1
+    if player.CurrentOrder >= len(player.File.Orders) {
+        player.CurrentOrder = 0
+    }
+    player.CurrentRow = 0
+
+    player.startPattern()
+}
+
+func (player *Player) PreviousOrder() {
+    player.CurrentOrder -= 1
+    if player.CurrentOrder < 0 {
+        player.CurrentOrder = len(player.File.Orders) - 1
+    }
+    player.CurrentRow = 0
+
+    player.startPattern()
+}
+
+func (player *Player) GetSpeed() int {
+    return player.Speed
+}
+
+func (player *Player) GetBPM() int {
+    return player.BPM
+}
+
+func (player *Player) GetChannelCount() int {
+    return len(player.Channels)
+}
+
+func (player *Player) GetName() string {
+    return player.File.Name
+}
+
+func (player *Player) IsStereo() bool {
+    return true
+}
+
+func (player *Player) GetChannelData(channel int, data []float32) int {
+    if channel < len(player.Channels) {
+        return player.Channels[channel].ScopeBuffer.Peek(data)
+    }
+
+    return 0
+}
+
+func (player *Player) ResetRow() {
+    player.CurrentRow = 0
+}
+
+func (player *Player) GetCurrentOrder() int {
+    return player.CurrentOrder
+}
+
+func (player *Player) RenderToPCM() io.Reader {
+    // make a buffer to hold 1/100th of a second of audio data, which is 4-bytes per sample
+    // and 1 samples per channel
+    rate := 100
+    buffer := make([]float32, player.SampleRate * 2 / rate)
+    mix := make([]float32, player.SampleRate * 2 / rate)
+
+    fillMix := func() bool {
+        if player.SongLooped {
+            return false
+        }
+
+        player.Update(1.0 / float32(rate))
+
+        for i := range mix {
+            mix[i] = 0
+        }
+
+        for _, channel := range player.Channels {
+            amount := channel.AudioBuffer.Read(buffer)
+
+            if amount > 0 {
+                // copy the samples into the mix buffer
+                for i := range amount {
+                    mix[i] = mix[i] + buffer[i]
+                }
+            }
+        }
+
+        for i := range mix {
+            mix[i] = max(min(mix[i], 1), -1)
+        }
+
+        return true
+    }
+
+    mixPosition := len(mix)
+    reader := func(data []byte) (int, error) {
+        if len(data) == 0 {
+            return 0, nil
+        }
+
+        if player.SongLooped {
+            return 0, io.EOF
+        }
+
+        // wait for the music to be produced
+        if mixPosition < len(mix) {
+            part := mix[mixPosition:]
+
+            amount := common.CopyFloat32(data, part)
+            mixPosition += amount
+            return amount * 4, nil
+        }
+
+        mixPosition = 0
+
+        more := fillMix()
+        if !more {
+            return 0, io.EOF
+        }
+
+        // copy the mix into the data buffer
+        amount := common.CopyFloat32(data, mix)
+        mixPosition += amount
+
+        return amount * 4, nil
+    }
+
+    return &common.ReaderFunc{
+        Func: reader,
+    }
+}
@@ -0,0 +1,144 @@
+package it
+
+import (
+    "io"
+    "fmt"
+    "encoding/binary"
+)
+
+// decoding of the IT214 and IT215 sample compression. the samples are split into blocks,
+// and each block is a stream of deltas stored with a bit width that changes as the block goes
+
+// reads values of any number of bits, least significant bit first
+type bitReader struct {
+    data []byte
+    position int // in bits
+}
+
+func (reader *bitReader) read(bits int) (int, bool) {
+    value := 0
+    for i := range bits {
+        index := reader.position >> 3
+        if index >= len(reader.data) {
+            return 0, false
+        }
+
+        if (reader.data[index] >> (reader.position & 7)) & 1 != 0 {
+            value |= 1 << i
+        }
+        reader.position += 1
+    }
+
+    return value, true
+}
+
+// keep a value inside a signed integer of the given number of bits
+func wrapSigned(value int, bits int) int {
+    shift := 32 - bits
+    return int(int32(uint32(value) << shift) >> shift)
+}
+
+// decompress length samples. IT215 integrates the deltas twice, which suits
+// samples with a lot of high frequencies
+func decompressSample(reader io.Reader, length int, is16Bit bool, it215 bool) ([]float32, error) {
+    // 8-bit blocks hold 0x8000 samples and start out storing 9 bits per value, 16-bit blocks
+    // hold 0x4000 samples and start at 17 bits. the extra bit marks a change of width
+    blockSize := 0x8000
+    sampleBits := 8
+    // the number of bits that give a new width when the width is small
+    widthBits := 3
+    if is16Bit {
+        blockSize = 0x4000
+        sampleBits = 16
+        widthBits = 4
+    }
+
+    fullWidth := sampleBits + 1
+    scale := float32(int(1) << (sampleBits - 1))
+
+    // the data grows with each block that is read rather than trusting the length up front
+    data := make([]float32, 0, min(length, blockSize))
+
+    for len(data) < length {
+        var compressedLength uint16
+        err := binary.Read(reader, binary.LittleEndian, &compressedLength)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading compressed block length: %v", err)
+        }
+
+        block := make([]byte, compressedLength)
+        _, err = io.ReadFull(reader, block)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading compressed block: %v", err)
+        }
+
+        bits := bitReader{data: block}
+        count := min(blockSize, length - len(data))
+        width := fullWidth
+        delta1 := 0
+        delta2 := 0
+
+        for produced := 0; produced < count; {
+            // a corrupt block can ask for a width that no value is stored with
+            if width < 1 || width > fullWidth {
+                return nil, fmt.Errorf("Invalid bit width %v in compressed sample", width)
+            }
+
+            value, ok := bits.read(width)
+            if !ok {
+                // a short block leaves the rest of it silent
+                break
+            }
+
+            if width < 7 {
+                // the top value of a small width is followed by the new width
+                if value == 1 << (width - 1) {
+                    value, ok = bits.read(widthBits)
+                    if !ok {
+                        break
+                    }
+                    value += 1
+                    if value >= width {
+                        value += 1
+                    }
+                    width = value
+                    continue
+                }
+            } else if width < fullWidth {
+                // a range of values around the top of a medium width is a new width
+                half := 1 << (widthBits - 1)
+                border := ((1 << sampleBits) - 1) >> (fullWidth - width) - half
+                if value > border && value <= border + half * 2 {
+                    value -= border
+                    if value >= width {
+                        value += 1
+                    }
+                    width = value
+                    continue
+                }
+            } else {
+                // the extra bit at full width means the low bits are the new width
+                if value & (1 << sampleBits) != 0 {
+                    width = (value + 1) & 0xff
+                    continue
+                }
+            }
+
+            delta1 = wrapSigned(delta1 + wrapSigned(value, min(width, sampleBits)), sampleBits)
+            delta2 = wrapSigned(delta2 + delta1, sampleBits)
+
+            if it215 {
+                data = append(data, float32(delta2) / scale)
+            } else {
+                data = append(data, float32(delta1) / scale)
+            }
+            produced += 1
+        }
+
+        for len(data) < length && len(data) % blockSize != 0 {
+            data = append(data, 0)
+        }
+    }
+
+    return data, nil
+}
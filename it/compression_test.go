@@ -0,0 +1,66 @@
+package it
+
+import (
+    "testing"
+    "bytes"
+)
+
+// a block is its length followed by the compressed bits
+func makeBlock(bits ...byte) []byte {
+    return append([]byte{byte(len(bits)), byte(len(bits) >> 8)}, bits...)
+}
+
+func TestDecompressSample(test *testing.T) {
+    tests := []struct {
+        name string
+        data []byte
+        is16Bit bool
+        length int
+        fails bool
+    }{
+        // 9 bit values of 1, 2 and 3
+        {name: "8-bit deltas", data: makeBlock(0x01, 0x04, 0x0c, 0x00), length: 3},
+        // 0x1ff at full width switches to width 0
+        {name: "8-bit width 0", data: makeBlock(0xff, 0x01), length: 4, fails: true},
+        // 0x1ffff at full width switches to width 0
+        {name: "16-bit width 0", data: makeBlock(0xff, 0xff, 0x01), is16Bit: true, length: 4, fails: true},
+        // 0x1fe at full width switches to width 255
+        {name: "8-bit width 255", data: makeBlock(0xfe, 0x01), length: 4, fails: true},
+        {name: "missing block", data: nil, length: 4, fails: true},
+        // the length is not allocated up front, so a huge one runs out of blocks instead of memory
+        {name: "huge length", data: makeBlock(0x01, 0x04, 0x0c, 0x00), length: 0x7ffffff0, fails: true},
+    }
+
+    for _, check := range tests {
+        for _, it215 := range []bool{false, true} {
+            data, err := decompressSample(bytes.NewReader(check.data), check.length, check.is16Bit, it215)
+            if check.fails {
+                if err == nil {
+                    test.Errorf("%v: expected an error", check.name)
+                }
+                continue
+            }
+
+            if err != nil {
+                test.Errorf("%v: %v", check.name, err)
+                continue
+            }
+
+            if len(data) != check.length {
+                test.Errorf("%v: expected %v samples, got %v", check.name, check.length, len(data))
+            }
+        }
+    }
+
+    // the deltas are added up once for IT214
+    data, err := decompressSample(bytes.NewReader(makeBlock(0x01, 0x04, 0x0c, 0x00)), 3, false, false)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    for i, expected := range []float32{1, 3, 6} {
+        if data[i] != expected / 128 {
+            test.Errorf("sample %v: expected %v, got %v", i, expected / 128, data[i])
+        }
+    }
+}
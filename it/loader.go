@@ -0,0 +1,842 @@
+package it
+
+import (
+    "io"
+    "bufio"
+    "bytes"
+    "fmt"
+    "log"
+    "encoding/binary"
+)
+
+const (
+    EffectNone = 0
+    EffectSetSpeed = 1 // A
+    EffectPositionJump = 2 // B
+    EffectPatternBreak = 3 // C
+    EffectVolumeSlide = 4 // D
+    EffectPortamentoDown = 5 // E
+    EffectPortamentoUp = 6 // F
+    EffectTonePortamento = 7 // G
+    EffectVibrato = 8 // H
+    EffectTremor = 9 // I
+    EffectArpeggio = 10 // J
+    EffectVibratoVolumeSlide = 11 // K
+    EffectTonePortamentoVolumeSlide = 12 // L
+    EffectChannelVolume = 13 // M
+    EffectChannelVolumeSlide = 14 // N
+    EffectSampleOffset = 15 // O
+    EffectPanningSlide = 16 // P
+    EffectRetrigger = 17 // Q
+    EffectTremolo = 18 // R
+    EffectSpecial = 19 // S
+    EffectTempo = 20 // T
+    EffectFineVibrato = 21 // U
+    EffectGlobalVolume = 22 // V
+    EffectGlobalVolumeSlide = 23 // W
+    EffectSetPanning = 24 // X
+    EffectPanbrello = 25 // Y
+    EffectMidiMacro = 26 // Z
+)
+
+// the special note values, anything else from 120 up fades the note out
+const (
+    NoteOff = 255
+    NoteCut = 254
+)
+
+const (
+    NewNoteActionCut = 0
+    NewNoteActionContinue = 1
+    NewNoteActionOff = 2
+    NewNoteActionFade = 3
+)
+
+const (
+    DuplicateCheckOff = 0
+    DuplicateCheckNote = 1
+    DuplicateCheckSample = 2
+    DuplicateCheckInstrument = 3
+)
+
+type ITFile struct {
+    Name string
+    Orders []byte
+    Instruments []*Instrument
+    Samples []*Sample
+    Patterns []Pattern
+    // the number of channels that the patterns use
+    Channels int
+    // 0-64, 100 is surround. the top bit is set for channels that are switched off
+    ChannelPanning [64]uint8
+    ChannelVolume [64]uint8 // 0-64
+    InitialSpeed uint8
+    InitialTempo uint8
+    GlobalVolume uint8 // 0-128
+    MixVolume uint8 // 0-128
+    PanSeparation uint8 // 0-128
+    Stereo bool
+    // false for sample mode songs, where the instrument column picks a sample
+    UseInstruments bool
+    LinearSlides bool
+    OldEffects bool
+    // G shares its memory with E and F
+    LinkedPortamento bool
+}
+
+func (file *ITFile) GetPattern(order int) *Pattern {
+    if order < 0 || order >= len(file.Orders) || int(file.Orders[order]) >= len(file.Patterns) {
+        return nil
+    }
+
+    return &file.Patterns[file.Orders[order]]
+}
+
+type Note struct {
+    Note uint8 // 0-119 where 60 is C-5, or one of the special note values
+    HasNote bool
+    Instrument uint8
+    HasInstrument bool
+    // 0-64 is a volume, the rest are volume column commands
+    Volume uint8
+    HasVolume bool
+    Effect uint8 // one of the Effect* values
+    EffectParameter uint8
+    HasEffect bool
+}
+
+func (note *Note) GetNotePosition() int {
+    if note.HasNote && note.Note < 120 {
+        return int(note.Note)
+    }
+
+    return 0
+}
+
+func (note *Note) GetName() string {
+    if !note.HasNote {
+        return "..."
+    }
+
+    switch {
+        case note.Note == NoteOff: return "==="
+        case note.Note == NoteCut: return "^^^"
+        case note.Note >= 120: return "~~~"
+    }
+
+    names := []string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+    return fmt.Sprintf("%v%d", names[note.Note % 12], note.Note / 12)
+}
+
+func (note *Note) GetSampleName() string {
+    if note.HasInstrument && note.Instrument > 0 {
+        return fmt.Sprintf("%02d", note.Instrument)
+    }
+    return ".."
+}
+
+// the volume column is shown the way impulse tracker shows it, a letter for the commands
+// followed by their parameter
+func (note *Note) GetVolumeName() string {
+    if !note.HasVolume {
+        return "..."
+    }
+
+    volume := int(note.Volume)
+    switch {
+        case volume <= 64: return fmt.Sprintf("%03d", volume)
+        case volume <= 74: return fmt.Sprintf("a%02d", volume - 65)
+        case volume <= 84: return fmt.Sprintf("b%02d", volume - 75)
+        case volume <= 94: return fmt.Sprintf("c%02d", volume - 85)
+        case volume <= 104: return fmt.Sprintf("d%02d", volume - 95)
+        case volume <= 114: return fmt.Sprintf("e%02d", volume - 105)
+        case volume <= 124: return fmt.Sprintf("f%02d", volume - 115)
+        case volume >= 128 && volume <= 192: return fmt.Sprintf("p%02d", volume - 128)
+        case volume >= 193 && volume <= 202: return fmt.Sprintf("g%02d", volume - 193)
+        case volume >= 203 && volume <= 212: return fmt.Sprintf("h%02d", volume - 203)
+    }
+
+    return "..."
+}
+
+func (note *Note) GetEffectName() string {
+    if note.HasEffect && note.Effect > 0 && note.Effect <= EffectMidiMacro {
+        return fmt.Sprintf("%c%02X", 'A' + note.Effect - 1, note.EffectParameter)
+    }
+
+    return "..."
+}
+
+type Pattern struct {
+    // each row has one note per channel
+    Rows [][]Note
+}
+
+type EnvelopeNode struct {
+    Value int8 // 0-64 for volume, -32 to 32 for panning and pitch
+    Tick uint16
+}
+
+type Envelope struct {
+    Nodes []EnvelopeNode
+    Enabled bool
+    Loop bool
+    Sustain bool
+    // the pitch envelope is used for the resonant filter instead
+    Filter bool
+    LoopStart int
+    LoopEnd int
+    SustainStart int
+    SustainEnd int
+}
+
+type KeyboardEntry struct {
+    Note uint8 // the note to play the sample at
+    Sample uint8 // 1-based, 0 means no sample
+}
+
+type Instrument struct {
+    Name string
+    NewNoteAction uint8
+    DuplicateCheckType uint8
+    DuplicateCheckAction uint8 // one of the NewNoteAction* values, other than continue
+    // subtracted from the fade volume each tick, which starts at 1024
+    Fadeout int
+    PitchPanSeparation int8 // -32 to 32
+    PitchPanCenter uint8 // a note
+    GlobalVolume uint8 // 0-128
+    // 0-64, the top bit is set if the instrument does not change the panning
+    DefaultPan uint8
+    Keyboard [120]KeyboardEntry
+    VolumeEnvelope Envelope
+    PanningEnvelope Envelope
+    PitchEnvelope Envelope
+}
+
+func (instrument *Instrument) HasDefaultPan() bool {
+    return instrument.DefaultPan & 128 == 0
+}
+
+const (
+    AutoVibratoSine = 0
+    AutoVibratoRampDown = 1
+    AutoVibratoSquare = 2
+    AutoVibratoRandom = 3
+)
+
+type Sample struct {
+    Name string
+    GlobalVolume uint8 // 0-64
+    Volume uint8 // 0-64
+    // 0-64, the top bit is set if the sample changes the panning
+    DefaultPan uint8
+    C5Speed uint32 // the playback rate of C-5
+
+    Loop bool
+    PingPongLoop bool
+    LoopStart int
+    LoopEnd int
+
+    // the sustain loop plays until the note is released
+    SustainLoop bool
+    PingPongSustainLoop bool
+    SustainLoopStart int
+    SustainLoopEnd int
+
+    VibratoSpeed uint8
+    VibratoDepth uint8
+    VibratoRate uint8 // how fast the vibrato reaches its full depth
+    VibratoType uint8 // one of the AutoVibrato* waveforms
+
+    Data []float32
+}
+
+func (sample *Sample) HasDefaultPan() bool {
+    return sample.DefaultPan & 128 != 0
+}
+
+// read the node table of a new format envelope
+func readEnvelope(data []byte) Envelope {
+    flags := data[0]
+    count := min(int(data[1]), 25)
+
+    var envelope Envelope
+    for i := range count {
+        envelope.Nodes = append(envelope.Nodes, EnvelopeNode{
+            Value: int8(data[6 + i * 3]),
+            Tick: binary.LittleEndian.Uint16(data[7 + i * 3:]),
+        })
+    }
+
+    envelope.LoopStart = int(data[2])
+    envelope.LoopEnd = int(data[3])
+    envelope.SustainStart = int(data[4])
+    envelope.SustainEnd = int(data[5])
+
+    envelope.Enabled = flags & 1 != 0 && count > 0
+    envelope.Loop = flags & 2 != 0 && envelope.LoopStart <= envelope.LoopEnd && envelope.LoopEnd < count
+    envelope.Sustain = flags & 4 != 0 && envelope.SustainStart <= envelope.SustainEnd && envelope.SustainEnd < count
+    envelope.Filter = flags & 128 != 0
+
+    return envelope
+}
+
+func readInstrument(reader io.ReadSeeker, offset uint32, logger *log.Logger) (*Instrument, error) {
+    _, err := reader.Seek(int64(offset), io.SeekStart)
+    if err != nil {
+        return nil, err
+    }
+
+    data := make([]byte, 554)
+    _, err = io.ReadFull(reader, data)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading instrument: %v", err)
+    }
+
+    if !bytes.Equal(data[:4], []byte("IMPI")) {
+        return nil, fmt.Errorf("Unexpected instrument signature: %v", data[:4])
+    }
+
+    instrument := Instrument{
+        Name: string(bytes.TrimRight(data[0x20:0x3a], "\x00")),
+        NewNoteAction: data[0x11] & 3,
+        DuplicateCheckType: data[0x12] & 3,
+        DuplicateCheckAction: data[0x13] % 3,
+        Fadeout: int(binary.LittleEndian.Uint16(data[0x14:])),
+        PitchPanSeparation: int8(data[0x16]),
+        PitchPanCenter: data[0x17],
+        GlobalVolume: min(data[0x18], 128),
+        DefaultPan: data[0x19],
+        VolumeEnvelope: readEnvelope(data[0x130:]),
+        PanningEnvelope: readEnvelope(data[0x182:]),
+        PitchEnvelope: readEnvelope(data[0x1d4:]),
+    }
+
+    // the duplicate check action is cut, off or fade, which are the same as the new note actions
+    // other than continue
+    if instrument.DuplicateCheckAction > 0 {
+        instrument.DuplicateCheckAction += 1
+    }
+
+    for i := range instrument.Keyboard {
+        instrument.Keyboard[i] = KeyboardEntry{
+            Note: data[0x40 + i * 2],
+            Sample: data[0x41 + i * 2],
+        }
+    }
+
+    logger.Printf("Instrument '%v' nna %v dct %v dca %v fadeout %v", instrument.Name, instrument.NewNoteAction, instrument.DuplicateCheckType, instrument.DuplicateCheckAction, instrument.Fadeout)
+
+    return &instrument, nil
+}
+
+// instruments from impulse tracker before 2.0 only have a volume envelope
+func readOldInstrument(reader io.ReadSeeker, offset uint32, logger *log.Logger) (*Instrument, error) {
+    _, err := reader.Seek(int64(offset), io.SeekStart)
+    if err != nil {
+        return nil, err
+    }
+
+    data := make([]byte, 554)
+    _, err = io.ReadFull(reader, data)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading instrument: %v", err)
+    }
+
+    if !bytes.Equal(data[:4], []byte("IMPI")) {
+        return nil, fmt.Errorf("Unexpected instrument signature: %v", data[:4])
+    }
+
+    flags := data[0x11]
+
+    instrument := Instrument{
+        Name: string(bytes.TrimRight(data[0x20:0x3a], "\x00")),
+        NewNoteAction: data[0x1a] & 3,
+        // the fadeout has half the range of the new format
+        Fadeout: int(binary.LittleEndian.Uint16(data[0x18:])) * 2,
+        PitchPanCenter: 60,
+        GlobalVolume: 128,
+        DefaultPan: 128,
+    }
+
+    // the old duplicate note check only compares notes and always cuts
+    if data[0x1b] != 0 {
+        instrument.DuplicateCheckType = DuplicateCheckNote
+        instrument.DuplicateCheckAction = NewNoteActionCut
+    }
+
+    for i := range instrument.Keyboard {
+        instrument.Keyboard[i] = KeyboardEntry{
+            Note: data[0x40 + i * 2],
+            Sample: data[0x41 + i * 2],
+        }
+    }
+
+    envelope := &instrument.VolumeEnvelope
+    for i := range 25 {
+        tick := data[0x1f8 + i * 2]
+        if tick == 0xff {
+            break
+        }
+
+        envelope.Nodes = append(envelope.Nodes, EnvelopeNode{
+            Tick: uint16(tick),
+            Value: int8(data[0x1f9 + i * 2]),
+        })
+    }
+
+    count := len(envelope.Nodes)
+    envelope.LoopStart = int(data[0x12])
+    envelope.LoopEnd = int(data[0x13])
+    envelope.SustainStart = int(data[0x14])
+    envelope.SustainEnd = int(data[0x15])
+    envelope.Enabled = flags & 1 != 0 && count > 0
+    envelope.Loop = flags & 2 != 0 && envelope.LoopStart <= envelope.LoopEnd && envelope.LoopEnd < count
+    envelope.Sustain = flags & 4 != 0 && envelope.SustainStart <= envelope.SustainEnd && envelope.SustainEnd < count
+
+    logger.Printf("Old instrument '%v' nna %v fadeout %v", instrument.Name, instrument.NewNoteAction, instrument.Fadeout)
+
+    return &instrument, nil
+}
+
+// read uncompressed sample data, which is a block of left samples followed by the right
+// samples for a stereo sample
+func readSampleData(reader io.Reader, length int, is16Bit bool, signed bool, delta bool) ([]float32, error) {
+    size := 1
+    if is16Bit {
+        size = 2
+    }
+
+    // the length comes from the file, so nothing is allocated until the data is really there
+    raw, err := io.ReadAll(io.LimitReader(reader, int64(length * size)))
+    if err != nil {
+        return nil, fmt.Errorf("Error reading sample data: %v", err)
+    }
+    if len(raw) < length * size {
+        return nil, fmt.Errorf("Error reading sample data: %v", io.ErrUnexpectedEOF)
+    }
+
+    data := make([]float32, length)
+    var last int
+    for i := range data {
+        var value int
+        if is16Bit {
+            value = int(binary.LittleEndian.Uint16(raw[i * 2:]))
+            if signed {
+                value = int(int16(value))
+            } else {
+                value -= 0x8000
+            }
+        } else {
+            value = int(raw[i])
+            if signed {
+                value = int(int8(value))
+            } else {
+                value -= 0x80
+            }
+        }
+
+        if delta {
+            if is16Bit {
+                value = int(int16(last + value))
+            } else {
+                value = int(int8(last + value))
+            }
+            last = value
+        }
+
+        if is16Bit {
+            data[i] = float32(value) / 32768
+        } else {
+            data[i] = float32(value) / 128
+        }
+    }
+
+    return data, nil
+}
+
+func readSample(reader io.ReadSeeker, offset uint32, logger *log.Logger) (*Sample, error) {
+    _, err := reader.Seek(int64(offset), io.SeekStart)
+    if err != nil {
+        return nil, err
+    }
+
+    data := make([]byte, 0x50)
+    _, err = io.ReadFull(reader, data)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading sample header: %v", err)
+    }
+
+    if !bytes.Equal(data[:4], []byte("IMPS")) {
+        return nil, fmt.Errorf("Unexpected sample signature: %v", data[:4])
+    }
+
+    flags := data[0x12]
+    convert := data[0x2e]
+    length := int(binary.LittleEndian.Uint32(data[0x30:]))
+    pointer := binary.LittleEndian.Uint32(data[0x48:])
+
+    sample := Sample{
+        Name: string(bytes.TrimRight(data[0x14:0x2e], "\x00")),
+        GlobalVolume: min(data[0x11], 64),
+        Volume: min(data[0x13], 64),
+        DefaultPan: data[0x2f],
+        C5Speed: binary.LittleEndian.Uint32(data[0x3c:]),
+        Loop: flags & 16 != 0,
+        PingPongLoop: flags & 64 != 0,
+        LoopStart: int(binary.LittleEndian.Uint32(data[0x34:])),
+        LoopEnd: int(binary.LittleEndian.Uint32(data[0x38:])),
+        SustainLoop: flags & 32 != 0,
+        PingPongSustainLoop: flags & 128 != 0,
+        SustainLoopStart: int(binary.LittleEndian.Uint32(data[0x40:])),
+        SustainLoopEnd: int(binary.LittleEndian.Uint32(data[0x44:])),
+        VibratoSpeed: data[0x4c],
+        VibratoDepth: data[0x4d],
+        VibratoRate: data[0x4e],
+        VibratoType: data[0x4f] & 3,
+    }
+
+    logger.Printf("Sample '%v' flags 0x%x convert 0x%x length %v c5 %v", sample.Name, flags, convert, length, sample.C5Speed)
+
+    // the sample header may exist without any sample data
+    if flags & 1 == 0 || length == 0 {
+        return &sample, nil
+    }
+
+    _, err = reader.Seek(int64(pointer), io.SeekStart)
+    if err != nil {
+        return nil, err
+    }
+
+    buffer := bufio.NewReader(reader)
+
+    is16Bit := flags & 2 != 0
+    channels := 1
+    if flags & 4 != 0 {
+        channels = 2
+    }
+
+    // each side of a stereo sample is stored separately, they are mixed down to mono
+    for range channels {
+        var channelData []float32
+        if flags & 8 != 0 {
+            channelData, err = decompressSample(buffer, length, is16Bit, convert & 4 != 0)
+        } else {
+            channelData, err = readSampleData(buffer, length, is16Bit, convert & 1 != 0, convert & 4 != 0)
+        }
+
+        if err != nil {
+            return nil, err
+        }
+
+        if sample.Data == nil {
+            sample.Data = channelData
+        } else {
+            for i := range sample.Data {
+                sample.Data[i] = (sample.Data[i] + channelData[i]) / 2
+            }
+        }
+    }
+
+    // loops that don't fit in the sample are not used
+    if sample.LoopEnd > length || sample.LoopStart >= sample.LoopEnd {
+        sample.Loop = false
+    }
+    if sample.SustainLoopEnd > length || sample.SustainLoopStart >= sample.SustainLoopEnd {
+        sample.SustainLoop = false
+    }
+
+    return &sample, nil
+}
+
+// read a packed pattern into rows of 64 channels
+func readPattern(reader io.ReadSeeker, offset uint32) (Pattern, error) {
+    // an offset of 0 is an empty 64 row pattern
+    if offset == 0 {
+        rows := make([][]Note, 64)
+        for i := range rows {
+            rows[i] = make([]Note, 64)
+        }
+        return Pattern{Rows: rows}, nil
+    }
+
+    _, err := reader.Seek(int64(offset), io.SeekStart)
+    if err != nil {
+        return Pattern{}, err
+    }
+
+    var header [8]byte
+    _, err = io.ReadFull(reader, header[:])
+    if err != nil {
+        return Pattern{}, fmt.Errorf("Error reading pattern header: %v", err)
+    }
+
+    length := binary.LittleEndian.Uint16(header[0:])
+    rowCount := int(binary.LittleEndian.Uint16(header[2:]))
+    if rowCount < 1 || rowCount > 200 {
+        return Pattern{}, fmt.Errorf("Rows must be between 1 and 200, got %d", rowCount)
+    }
+
+    packed := make([]byte, length)
+    _, err = io.ReadFull(reader, packed)
+    if err != nil {
+        return Pattern{}, fmt.Errorf("Error reading pattern data: %v", err)
+    }
+
+    data := bytes.NewReader(packed)
+
+    // the mask and the values of the last note are remembered for each channel
+    var masks [64]byte
+    var last [64]Note
+
+    var pattern Pattern
+    row := make([]Note, 64)
+
+    for len(pattern.Rows) < rowCount {
+        channelVariable, err := data.ReadByte()
+        if err != nil {
+            // some trackers leave out the end of row markers at the end of the pattern
+            pattern.Rows = append(pattern.Rows, row)
+            row = make([]Note, 64)
+            continue
+        }
+
+        if channelVariable == 0 {
+            pattern.Rows = append(pattern.Rows, row)
+            row = make([]Note, 64)
+            continue
+        }
+
+        channel := int(channelVariable - 1) & 63
+        if channelVariable & 128 != 0 {
+            masks[channel], err = data.ReadByte()
+            if err != nil {
+                return Pattern{}, fmt.Errorf("Error reading pattern mask: %v", err)
+            }
+        }
+
+        mask := masks[channel]
+        note := &row[channel]
+
+        if mask & 1 != 0 {
+            last[channel].Note, err = data.ReadByte()
+            if err != nil {
+                return Pattern{}, fmt.Errorf("Error reading note: %v", err)
+            }
+        }
+
+        if mask & 2 != 0 {
+            last[channel].Instrument, err = data.ReadByte()
+            if err != nil {
+                return Pattern{}, fmt.Errorf("Error reading instrument: %v", err)
+            }
+        }
+
+        if mask & 4 != 0 {
+            last[channel].Volume, err = data.ReadByte()
+            if err != nil {
+                return Pattern{}, fmt.Errorf("Error reading volume: %v", err)
+            }
+        }
+
+        if mask & 8 != 0 {
+            last[channel].Effect, err = data.ReadByte()
+            if err != nil {
+                return Pattern{}, fmt.Errorf("Error reading effect: %v", err)
+            }
+            last[channel].EffectParameter, err = data.ReadByte()
+            if err != nil {
+                return Pattern{}, fmt.Errorf("Error reading effect parameter: %v", err)
+            }
+        }
+
+        if mask & (1 | 16) != 0 {
+            note.HasNote = true
+            note.Note = last[channel].Note
+        }
+
+        if mask & (2 | 32) != 0 {
+            note.HasInstrument = true
+            note.Instrument = last[channel].Instrument
+        }
+
+        if mask & (4 | 64) != 0 {
+            note.HasVolume = true
+            note.Volume = last[channel].Volume
+        }
+
+        if mask & (8 | 128) != 0 {
+            note.HasEffect = true
+            note.Effect = last[channel].Effect
+            note.EffectParameter = last[channel].EffectParameter
+        }
+    }
+
+    return pattern, nil
+}
+
+func Load(reader io.ReadSeeker, logger *log.Logger) (*ITFile, error) {
+    _, err := reader.Seek(0, io.SeekStart)
+    if err != nil {
+        return nil, err
+    }
+
+    header := make([]byte, 0xc0)
+    _, err = io.ReadFull(reader, header)
+    if err != nil {
+        return nil, err
+    }
+
+    if !bytes.Equal(header[:4], []byte("IMPM")) {
+        return nil, fmt.Errorf("Not an it file, signature was %v", header[:4])
+    }
+
+    name := bytes.TrimRight(header[4:0x1e], "\x00")
+    logger.Printf("Name: '%s'", name)
+
+    orderCount := int(binary.LittleEndian.Uint16(header[0x20:]))
+    instrumentCount := int(binary.LittleEndian.Uint16(header[0x22:]))
+    sampleCount := int(binary.LittleEndian.Uint16(header[0x24:]))
+    patternCount := int(binary.LittleEndian.Uint16(header[0x26:]))
+    trackerVersion := binary.LittleEndian.Uint16(header[0x28:])
+    compatibleVersion := binary.LittleEndian.Uint16(header[0x2a:])
+    flags := binary.LittleEndian.Uint16(header[0x2c:])
+
+    logger.Printf("Orders %v instruments %v samples %v patterns %v", orderCount, instrumentCount, sampleCount, patternCount)
+    logger.Printf("Tracker version 0x%x compatible with 0x%x flags 0x%x", trackerVersion, compatibleVersion, flags)
+
+    file := ITFile{
+        Name: string(name),
+        GlobalVolume: min(header[0x30], 128),
+        MixVolume: min(header[0x31], 128),
+        InitialSpeed: header[0x32],
+        InitialTempo: header[0x33],
+        PanSeparation: min(header[0x34], 128),
+        Stereo: flags & 1 != 0,
+        UseInstruments: flags & 4 != 0,
+        LinearSlides: flags & 8 != 0,
+        OldEffects: flags & 16 != 0,
+        LinkedPortamento: flags & 32 != 0,
+    }
+
+    if file.InitialSpeed == 0 {
+        file.InitialSpeed = 6
+    }
+    if file.InitialTempo < 32 {
+        file.InitialTempo = 125
+    }
+
+    copy(file.ChannelPanning[:], header[0x40:0x80])
+    copy(file.ChannelVolume[:], header[0x80:0xc0])
+    for i := range file.ChannelVolume {
+        file.ChannelVolume[i] = min(file.ChannelVolume[i], 64)
+    }
+
+    logger.Printf("Speed %v tempo %v global volume %v mix volume %v", file.InitialSpeed, file.InitialTempo, file.GlobalVolume, file.MixVolume)
+
+    buffer := bufio.NewReader(reader)
+
+    orders := make([]byte, orderCount)
+    _, err = io.ReadFull(buffer, orders)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading orders: %v", err)
+    }
+
+    // 254 is a marker that is skipped and 255 is the end of the song
+    for _, order := range orders {
+        if order == 255 {
+            break
+        }
+        if order == 254 {
+            continue
+        }
+        file.Orders = append(file.Orders, order)
+    }
+
+    if len(file.Orders) == 0 {
+        return nil, fmt.Errorf("Song has no orders")
+    }
+
+    readOffsets := func(count int) ([]uint32, error) {
+        offsets := make([]uint32, count)
+        err := binary.Read(buffer, binary.LittleEndian, offsets)
+        return offsets, err
+    }
+
+    instrumentOffsets, err := readOffsets(instrumentCount)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading instrument offsets: %v", err)
+    }
+
+    sampleOffsets, err := readOffsets(sampleCount)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading sample offsets: %v", err)
+    }
+
+    patternOffsets, err := readOffsets(patternCount)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading pattern offsets: %v", err)
+    }
+
+    if file.UseInstruments {
+        for i, offset := range instrumentOffsets {
+            var instrument *Instrument
+            if compatibleVersion >= 0x200 {
+                instrument, err = readInstrument(reader, offset, logger)
+            } else {
+                instrument, err = readOldInstrument(reader, offset, logger)
+            }
+
+            if err != nil {
+                return nil, fmt.Errorf("Error reading instrument %v: %v", i, err)
+            }
+
+            file.Instruments = append(file.Instruments, instrument)
+        }
+    }
+
+    for i, offset := range sampleOffsets {
+        sample, err := readSample(reader, offset, logger)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading sample %v: %v", i, err)
+        }
+
+        file.Samples = append(file.Samples, sample)
+    }
+
+    for i, offset := range patternOffsets {
+        pattern, err := readPattern(reader, offset)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading pattern %v: %v", i, err)
+        }
+
+        for _, row := range pattern.Rows {
+            for channel := range row {
+                if row[channel] != (Note{}) {
+                    file.Channels = max(file.Channels, channel + 1)
+                }
+            }
+        }
+
+        file.Patterns = append(file.Patterns, pattern)
+    }
+
+    // orders may refer to patterns that don't exist, which play as empty patterns
+    for _, order := range file.Orders {
+        for int(order) >= len(file.Patterns) {
+            pattern, _ := readPattern(reader, 0)
+            file.Patterns = append(file.Patterns, pattern)
+        }
+    }
+
+    file.Channels = max(file.Channels, 1)
+    for i := range file.Patterns {
+        for row := range file.Patterns[i].Rows {
+            file.Patterns[i].Rows[row] = file.Patterns[i].Rows[row][:file.Channels]
+        }
+    }
+
+    logger.Printf("Channels in use: %v", file.Channels)
+
+    return &file, nil
+}
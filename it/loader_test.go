@@ -0,0 +1,78 @@
+package it
+
+import (
+    "testing"
+    "bytes"
+)
+
+func TestReadPattern(test *testing.T) {
+    // the first row has channel 1 with a note, instrument, volume and effect, and channel 2
+    // with only the last note it had. the second row repeats every value of channel 1
+    packed := []byte{
+        0x81, 0x0f, 60, 1, 32, 1, 6,
+        0x82, 0x10,
+        0,
+        0x81, 0xf0,
+        0,
+    }
+
+    data := []byte{byte(len(packed)), 0, 2, 0, 0, 0, 0, 0}
+    data = append(data, packed...)
+
+    // the pattern starts after some other data
+    data = append([]byte{1, 2, 3}, data...)
+
+    pattern, err := readPattern(bytes.NewReader(data), 3)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    if len(pattern.Rows) != 2 {
+        test.Fatalf("expected 2 rows, got %v", len(pattern.Rows))
+    }
+
+    first := pattern.Rows[0][0]
+    if !first.HasNote || first.Note != 60 || first.Instrument != 1 || first.Volume != 32 || first.Effect != 1 || first.EffectParameter != 6 {
+        test.Errorf("unexpected first note %+v", first)
+    }
+
+    // 0x10 is the last note of channel 2, which it never had
+    if second := pattern.Rows[0][1]; !second.HasNote || second.Note != 0 || second.HasInstrument {
+        test.Errorf("unexpected second note %+v", second)
+    }
+
+    if again := pattern.Rows[1][0]; again != first {
+        test.Errorf("expected the second row to repeat %+v, got %+v", first, again)
+    }
+
+    // a pattern with less data than its header says is an error
+    _, err = readPattern(bytes.NewReader(data[:3 + 8 + 4]), 3)
+    if err == nil {
+        test.Errorf("expected an error for a cut off pattern")
+    }
+
+    // an offset of 0 is an empty pattern
+    empty, err := readPattern(bytes.NewReader(nil), 0)
+    if err != nil || len(empty.Rows) != 64 {
+        test.Errorf("expected an empty pattern of 64 rows, got %v rows: %v", len(empty.Rows), err)
+    }
+}
+
+func TestReadSampleData(test *testing.T) {
+    data, err := readSampleData(bytes.NewReader([]byte{0, 0x40, 0xc0}), 3, false, true, false)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    for i, expected := range []float32{0, 0.5, -0.5} {
+        if data[i] != expected {
+            test.Errorf("sample %v: expected %v, got %v", i, expected, data[i])
+        }
+    }
+
+    // a length much larger than the data is an error, not an allocation of the whole length
+    _, err = readSampleData(bytes.NewReader([]byte{1, 2, 3}), 0x7ffffff0, true, true, false)
+    if err == nil {
+        test.Errorf("expected an error for a sample that is cut off")
+    }
+}
@@ -0,0 +1,1727 @@
+package it
+
+import (
+    "io"
+    "log"
+    "math"
+    "math/rand/v2"
+    "runtime"
+    "slices"
+
+    "github.com/kazzmir/tracker/common"
+)
+
+// the most notes that can play at once, counting the ones that new note actions left
+// playing in the background
+const MaxVoices = 256
+
+// the period of a note is amigaClock / frequency, which makes C-5 at 8363hz period 1712
+const amigaClock = 14317056.0
+
+// the tone portamento speeds of the volume column g command
+var volumePortamentoSpeeds = []int{0, 1, 4, 8, 16, 32, 64, 96, 128, 255}
+
+// the playback rate of a note, where note 60 is C-5 and plays at the sample's C5 speed
+func noteFrequency(note int, c5Speed uint32) float64 {
+    return float64(c5Speed) * math.Pow(2, float64(note - 60) / 12)
+}
+
+type Vibrato struct {
+    common.Oscillator
+    // Uxy is 4 times finer than Hxy
+    Fine bool
+}
+
+// move the frequency by the vibrato. Hxy moves the pitch by up to 4 slide units per step of depth
+func (vibrato *Vibrato) Apply(frequency float64, player *Player) float64 {
+    scale := 4.0
+    if vibrato.Fine {
+        scale = 1
+    }
+
+    // old effects vibrato is twice as deep, the same as scream tracker
+    if player.File.OldEffects {
+        scale *= 2
+    }
+
+    return player.slideFrequency(frequency, vibrato.Value() * float64(vibrato.Depth) * scale)
+}
+
+type Tremolo struct {
+    common.Oscillator
+}
+
+func (tremolo *Tremolo) Apply(volume int) int {
+    return max(0, min(64, volume + int(tremolo.Value() * float64(tremolo.Depth) * 4)))
+}
+
+type Panbrello struct {
+    common.Oscillator
+}
+
+func (panbrello *Panbrello) Apply(pan int) int {
+    return max(0, min(64, pan + int(panbrello.Value() * float64(panbrello.Depth) * 2)))
+}
+
+// the playback position of a voice within an instrument envelope
+type EnvelopeState struct {
+    Tick int
+    // starts out as the instrument's setting, S77-S7C can switch it for the current note
+    Enabled bool
+}
+
+func (state *EnvelopeState) Reset(envelope *Envelope) {
+    state.Tick = 0
+    state.Enabled = envelope.Enabled
+}
+
+// advance the envelope by one tick. the sustain loop only holds while the note is not released.
+// returns true once the envelope has played to its last node
+func (state *EnvelopeState) Update(envelope *Envelope, released bool) bool {
+    if !state.Enabled || len(envelope.Nodes) == 0 {
+        return false
+    }
+
+    nodes := envelope.Nodes
+    sustained := envelope.Sustain && !released
+
+    state.Tick += 1
+
+    if sustained {
+        if state.Tick > int(nodes[envelope.SustainEnd].Tick) {
+            state.Tick = int(nodes[envelope.SustainStart].Tick)
+        }
+    } else if envelope.Loop {
+        if state.Tick > int(nodes[envelope.LoopEnd].Tick) {
+            state.Tick = int(nodes[envelope.LoopStart].Tick)
+        }
+    }
+
+    last := int(nodes[len(nodes) - 1].Tick)
+    if state.Tick >= last {
+        state.Tick = last
+        return !sustained && !envelope.Loop
+    }
+
+    return false
+}
+
+// the envelope value at the current tick, linearly interpolated between nodes
+func (state *EnvelopeState) Value(envelope *Envelope) float32 {
+    nodes := envelope.Nodes
+
+    if len(nodes) == 0 {
+        return 0
+    }
+
+    if state.Tick <= int(nodes[0].Tick) {
+        return float32(nodes[0].Value)
+    }
+
+    for i := range len(nodes) - 1 {
+        start := nodes[i]
+        end := nodes[i+1]
+        if state.Tick >= int(start.Tick) && state.Tick < int(end.Tick) {
+            amount := float32(state.Tick - int(start.Tick)) / float32(end.Tick - start.Tick)
+            return float32(start.Value) + float32(end.Value - start.Value) * amount
+        }
+    }
+
+    return float32(nodes[len(nodes) - 1].Value)
+}
+
+// the playback state of a sample's auto vibrato
+type AutoVibratoState struct {
+    Position int // 0-255 within the waveform
+    Depth int // the current depth scaled by 256, which ramps up at the sample's vibrato rate
+    random float64
+}
+
+func (state *AutoVibratoState) Update(sample *Sample) {
+    state.Position = (state.Position + int(sample.VibratoSpeed)) & 0xff
+    state.Depth = min(int(sample.VibratoDepth) << 8, state.Depth + int(sample.VibratoRate))
+    if sample.VibratoType == AutoVibratoRandom {
+        state.random = rand.Float64() * 2 - 1
+    }
+}
+
+// the pitch offset in slide units, at full depth 64 it is half a semitone with linear slides
+func (state *AutoVibratoState) Value(sample *Sample) float64 {
+    if sample.VibratoDepth == 0 || sample.VibratoSpeed == 0 {
+        return 0
+    }
+
+    var wave float64
+    switch sample.VibratoType {
+        case AutoVibratoSine:
+            wave = math.Sin(float64(state.Position) * 2 * math.Pi / 256)
+        case AutoVibratoRampDown:
+            wave = 1 - float64(state.Position) / 128
+        case AutoVibratoSquare:
+            if state.Position < 128 {
+                wave = 1
+            } else {
+                wave = -1
+            }
+        case AutoVibratoRandom:
+            wave = state.random
+    }
+
+    return wave * float64(state.Depth) / 256 / 2
+}
+
+// a note that is playing. the channel that started it controls it until the next note, then
+// the new note action decides whether it carries on in the background
+type Voice struct {
+    Instrument *Instrument // nil in sample mode
+    Sample *Sample
+    Note int // the note in the pattern, before the instrument keyboard mapped it
+
+    // set by the channel while the voice is in the foreground, background voices keep
+    // the last values
+    Frequency float64
+    Volume int // 0-64
+    ChannelVolume int // 0-64
+    Pan int // 0-64
+
+    NewNoteAction uint8
+
+    position float64
+    // true while a ping-pong loop is playing the sample in reverse
+    backwards bool
+
+    // the note was let go, so the sustain loops end
+    Released bool
+    Fading bool
+    FadeVolume int // 0-1024
+
+    VolumeEnvelope EnvelopeState
+    PanningEnvelope EnvelopeState
+    PitchEnvelope EnvelopeState
+    AutoVibrato AutoVibratoState
+
+    // the sample played to its end or the note was cut
+    Finished bool
+}
+
+func makeVoice(instrument *Instrument, sample *Sample, note int) *Voice {
+    voice := &Voice{
+        Instrument: instrument,
+        Sample: sample,
+        Note: note,
+        FadeVolume: 1024,
+        NewNoteAction: NewNoteActionCut,
+    }
+
+    if instrument != nil {
+        voice.NewNoteAction = instrument.NewNoteAction
+        voice.VolumeEnvelope.Reset(&instrument.VolumeEnvelope)
+        voice.PanningEnvelope.Reset(&instrument.PanningEnvelope)
+        voice.PitchEnvelope.Reset(&instrument.PitchEnvelope)
+    }
+
+    return voice
+}
+
+// let go of the note. without a volume envelope, or with one that loops, the note also fades out
+func (voice *Voice) Release() {
+    voice.Released = true
+
+    instrument := voice.Instrument
+    if instrument != nil && (!voice.VolumeEnvelope.Enabled || instrument.VolumeEnvelope.Loop) {
+        voice.Fading = true
+    }
+}
+
+// apply one of the new note actions, which are also used by the duplicate check and S70-S72
+func (voice *Voice) doAction(action uint8) {
+    switch action {
+        case NewNoteActionCut: voice.Finished = true
+        case NewNoteActionOff: voice.Release()
+        case NewNoteActionFade: voice.Fading = true
+    }
+}
+
+// play the sample again from the start
+func (voice *Voice) restart() {
+    voice.position = 0
+    voice.backwards = false
+    voice.Finished = false
+}
+
+func (voice *Voice) UpdateTick() {
+    if voice.Finished {
+        return
+    }
+
+    voice.AutoVibrato.Update(voice.Sample)
+
+    instrument := voice.Instrument
+    if instrument == nil {
+        return
+    }
+
+    if voice.VolumeEnvelope.Update(&instrument.VolumeEnvelope, voice.Released) {
+        // the note fades out once the volume envelope ends, and stops if it ended at 0
+        voice.Fading = true
+        if voice.VolumeEnvelope.Value(&instrument.VolumeEnvelope) == 0 {
+            voice.Finished = true
+        }
+    }
+
+    voice.PanningEnvelope.Update(&instrument.PanningEnvelope, voice.Released)
+    voice.PitchEnvelope.Update(&instrument.PitchEnvelope, voice.Released)
+
+    // a fadeout of 0 never fades
+    if voice.Fading && instrument.Fadeout > 0 {
+        voice.FadeVolume = max(0, voice.FadeVolume - instrument.Fadeout)
+        if voice.FadeVolume == 0 {
+            voice.Finished = true
+        }
+    }
+}
+
+// the volume 0-1 of the voice before the global volume
+func (voice *Voice) getVolume() float32 {
+    volume := float32(voice.Volume) / 64 * float32(voice.Sample.GlobalVolume) / 64 * float32(voice.ChannelVolume) / 64
+
+    if instrument := voice.Instrument; instrument != nil {
+        volume *= float32(instrument.GlobalVolume) / 128
+        if voice.VolumeEnvelope.Enabled {
+            volume *= voice.VolumeEnvelope.Value(&instrument.VolumeEnvelope) / 64
+        }
+        volume *= float32(voice.FadeVolume) / 1024
+    }
+
+    return volume
+}
+
+// the panning of the voice, 0 is full left, 0.5 is center and 1 is full right
+func (voice *Voice) getPanning(player *Player) float32 {
+    if !player.File.Stereo {
+        return 0.5
+    }
+
+    pan := float32(voice.Pan)
+
+    if instrument := voice.Instrument; instrument != nil {
+        // notes above the pitch pan center move one way and notes below it the other
+        pan += float32((voice.Note - int(instrument.PitchPanCenter)) * int(instrument.PitchPanSeparation)) / 8
+        pan = max(0, min(64, pan))
+
+        if voice.PanningEnvelope.Enabled {
+            // the envelope can only move the pan as far as the nearest edge allows
+            envelope := voice.PanningEnvelope.Value(&instrument.PanningEnvelope)
+            pan += envelope * (32 - float32(math.Abs(float64(pan - 32)))) / 32
+        }
+    }
+
+    // the pan separation narrows the stereo image
+    pan = 32 + (pan - 32) * float32(player.File.PanSeparation) / 128
+
+    return max(0, min(1, pan / 64))
+}
+
+func (voice *Voice) getFrequency(player *Player) float64 {
+    frequency := player.slideFrequency(voice.Frequency, voice.AutoVibrato.Value(voice.Sample))
+
+    if instrument := voice.Instrument; instrument != nil && voice.PitchEnvelope.Enabled && !instrument.PitchEnvelope.Filter {
+        // the pitch envelope goes from -32 to 32 in half semitones
+        frequency *= math.Pow(2, float64(voice.PitchEnvelope.Value(&instrument.PitchEnvelope)) / 24)
+    }
+
+    return frequency
+}
+
+// keep the playback position inside the sample, following the sustain loop until the note is
+// released and the normal loop after that. returns false once the sample has played to the end
+func (voice *Voice) wrapPosition() bool {
+    sample := voice.Sample
+
+    var loopStart, loopEnd float64
+    pingPong := false
+    if sample.SustainLoop && !voice.Released {
+        loopStart = float64(sample.SustainLoopStart)
+        loopEnd = float64(sample.SustainLoopEnd)
+        pingPong = sample.PingPongSustainLoop
+    } else if sample.Loop {
+        loopStart = float64(sample.LoopStart)
+        loopEnd = float64(sample.LoopEnd)
+        pingPong = sample.PingPongLoop
+    } else {
+        return voice.position >= 0 && int(voice.position) < len(sample.Data)
+    }
+
+    if !pingPong {
+        voice.backwards = false
+        if voice.position >= loopEnd {
+            voice.position = loopStart + math.Mod(voice.position - loopEnd, loopEnd - loopStart)
+        }
+    } else {
+        // bounce off either end of the loop, reflecting however far the position overshot
+        for voice.position >= loopEnd || (voice.backwards && voice.position < loopStart) {
+            if voice.position >= loopEnd {
+                voice.position = max(loopStart, loopEnd - (voice.position - loopEnd) - 1)
+                voice.backwards = true
+            } else {
+                voice.position = min(loopEnd - 1, loopStart + (loopStart - voice.position))
+                voice.backwards = false
+            }
+        }
+    }
+
+    return voice.position >= 0 && int(voice.position) < len(sample.Data)
+}
+
+// add the voice to a buffer of interleaved stereo samples
+func (voice *Voice) mix(out []float32, player *Player) {
+    if voice.Finished {
+        return
+    }
+
+    increment := voice.getFrequency(player) / float64(player.SampleRate)
+    if increment <= 0 {
+        return
+    }
+
+    volume := voice.getVolume() * float32(player.GlobalVolume) / 128
+    leftPan, rightPan := player.PanLaw.Gains(voice.getPanning(player))
+
+    for i := 0; i < len(out); i += 2 {
+        if !voice.wrapPosition() {
+            voice.Finished = true
+            break
+        }
+
+        sample := voice.Sample.Data[int(voice.position)] * volume
+        out[i] += sample * leftPan
+        out[i+1] += sample * rightPan
+
+        if voice.backwards {
+            voice.position -= increment
+        } else {
+            voice.position += increment
+        }
+    }
+}
+
+type Channel struct {
+    Player *Player
+    Channel int
+    AudioBuffer *common.AudioBuffer
+    ScopeBuffer *common.AudioBuffer
+    Volume float32
+    buffer []float32 // used for reading audio data
+    mix []float32 // the voices are mixed here before going to the audio buffer
+    Mute bool
+
+    // the note that the channel's effects control
+    Voice *Voice
+    // notes that the new note actions left playing, they are heard on this channel
+    Background []*Voice
+
+    InstrumentNumber int // 1-based, a sample number in sample mode
+    Frequency float64 // the pitch of the note after slides
+    PortamentoTarget float64
+    NoteVolume int // 0-64
+    ChannelVolume int // 0-64
+    Pan int // 0-64
+
+    CurrentEffect int
+    EffectParameter int
+    // the volume column byte, if it holds a command rather than a volume
+    VolumeCommand int
+
+    Vibrato Vibrato
+    Tremolo Tremolo
+    Panbrello Panbrello
+    // S1x, tone portamento moves in semitones
+    Glissando bool
+
+    // effect memory, a parameter of 0 reuses the last non-zero parameter
+    VolumeSlideMemory int // D, K and L
+    PitchSlideMemory int // E and F, and G if the song links them
+    PortamentoMemory int // G
+    TremorMemory int
+    ArpeggioMemory int
+    ChannelVolumeSlideMemory int
+    SampleOffsetMemory int
+    PanningSlideMemory int
+    RetriggerMemory int
+    SpecialMemory int
+    TempoMemory int
+    GlobalVolumeSlideMemory int
+    VolumeColumnMemory int // a, b, c and d in the volume column
+
+    // SAx, the sample offset above 64k
+    HighOffset int
+
+    TremorCount int
+    RetriggerCount int
+    // the tick of the row that SCx cuts the note on
+    NoteCut int
+    // a note held back by SDx, played once the delay tick is reached
+    DelayedNote *Note
+    NoteDelay int
+
+    // SBx pattern loop state, each channel has its own loop
+    LoopRow int
+    LoopCount int
+
+    // ticks since the start of the row
+    rowTick int
+    currentRow int
+}
+
+// the foreground voice followed by the background voices
+func (channel *Channel) voices() []*Voice {
+    var voices []*Voice
+    if channel.Voice != nil {
+        voices = append(voices, channel.Voice)
+    }
+    return append(voices, channel.Background...)
+}
+
+// a parameter of 0 gives the value in memory, anything else replaces it
+func remember(memory *int, parameter int) int {
+    if parameter == 0 {
+        return *memory
+    }
+
+    *memory = parameter
+    return parameter
+}
+
+// the parameter of an effect once its memory is applied
+func (channel *Channel) effectMemory(effect int, parameter int) int {
+    switch effect {
+        case EffectVolumeSlide, EffectVibratoVolumeSlide, EffectTonePortamentoVolumeSlide:
+            return remember(&channel.VolumeSlideMemory, parameter)
+        case EffectPortamentoDown, EffectPortamentoUp:
+            return remember(&channel.PitchSlideMemory, parameter)
+        case EffectTonePortamento:
+            if channel.Player.File.LinkedPortamento {
+                return remember(&channel.PitchSlideMemory, parameter)
+            }
+            return remember(&channel.PortamentoMemory, parameter)
+        case EffectTremor:
+            return remember(&channel.TremorMemory, parameter)
+        case EffectArpeggio:
+            return remember(&channel.ArpeggioMemory, parameter)
+        case EffectChannelVolumeSlide:
+            return remember(&channel.ChannelVolumeSlideMemory, parameter)
+        case EffectSampleOffset:
+            return remember(&channel.SampleOffsetMemory, parameter)
+        case EffectPanningSlide:
+            return remember(&channel.PanningSlideMemory, parameter)
+        case EffectRetrigger:
+            return remember(&channel.RetriggerMemory, parameter)
+        case EffectSpecial:
+            return remember(&channel.SpecialMemory, parameter)
+        case EffectTempo:
+            return remember(&channel.TempoMemory, parameter)
+        case EffectGlobalVolumeSlide:
+            return remember(&channel.GlobalVolumeSlideMemory, parameter)
+    }
+
+    return parameter
+}
+
+// the speed of tone portamento, shared with E and F if the song links them
+func (channel *Channel) portamentoSpeed() int {
+    if channel.Player.File.LinkedPortamento {
+        return channel.PitchSlideMemory
+    }
+    return channel.PortamentoMemory
+}
+
+// true if tone portamento is active from either the effect or the volume column
+func (channel *Channel) isTonePortamento() bool {
+    return channel.CurrentEffect == EffectTonePortamento || channel.CurrentEffect == EffectTonePortamentoVolumeSlide || (channel.VolumeCommand >= 193 && channel.VolumeCommand <= 202)
+}
+
+// true if vibrato is active from either the effect or the volume column
+func (channel *Channel) isVibrato() bool {
+    return channel.CurrentEffect == EffectVibrato || channel.CurrentEffect == EffectFineVibrato || channel.CurrentEffect == EffectVibratoVolumeSlide || (channel.VolumeCommand >= 203 && channel.VolumeCommand <= 212)
+}
+
+func (channel *Channel) UpdateRow() {
+    channel.currentRow = channel.Player.CurrentRow
+    channel.rowTick = 0
+    channel.DelayedNote = nil
+
+    note, ok := channel.Player.GetRowNote(channel.Channel, channel.currentRow)
+    if !ok {
+        return
+    }
+
+    // SDx holds back the whole row for this channel until tick x
+    if note.HasEffect && note.Effect == EffectSpecial {
+        parameter := int(note.EffectParameter)
+        if parameter == 0 {
+            parameter = channel.SpecialMemory
+        }
+
+        if parameter >> 4 == 0xd && parameter & 0xf > 0 {
+            channel.SpecialMemory = parameter
+            channel.DelayedNote = note
+            channel.NoteDelay = parameter & 0xf
+            channel.CurrentEffect = EffectNone
+            channel.VolumeCommand = 0
+            return
+        }
+    }
+
+    channel.playNote(note)
+}
+
+// decide what happens to the notes that are still playing when a new one starts
+func (channel *Channel) newNoteAction(instrument *Instrument, sample *Sample, note int) {
+    // the duplicate check looks at every note this channel started with the same instrument
+    if instrument != nil && instrument.DuplicateCheckType != DuplicateCheckOff {
+        for _, voice := range channel.voices() {
+            if voice.Instrument != instrument {
+                continue
+            }
+
+            duplicate := false
+            switch instrument.DuplicateCheckType {
+                case DuplicateCheckNote: duplicate = voice.Note == note
+                case DuplicateCheckSample: duplicate = voice.Sample == sample
+                case DuplicateCheckInstrument: duplicate = true
+            }
+
+            if duplicate {
+                voice.doAction(instrument.DuplicateCheckAction)
+            }
+        }
+    }
+
+    old := channel.Voice
+    channel.Voice = nil
+    if old == nil || old.Finished || old.NewNoteAction == NewNoteActionCut {
+        return
+    }
+
+    old.doAction(old.NewNoteAction)
+
+    // once every virtual channel is taken, the oldest note of this channel makes room
+    if channel.Player.countVoices() >= MaxVoices {
+        if len(channel.Background) == 0 {
+            return
+        }
+        channel.Background = channel.Background[1:]
+    }
+
+    channel.Background = append(channel.Background, old)
+}
+
+// start a note, unless the instrument has no sample for it
+func (channel *Channel) startNote(note int, resetVolume bool, sampleOffset int) {
+    player := channel.Player
+    instrument, sample, mappedNote := player.lookupNote(channel.InstrumentNumber, note)
+
+    channel.newNoteAction(instrument, sample, note)
+
+    if sample == nil || len(sample.Data) == 0 || sample.C5Speed == 0 {
+        return
+    }
+
+    voice := makeVoice(instrument, sample, note)
+    channel.Voice = voice
+    channel.Frequency = noteFrequency(mappedNote, sample.C5Speed)
+    channel.PortamentoTarget = channel.Frequency
+    channel.TremorCount = 0
+    channel.RetriggerCount = 0
+
+    if resetVolume {
+        channel.NoteVolume = int(sample.Volume)
+    }
+
+    // the instrument panning is used unless the sample has its own
+    if instrument != nil && instrument.HasDefaultPan() {
+        channel.Pan = min(64, int(instrument.DefaultPan))
+    }
+    if sample.HasDefaultPan() {
+        channel.Pan = min(64, int(sample.DefaultPan & 127))
+    }
+
+    if sampleOffset > 0 {
+        if sampleOffset < len(sample.Data) {
+            voice.position = float64(sampleOffset)
+        } else if player.File.OldEffects {
+            // old effects plays nothing when the offset is past the end
+            voice.Finished = true
+        }
+    }
+}
+
+func (channel *Channel) playNote(note *Note) {
+    player := channel.Player
+
+    channel.CurrentEffect = EffectNone
+    channel.EffectParameter = 0
+    channel.VolumeCommand = 0
+
+    if note.HasEffect {
+        channel.CurrentEffect = int(note.Effect)
+        channel.EffectParameter = channel.effectMemory(channel.CurrentEffect, int(note.EffectParameter))
+    }
+
+    if note.HasVolume && note.Volume > 64 {
+        channel.VolumeCommand = int(note.Volume)
+    }
+
+    if note.HasInstrument && note.Instrument > 0 {
+        channel.InstrumentNumber = int(note.Instrument)
+    }
+
+    sampleOffset := 0
+    if channel.CurrentEffect == EffectSampleOffset {
+        sampleOffset = channel.HighOffset << 16 | channel.EffectParameter << 8
+    }
+
+    if note.HasNote {
+        switch {
+            case note.Note == NoteOff:
+                if channel.Voice != nil {
+                    channel.Voice.Release()
+                }
+            case note.Note == NoteCut:
+                channel.Voice = nil
+            case note.Note >= 120:
+                if channel.Voice != nil {
+                    channel.Voice.Fading = true
+                }
+            case channel.isTonePortamento() && channel.Voice != nil && !channel.Voice.Finished:
+                // slide to the new note rather than playing it
+                _, sample, mappedNote := player.lookupNote(channel.InstrumentNumber, int(note.Note))
+                if sample != nil {
+                    channel.PortamentoTarget = noteFrequency(mappedNote, sample.C5Speed)
+                }
+                if note.HasInstrument {
+                    channel.NoteVolume = int(channel.Voice.Sample.Volume)
+                }
+            default:
+                channel.startNote(int(note.Note), note.HasInstrument, sampleOffset)
+        }
+    } else if note.HasInstrument && note.Instrument > 0 && channel.Voice != nil {
+        // an instrument on its own sets the volume back to the sample default
+        channel.NoteVolume = int(channel.Voice.Sample.Volume)
+    }
+
+    if note.HasVolume {
+        channel.doVolumeColumnRow(int(note.Volume))
+    }
+
+    parameter := channel.EffectParameter
+
+    switch channel.CurrentEffect {
+        case EffectNone:
+        case EffectSetSpeed:
+            if parameter > 0 {
+                player.Speed = parameter
+                if player.OnChangeSpeed != nil {
+                    player.OnChangeSpeed(player.Speed, player.BPM)
+                }
+            }
+        case EffectPositionJump:
+            player.DoJump = true
+            player.JumpOrder = parameter
+        case EffectPatternBreak:
+            player.DoBreak = true
+            player.BreakRow = parameter
+        case EffectVolumeSlide, EffectVibratoVolumeSlide, EffectTonePortamentoVolumeSlide:
+            channel.NoteVolume = max(0, min(64, channel.NoteVolume + slideAmount(parameter, true)))
+        case EffectPortamentoDown, EffectPortamentoUp:
+            direction := 1.0
+            if channel.CurrentEffect == EffectPortamentoDown {
+                direction = -1
+            }
+
+            // Fx is a fine slide and Ex is an extra fine slide, both only happen on the first tick
+            switch parameter >> 4 {
+                case 0xf: channel.Frequency = player.slideFrequency(channel.Frequency, direction * float64(parameter & 0xf) * 4)
+                case 0xe: channel.Frequency = player.slideFrequency(channel.Frequency, direction * float64(parameter & 0xf))
+            }
+        case EffectTonePortamento:
+        case EffectVibrato, EffectFineVibrato:
+            // each half of the parameter keeps its old value if it is 0
+            if parameter >> 4 > 0 {
+                channel.Vibrato.Speed = parameter >> 4
+            }
+            if parameter & 0xf > 0 {
+                channel.Vibrato.Depth = parameter & 0xf
+            }
+            channel.Vibrato.Fine = channel.CurrentEffect == EffectFineVibrato
+        case EffectTremor:
+        case EffectArpeggio:
+        case EffectChannelVolume:
+            if parameter <= 64 {
+                channel.ChannelVolume = parameter
+            }
+        case EffectChannelVolumeSlide:
+            channel.ChannelVolume = max(0, min(64, channel.ChannelVolume + slideAmount(parameter, true)))
+        case EffectSampleOffset:
+        case EffectPanningSlide:
+            // the high nibble slides left and the low nibble slides right
+            channel.Pan = max(0, min(64, channel.Pan - slideAmount(parameter, true)))
+        case EffectRetrigger:
+        case EffectTremolo:
+            if parameter >> 4 > 0 {
+                channel.Tremolo.Speed = parameter >> 4
+            }
+            if parameter & 0xf > 0 {
+                channel.Tremolo.Depth = parameter & 0xf
+            }
+        case EffectSpecial:
+            channel.doSpecial(parameter)
+        case EffectTempo:
+            // T0x and T1x slide the tempo on the following ticks
+            if parameter >= 0x20 {
+                player.BPM = parameter
+                if player.OnChangeSpeed != nil {
+                    player.OnChangeSpeed(player.Speed, player.BPM)
+                }
+            }
+        case EffectGlobalVolume:
+            if parameter <= 128 {
+                player.GlobalVolume = parameter
+            }
+        case EffectGlobalVolumeSlide:
+            player.GlobalVolume = max(0, min(128, player.GlobalVolume + slideAmount(parameter, true)))
+        case EffectSetPanning:
+            channel.Pan = (parameter * 64 + 127) / 255
+        case EffectPanbrello:
+            if parameter >> 4 > 0 {
+                channel.Panbrello.Speed = parameter >> 4
+            }
+            if parameter & 0xf > 0 {
+                channel.Panbrello.Depth = parameter & 0xf
+            }
+        case EffectMidiMacro:
+            // there is no midi output or resonant filter
+        default:
+            log.Printf("Channel %v unknown effect %v with parameter %v", channel.Channel, channel.CurrentEffect, parameter)
+    }
+}
+
+// the volume column commands that take effect when the row starts
+func (channel *Channel) doVolumeColumnRow(volume int) {
+    switch {
+        case volume <= 64:
+            channel.NoteVolume = volume
+        // a and b are fine volume slides
+        case volume <= 74:
+            channel.NoteVolume = min(64, channel.NoteVolume + remember(&channel.VolumeColumnMemory, volume - 65))
+        case volume <= 84:
+            channel.NoteVolume = max(0, channel.NoteVolume - remember(&channel.VolumeColumnMemory, volume - 75))
+        // c and d slide on the following ticks
+        case volume <= 94:
+            remember(&channel.VolumeColumnMemory, volume - 85)
+        case volume <= 104:
+            remember(&channel.VolumeColumnMemory, volume - 95)
+        // e and f are pitch slides that share the memory of E and F, at 4 times the parameter
+        case volume <= 114:
+            remember(&channel.PitchSlideMemory, (volume - 105) * 4)
+        case volume <= 124:
+            remember(&channel.PitchSlideMemory, (volume - 115) * 4)
+        case volume >= 128 && volume <= 192:
+            channel.Pan = volume - 128
+        case volume >= 193 && volume <= 202:
+            speed := volumePortamentoSpeeds[volume - 193]
+            if speed > 0 {
+                if channel.Player.File.LinkedPortamento {
+                    channel.PitchSlideMemory = speed
+                } else {
+                    channel.PortamentoMemory = speed
+                }
+            }
+        case volume >= 203 && volume <= 212:
+            if volume > 203 {
+                channel.Vibrato.Depth = volume - 203
+            }
+            channel.Vibrato.Fine = false
+    }
+}
+
+// the volume column commands that act on every tick except the first
+func (channel *Channel) doVolumeColumnTick() {
+    volume := channel.VolumeCommand
+    player := channel.Player
+
+    switch {
+        case volume >= 85 && volume <= 94:
+            channel.NoteVolume = min(64, channel.NoteVolume + channel.VolumeColumnMemory)
+        case volume >= 95 && volume <= 104:
+            channel.NoteVolume = max(0, channel.NoteVolume - channel.VolumeColumnMemory)
+        case volume >= 105 && volume <= 114:
+            channel.Frequency = player.slideFrequency(channel.Frequency, -float64(channel.PitchSlideMemory * 4))
+        case volume >= 115 && volume <= 124:
+            channel.Frequency = player.slideFrequency(channel.Frequency, float64(channel.PitchSlideMemory * 4))
+        case volume >= 193 && volume <= 202:
+            channel.doTonePortamento()
+        case volume >= 203 && volume <= 212:
+            channel.Vibrato.Update()
+    }
+}
+
+// the change of a Dxy style slide on one tick, where x slides up and y slides down. DxF and DFy
+// are fine slides that only happen on the first tick, the others happen on every other tick
+func slideAmount(parameter int, firstTick bool) int {
+    up := parameter >> 4
+    down := parameter & 0xf
+
+    switch {
+        case down == 0xf && up > 0:
+            if firstTick {
+                return up
+            }
+        case up == 0xf && down > 0:
+            if firstTick {
+                return -down
+            }
+        case firstTick:
+        case down == 0:
+            return up
+        case up == 0:
+            return -down
+    }
+
+    return 0
+}
+
+// the S effects that happen when the row starts
+func (channel *Channel) doSpecial(parameter int) {
+    player := channel.Player
+    value := parameter & 0xf
+
+    switch parameter >> 4 {
+        case 0x1:
+            channel.Glissando = value != 0
+        case 0x3:
+            channel.Vibrato.SetControl(value)
+        case 0x4:
+            channel.Tremolo.SetControl(value)
+        case 0x5:
+            channel.Panbrello.SetControl(value)
+        case 0x6:
+            // extra ticks for this row
+            player.TickDelay += value
+        case 0x7:
+            channel.doNoteControl(value)
+        case 0x8:
+            channel.Pan = (value * 64 + 7) / 15
+        case 0x9:
+            // surround is played in the center
+            if value == 1 {
+                channel.Pan = 32
+            }
+        case 0xa:
+            channel.HighOffset = value
+        case 0xb:
+            channel.doPatternLoop(value)
+        case 0xc:
+            // SC0 cuts on the first tick after the row starts, the same as SC1
+            channel.NoteCut = max(1, value)
+        case 0xe:
+            if player.PatternDelay == 0 {
+                player.PatternDelay = value
+            }
+        case 0x0, 0x2, 0xd, 0xf:
+            // the filter, finetune and macro settings don't apply, and note delay is handled with the row
+        default:
+            log.Printf("Unknown special effect %v with parameter %v", parameter >> 4, parameter)
+    }
+}
+
+// S7x, act on the notes in the background or change how the current note behaves
+func (channel *Channel) doNoteControl(value int) {
+    voice := channel.Voice
+
+    switch value {
+        // past note cut, off and fade
+        case 0, 1, 2:
+            actions := []uint8{NewNoteActionCut, NewNoteActionOff, NewNoteActionFade}
+            for _, background := range channel.Background {
+                background.doAction(actions[value])
+            }
+        // set the new note action of the current note
+        case 3, 4, 5, 6:
+            if voice != nil {
+                voice.NewNoteAction = uint8(value - 3)
+            }
+        // switch the envelopes of the current note off and on
+        case 7, 8, 9, 0xa, 0xb, 0xc:
+            if voice == nil || voice.Instrument == nil {
+                return
+            }
+
+            instrument := voice.Instrument
+            switch value {
+                case 7: voice.VolumeEnvelope.Enabled = false
+                case 8: voice.VolumeEnvelope.Enabled = len(instrument.VolumeEnvelope.Nodes) > 0
+                case 9: voice.PanningEnvelope.Enabled = false
+                case 0xa: voice.PanningEnvelope.Enabled = len(instrument.PanningEnvelope.Nodes) > 0
+                case 0xb: voice.PitchEnvelope.Enabled = false
+                case 0xc: voice.PitchEnvelope.Enabled = len(instrument.PitchEnvelope.Nodes) > 0
+            }
+    }
+}
+
+// SB0 marks the start of a loop, SBx with x > 0 jumps back to it x times
+func (channel *Channel) doPatternLoop(value int) {
+    player := channel.Player
+
+    if value == 0 {
+        channel.LoopRow = player.CurrentRow
+        return
+    }
+
+    if channel.LoopCount == 0 {
+        channel.LoopCount = value
+    } else {
+        channel.LoopCount -= 1
+    }
+
+    if channel.LoopCount > 0 {
+        player.DoLoop = true
+        player.LoopRow = channel.LoopRow
+    } else {
+        // once a loop is done the next one starts after it
+        channel.LoopRow = player.CurrentRow + 1
+    }
+}
+
+// Gxx moves the pitch by 4*xx slide units per tick towards the target note
+func (channel *Channel) doTonePortamento() {
+    target := channel.PortamentoTarget
+    if target <= 0 || channel.Frequency <= 0 {
+        return
+    }
+
+    speed := float64(channel.portamentoSpeed() * 4)
+    player := channel.Player
+
+    if channel.Frequency < target {
+        channel.Frequency = min(target, player.slideFrequency(channel.Frequency, speed))
+    } else if channel.Frequency > target {
+        channel.Frequency = max(target, player.slideFrequency(channel.Frequency, -speed))
+    }
+}
+
+// Qxy retriggers the note every y ticks and changes the volume by x
+func (channel *Channel) doRetrigger(ticks int) {
+    interval := channel.EffectParameter & 0xf
+    if interval == 0 {
+        return
+    }
+
+    channel.RetriggerCount += ticks
+    if channel.RetriggerCount < interval {
+        return
+    }
+    channel.RetriggerCount = 0
+
+    volume := channel.NoteVolume
+    switch channel.EffectParameter >> 4 {
+        case 0x1: volume -= 1
+        case 0x2: volume -= 2
+        case 0x3: volume -= 4
+        case 0x4: volume -= 8
+        case 0x5: volume -= 16
+        case 0x6: volume = volume * 2 / 3
+        case 0x7: volume = volume / 2
+        case 0x9: volume += 1
+        case 0xa: volume += 2
+        case 0xb: volume += 4
+        case 0xc: volume += 8
+        case 0xd: volume += 16
+        case 0xe: volume = volume * 3 / 2
+        case 0xf: volume = volume * 2
+    }
+    channel.NoteVolume = max(0, min(64, volume))
+
+    if channel.Voice != nil {
+        channel.Voice.restart()
+    }
+}
+
+// the number of tremor ticks the note is on and off, old effects adds one to each
+func (channel *Channel) tremorTimes() (int, int) {
+    on := channel.EffectParameter >> 4
+    off := channel.EffectParameter & 0xf
+    if channel.Player.File.OldEffects {
+        return on + 1, off + 1
+    }
+
+    return max(1, on), max(1, off)
+}
+
+func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
+    if !changeRow {
+        channel.rowTick += ticks
+    }
+
+    for _, voice := range channel.voices() {
+        voice.UpdateTick()
+    }
+
+    if channel.DelayedNote != nil {
+        if channel.rowTick >= channel.NoteDelay {
+            note := channel.DelayedNote
+            channel.DelayedNote = nil
+            channel.playNote(note)
+        }
+        return
+    }
+
+    player := channel.Player
+    parameter := channel.EffectParameter
+
+    if !changeRow {
+        channel.doVolumeColumnTick()
+    }
+
+    switch channel.CurrentEffect {
+        case EffectVolumeSlide:
+            if !changeRow {
+                channel.NoteVolume = max(0, min(64, channel.NoteVolume + slideAmount(parameter, false)))
+            }
+        case EffectPortamentoDown:
+            if !changeRow && parameter < 0xe0 {
+                channel.Frequency = player.slideFrequency(channel.Frequency, -float64(parameter * 4 * ticks))
+            }
+        case EffectPortamentoUp:
+            if !changeRow && parameter < 0xe0 {
+                channel.Frequency = player.slideFrequency(channel.Frequency, float64(parameter * 4 * ticks))
+            }
+        case EffectTonePortamento:
+            if !changeRow {
+                channel.doTonePortamento()
+            }
+        case EffectVibrato, EffectFineVibrato:
+            channel.Vibrato.Update()
+        case EffectVibratoVolumeSlide:
+            channel.Vibrato.Update()
+            if !changeRow {
+                channel.NoteVolume = max(0, min(64, channel.NoteVolume + slideAmount(parameter, false)))
+            }
+        case EffectTonePortamentoVolumeSlide:
+            if !changeRow {
+                channel.doTonePortamento()
+                channel.NoteVolume = max(0, min(64, channel.NoteVolume + slideAmount(parameter, false)))
+            }
+        case EffectTremor:
+            // the tremor count keeps going from one row to the next
+            on, off := channel.tremorTimes()
+            channel.TremorCount = (channel.TremorCount + ticks) % (on + off)
+        case EffectChannelVolumeSlide:
+            if !changeRow {
+                channel.ChannelVolume = max(0, min(64, channel.ChannelVolume + slideAmount(parameter, false)))
+            }
+        case EffectPanningSlide:
+            if !changeRow {
+                channel.Pan = max(0, min(64, channel.Pan - slideAmount(parameter, false)))
+            }
+        case EffectRetrigger:
+            channel.doRetrigger(ticks)
+        case EffectTremolo:
+            channel.Tremolo.Update()
+        case EffectSpecial:
+            if parameter >> 4 == 0xc && channel.NoteCut > 0 && channel.rowTick >= channel.NoteCut {
+                channel.NoteVolume = 0
+                channel.NoteCut = 0
+            }
+        case EffectTempo:
+            if !changeRow && parameter < 0x20 {
+                if parameter >> 4 == 0 {
+                    player.BPM = max(32, player.BPM - (parameter & 0xf))
+                } else {
+                    player.BPM = min(255, player.BPM + (parameter & 0xf))
+                }
+                if player.OnChangeSpeed != nil {
+                    player.OnChangeSpeed(player.Speed, player.BPM)
+                }
+            }
+        case EffectGlobalVolumeSlide:
+            if !changeRow {
+                player.GlobalVolume = max(0, min(128, player.GlobalVolume + slideAmount(parameter, false)))
+            }
+        case EffectPanbrello:
+            channel.Panbrello.Update()
+    }
+}
+
+// the pitch of the current note with glissando, arpeggio and vibrato applied
+func (channel *Channel) getFrequency() float64 {
+    frequency := channel.Frequency
+    player := channel.Player
+
+    if channel.Glissando && channel.isTonePortamento() && channel.Voice != nil {
+        // round to the nearest semitone of the sample
+        c5Speed := float64(channel.Voice.Sample.C5Speed)
+        semitones := math.Round(12 * math.Log2(frequency / c5Speed))
+        frequency = c5Speed * math.Pow(2, semitones / 12)
+    }
+
+    if channel.CurrentEffect == EffectArpeggio {
+        // Jxy cycles between the note, the note + x semitones and the note + y semitones
+        semitones := 0
+        switch channel.rowTick % 3 {
+            case 1: semitones = channel.EffectParameter >> 4
+            case 2: semitones = channel.EffectParameter & 0xf
+        }
+        frequency *= math.Pow(2, float64(semitones) / 12)
+    }
+
+    if channel.isVibrato() {
+        frequency = channel.Vibrato.Apply(frequency, player)
+    }
+
+    return frequency
+}
+
+// the note volume 0-64 with tremor and tremolo applied
+func (channel *Channel) getVolume() int {
+    switch channel.CurrentEffect {
+        case EffectTremor:
+            on, _ := channel.tremorTimes()
+            if channel.TremorCount >= on {
+                return 0
+            }
+        case EffectTremolo:
+            return channel.Tremolo.Apply(channel.NoteVolume)
+    }
+
+    return channel.NoteVolume
+}
+
+func (channel *Channel) getPan() int {
+    if channel.CurrentEffect == EffectPanbrello {
+        return channel.Panbrello.Apply(channel.Pan)
+    }
+
+    return channel.Pan
+}
+
+func (channel *Channel) Update(rate float32) {
+    samples := int(float32(channel.Player.SampleRate) * rate)
+
+    if cap(channel.mix) < samples * 2 {
+        channel.mix = make([]float32, samples * 2)
+    }
+    mix := channel.mix[:samples * 2]
+    for i := range mix {
+        mix[i] = 0
+    }
+
+    if voice := channel.Voice; voice != nil {
+        voice.Frequency = channel.getFrequency()
+        voice.Volume = channel.getVolume()
+        voice.ChannelVolume = channel.ChannelVolume
+        voice.Pan = channel.getPan()
+    }
+
+    for _, voice := range channel.voices() {
+        voice.mix(mix, channel.Player)
+    }
+
+    channel.Background = slices.DeleteFunc(channel.Background, func(voice *Voice) bool {
+        return voice.Finished
+    })
+
+    channel.AudioBuffer.Lock()
+    channel.ScopeBuffer.Lock()
+
+    for _, value := range mix {
+        value = max(-1, min(1, value * channel.Volume))
+        channel.AudioBuffer.UnsafeWrite(value)
+        channel.ScopeBuffer.UnsafeWrite(value)
+    }
+
+    channel.AudioBuffer.Unlock()
+    channel.ScopeBuffer.Unlock()
+}
+
+func (channel *Channel) Read(data []byte) (int, error) {
+    if channel.Mute {
+        for i := 0; i < len(data); i++ {
+            data[i] = 0
+        }
+        channel.AudioBuffer.Clear()
+        return len(data), nil
+    }
+
+    samples := len(data) / 4
+
+    if samples > len(channel.buffer) {
+        samples = len(channel.buffer)
+    }
+
+    part := channel.buffer[:samples]
+    floatSamples := channel.AudioBuffer.Read(part)
+
+    i := 0
+    for sampleIndex := range floatSamples {
+        value := part[sampleIndex]
+        bits := math.Float32bits(value)
+        data[i*4+0] = byte(bits)
+        data[i*4+1] = byte(bits >> 8)
+        data[i*4+2] = byte(bits >> 16)
+        data[i*4+3] = byte(bits >> 24)
+
+        i += 1
+    }
+
+    i *= 4
+
+    // in a browser we have to return something, so we generate some silence
+    if i == 0 && runtime.GOOS == "js" {
+        for i < 8 {
+            data[i] = 0
+            i += 1
+        }
+        return 8, nil
+    } else {
+        // on a normal os we can just return 0 if necessary
+        return floatSamples * 4, nil
+    }
+}
+
+type Player struct {
+    File *ITFile
+    SampleRate int
+    Order int
+    ticks float32
+    CurrentRow int
+    BPM int
+    Speed int
+    OrdersPlayed int // How many orders have been played so far
+    // tells when playback comes back around to somewhere it has been
+    loops common.LoopDetector
+    // true after the song has started over
+    SongLooped bool
+
+    GlobalVolume int // 0-128
+    PanLaw common.PanLaw
+
+    DoBreak bool
+    BreakRow int // The row to break at, if DoBreak is true
+    DoJump bool
+    JumpOrder int // The order to jump to at the end of the row, if DoJump is true
+
+    // jump back to LoopRow at the end of the current row, the loop itself is kept by the channels
+    DoLoop bool
+    LoopRow int
+
+    // SEx repeats the current row this many more times
+    PatternDelay int
+    // S6x adds ticks to the current row
+    TickDelay int
+
+    Channels []*Channel
+
+    OnChangeRow func(row int)
+    OnChangeOrder func(order int, pattern int)
+    OnChangeSpeed func(speed int, bpm int)
+}
+
+func MakePlayer(file *ITFile, sampleRate int) *Player {
+    player := &Player{
+        File: file,
+        BPM: int(file.InitialTempo),
+        Speed: int(file.InitialSpeed),
+        SampleRate: sampleRate,
+        GlobalVolume: int(file.GlobalVolume),
+        loops: common.MakeLoopDetector(),
+    }
+
+    for channelNum := range file.Channels {
+        pan := int(file.ChannelPanning[channelNum] & 127)
+        // surround is played in the center
+        if pan > 64 {
+            pan = 32
+        }
+
+        player.Channels = append(player.Channels, &Channel{
+            Player: player,
+            Channel: channelNum,
+            AudioBuffer: common.MakeAudioBuffer(sampleRate * 2),
+            ScopeBuffer: common.MakeAudioBuffer(sampleRate * 2 / 10),
+            Volume: 1.0,
+            NoteVolume: 64,
+            ChannelVolume: int(file.ChannelVolume[channelNum]),
+            Pan: pan,
+            // channels that are switched off in the song start out muted
+            Mute: file.ChannelPanning[channelNum] & 128 != 0,
+            buffer: make([]float32, sampleRate),
+            currentRow: -1,
+        })
+    }
+
+    return player
+}
+
+// move a frequency by a number of slide units, positive is a higher pitch. with linear slides
+// a unit is 1/64th of a semitone, otherwise it is one period unit
+func (player *Player) slideFrequency(frequency float64, amount float64) float64 {
+    if frequency <= 0 || amount == 0 {
+        return frequency
+    }
+
+    if player.File.LinearSlides {
+        return frequency * math.Pow(2, amount / 768)
+    }
+
+    period := max(1, amigaClock / frequency - amount)
+    return amigaClock / period
+}
+
+// the instrument, sample and mapped note that a note plays. in sample mode the instrument
+// number picks the sample directly
+func (player *Player) lookupNote(number int, note int) (*Instrument, *Sample, int) {
+    if !player.File.UseInstruments {
+        return nil, player.GetSample(number - 1), note
+    }
+
+    instrument := player.GetInstrument(number - 1)
+    if instrument == nil || note < 0 || note >= len(instrument.Keyboard) {
+        return instrument, nil, note
+    }
+
+    entry := instrument.Keyboard[note]
+    mappedNote := int(entry.Note)
+    if mappedNote >= 120 {
+        mappedNote = note
+    }
+
+    return instrument, player.GetSample(int(entry.Sample) - 1), mappedNote
+}
+
+// the number of voices playing on all channels
+func (player *Player) countVoices() int {
+    total := 0
+    for _, channel := range player.Channels {
+        total += len(channel.voices())
+    }
+    return total
+}
+
+func (player *Player) Update(timeDelta float32) {
+    oldTicks := int(player.ticks)
+
+    if player.CurrentRow < 0 {
+        player.CurrentRow = 0
+    }
+
+    player.ticks += timeDelta * float32(player.BPM) * 2 / 5
+    newTicks := int(player.ticks)
+
+    // true if the row advanced, even if it went back to the same row because of a pattern loop
+    rowChanged := false
+
+    if player.ticks >= float32(player.Speed + player.TickDelay) {
+        player.ticks -= float32(player.Speed + player.TickDelay)
+        player.TickDelay = 0
+
+        if player.PatternDelay > 0 {
+            // repeat the row without playing its notes again
+            player.PatternDelay -= 1
+        } else {
+            player.CurrentRow += 1
+            rowChanged = true
+
+            if player.DoLoop {
+                player.CurrentRow = player.LoopRow
+                player.DoLoop = false
+            } else if player.DoJump || player.DoBreak {
+                // Bxx on its own starts the new pattern from the top
+                row := 0
+                if player.DoBreak {
+                    row = player.BreakRow
+                }
+
+                if player.DoJump {
+                    player.advanceOrder(player.JumpOrder, row)
+                } else {
+                    player.advanceOrder(player.Order + 1, row)
+                }
+
+                player.DoJump = false
+                player.DoBreak = false
+
+                if player.OnChangeOrder != nil {
+                    player.OnChangeOrder(player.Order, player.GetPattern())
+                }
+            }
+
+            if player.OnChangeRow != nil {
+                player.OnChangeRow(player.CurrentRow)
+            }
+        }
+    }
+
+    pattern := player.File.GetPattern(player.Order)
+    if pattern != nil && player.CurrentRow >= len(pattern.Rows) {
+        player.advanceOrder(player.Order + 1, 0)
+
+        if player.OnChangeOrder != nil {
+            player.OnChangeOrder(player.Order, player.GetPattern())
+        }
+    }
+
+    for _, channel := range player.Channels {
+        changeRow := false
+        if rowChanged || player.CurrentRow != channel.currentRow {
+            channel.UpdateRow()
+            changeRow = true
+        }
+
+        if newTicks != oldTicks {
+            channel.UpdateTick(changeRow, newTicks - oldTicks)
+        }
+
+        channel.Update(timeDelta)
+    }
+}
+
+func (player *Player) GetChannelReaders() []io.Reader {
+    var out []io.Reader
+    for _, channel := range player.Channels {
+        out = append(out, channel)
+    }
+    return out
+}
+
+func (player *Player) GetSpeed() int {
+    return player.Speed
+}
+
+func (player *Player) GetBPM() int {
+    return player.BPM
+}
+
+func (player *Player) GetName() string {
+    return player.File.Name
+}
+
+func (player *Player) GetInstrument(index int) *Instrument {
+    if index < 0 || index >= len(player.File.Instruments) {
+        return nil
+    }
+
+    return player.File.Instruments[index]
+}
+
+func (player *Player) GetSample(index int) *Sample {
+    if index < 0 || index >= len(player.File.Samples) {
+        return nil
+    }
+
+    return player.File.Samples[index]
+}
+
+func (player *Player) GetRowNote(channel int, row int) (*Note, bool) {
+    pattern := player.File.GetPattern(player.Order)
+    if pattern == nil || row < 0 || row >= len(pattern.Rows) {
+        return nil, false
+    }
+
+    notes := pattern.Rows[row]
+    if channel < 0 || channel >= len(notes) {
+        return nil, false
+    }
+
+    return &notes[channel], true
+}
+
+func (player *Player) GetRowNoteInfo(channel int, row int) (common.NoteInfo, bool) {
+    note, ok := player.GetRowNote(channel, row)
+    if !ok {
+        return nil, false
+    }
+    return note, true
+}
+
+func (player *Player) GetCurrentOrder() int {
+    return player.Order
+}
+
+func (player *Player) SetOnChangeRow(f func(int)) {
+    player.OnChangeRow = f
+}
+
+func (player *Player) SetOnChangeOrder(f func(int, int)) {
+    player.OnChangeOrder = f
+}
+
+func (player *Player) SetOnChangeSpeed(f func(int, int)) {
+    player.OnChangeSpeed = f
+}
+
+func (player *Player) GetChannelCount() int {
+    return len(player.Channels)
+}
+
+func (player *Player) GetPattern() int {
+    return int(player.File.Orders[player.Order])
+}
+
+func (player *Player) GetSongLength() int {
+    return len(player.File.Orders)
+}
+
+func (player *Player) IsStereo() bool {
+    return true
+}
+
+func (player *Player) ToggleMuteChannel(channel int) bool {
+    if channel < 0 || channel >= len(player.Channels) {
+        return false
+    }
+
+    player.Channels[channel].Mute = !player.Channels[channel].Mute
+    return player.Channels[channel].Mute
+}
+
+// move playback to the given row of the given order as the song progresses. past the end of
+// the order list the song starts over, and a row past the end of the pattern is row 0
+func (player *Player) advanceOrder(order int, row int) {
+    if order < 0 || order >= player.GetSongLength() {
+        order = 0
+    }
+
+    if pattern := player.File.GetPattern(order); pattern != nil && row >= len(pattern.Rows) {
+        row = 0
+    }
+
+    if player.loops.Visit(order, row) {
+        player.SongLooped = true
+    }
+
+    player.Order = order
+    player.CurrentRow = row
+    player.OrdersPlayed += 1
+    player.resetPatternLoop()
+}
+
+// forget any pattern loop, used when a new pattern starts
+func (player *Player) resetPatternLoop() {
+    player.DoLoop = false
+    player.LoopRow = 0
+    for _, channel := range player.Channels {
+        channel.LoopRow = 0
+        channel.LoopCount = 0
+    }
+}
+
+func (player *Player) NextOrder() {
+    player.resetPatternLoop()
+    player.Order += 1
+    if player.Order >= player.GetSongLength() {
+        player.Order = 0
+    }
+
+    if player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.Order, player.GetPattern())
+    }
+}
+
+func (player *Player) PreviousOrder() {
+    player.resetPatternLoop()
+    player.Order -= 1
+    if player.Order < 0 {
+        player.Order = player.GetSongLength() - 1
+    }
+
+    if player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.Order, player.GetPattern())
+    }
+}
+
+func (player *Player) GetChannelData(channel int, data []float32) int {
+    if channel < len(player.Channels) {
+        return player.Channels[channel].ScopeBuffer.Peek(data)
+    }
+
+    return 0
+}
+
+func (player *Player) ResetRow() {
+    player.CurrentRow = 0
+}
+
+func (player *Player) RenderToPCM() io.Reader {
+    // make a buffer to hold 1/100th of a second of audio data, which is 4-bytes per sample
+    // and 1 samples per channel
+    rate := 100
+    buffer := make([]float32, player.SampleRate * 2 / rate)
+    mix := make([]float32, player.SampleRate * 2 / rate)
+
+    fillMix := func() bool {
+        if player.SongLooped {
+            return false
+        }
+
+        player.Update(1.0 / float32(rate))
+
+        for i := range mix {
+            mix[i] = 0
+        }
+
+        for _, channel := range player.Channels {
+            amount := channel.AudioBuffer.Read(buffer)
+
+            if amount > 0 {
+                // copy the samples into the mix buffer
+                for i := range amount {
+                    mix[i] = mix[i] + buffer[i]
+                }
+            }
+        }
+
+        for i := range mix {
+            mix[i] = max(min(mix[i], 1), -1)
+        }
+
+        return true
+    }
+
+    mixPosition := len(mix)
+    reader := func(data []byte) (int, error) {
+        if len(data) == 0 {
+            return 0, nil
+        }
+
+        if player.SongLooped {
+            return 0, io.EOF
+        }
+
+        // wait for the music to be produced
+        if mixPosition < len(mix) {
+            part := mix[mixPosition:]
+
+            amount := common.CopyFloat32(data, part)
+            mixPosition += amount
+            return amount * 4, nil
+        }
+
+        mixPosition = 0
+
+        more := fillMix()
+        if !more {
+            return 0, io.EOF
+        }
+
+        // copy the mix into the data buffer
+        amount := common.CopyFloat32(data, mix)
+        mixPosition += amount
+
+        return amount * 4, nil
+    }
+
+    return &common.ReaderFunc{
+        Func: reader,
+    }
+}
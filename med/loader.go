@@ -0,0 +1,749 @@
+package med
+
+import (
+    "io"
+    "bytes"
+    "fmt"
+    "log"
+    "math"
+    "encoding/binary"
+
+    "github.com/kazzmir/tracker/mod"
+)
+
+const MaxSamples = 63
+const MaxChannels = 64
+
+// flags of the song
+const (
+    // the volume command is in hex rather than decimal
+    flagVolumeHex = 0x10
+    // the old 8 channel mode of octamed, which has its own tempos
+    flagEightChannel = 0x40
+    // the tempo is in beats per minute, and the low bits of flags2 are the lines per beat
+    flag2BPM = 0x20
+)
+
+// the header of an instrument is its length and then its type
+const (
+    instrumentHybrid = -2
+    instrumentSynth = -1
+    instrument16Bit = 0x10
+    instrumentStereo = 0x20
+)
+
+// the number of octaves that are stored for the iff instrument types 1 to 6
+var iffOctaves = []int{0, 5, 3, 2, 4, 6, 7}
+
+// tempos 1 to 10 are compatible with the soundtracker speeds, given here in med tempo units
+var compatibleTempos = []int{195, 97, 65, 49, 39, 32, 28, 24, 22, 20}
+var eightChannelTempos = []int{179, 164, 152, 141, 131, 123, 116, 110, 104, 99}
+
+// octamed songs are made of blocks, which are patterns that can have any number of lines and tracks.
+// the commands are mostly the protracker effects, so blocks are converted to mod patterns and played
+// by the mod player
+type MEDFile struct {
+    mod.ModFile
+    // 0 to 3 for MMD0 to MMD3
+    Version int
+    // the tick rate in protracker bpm, and the ticks per line
+    InitialTempo int
+    InitialSpeed int
+    // the tempo is in beats per minute instead of med tempo units
+    BPMMode bool
+    LinesPerBeat int
+    EightChannel bool
+    VolumeHex bool
+
+    // the blocks as they are stored in the file, for the commands that the mod player doesn't have
+    Blocks []Block
+    // one for each sample
+    Instruments []Instrument
+}
+
+// a note of a block. note 1 is C-1 in med, but it is shown as the mod note with the same period
+type Note struct {
+    Note int
+    Instrument int
+    Command int
+    Parameter int
+}
+
+func (note *Note) GetNotePosition() int {
+    if note.Note > 0 {
+        return note.Note - 1 + 36
+    }
+
+    return 0
+}
+
+func (note *Note) GetName() string {
+    if note.Note > 0 {
+        names := []string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+        return fmt.Sprintf("%v%v", names[(note.Note - 1) % 12], (note.Note - 1) / 12 + 3)
+    }
+
+    return "..."
+}
+
+func (note *Note) GetSampleName() string {
+    if note.Instrument > 0 {
+        return fmt.Sprintf("%02d", note.Instrument)
+    }
+
+    return ".."
+}
+
+// med does not have a volume column
+func (note *Note) GetVolumeName() string {
+    return ""
+}
+
+func (note *Note) GetEffectName() string {
+    if note.Command > 0 || note.Parameter > 0 {
+        return fmt.Sprintf("%02X%02X", note.Command, note.Parameter)
+    }
+
+    return "..."
+}
+
+type Block struct {
+    Tracks int
+    Lines int
+    // the notes in line order
+    Notes []Note
+}
+
+func (block *Block) GetNote(line int, track int) (*Note, bool) {
+    if line < 0 || line >= block.Lines || track < 0 || track >= block.Tracks {
+        return &Note{}, false
+    }
+
+    return &block.Notes[line * block.Tracks + track], true
+}
+
+// what an instrument does besides play its sample
+type Instrument struct {
+    // a note is held for this many ticks before it decays, 0 holds it until the next note.
+    // samples fade out by the decay each tick, and synths jump to the decay in their volume sequence
+    Hold int
+    Decay int
+    // nil unless this is a synth or hybrid instrument
+    Synth *Synth
+}
+
+// synth instruments play short waveforms, picked by a waveform sequence while a volume sequence
+// sets the volume. hybrid instruments are the same, except the first waveform is a sample
+type Synth struct {
+    Hybrid bool
+    // the number of ticks between the steps of each sequence
+    VolumeSpeed int
+    WaveformSpeed int
+    VolumeSequence []byte
+    WaveformSequence []byte
+    // the waveforms without the volume of the instrument applied
+    Waveforms []mod.Sample
+}
+
+// the bytes at the offset, or an error if they are outside of the file
+func slice(data []byte, offset int, length int) ([]byte, error) {
+    if offset < 0 || length < 0 || offset + length > len(data) {
+        return nil, fmt.Errorf("offset %v length %v is outside of the file", offset, length)
+    }
+
+    return data[offset:offset + length], nil
+}
+
+func readUint16(data []byte, offset int) (int, error) {
+    value, err := slice(data, offset, 2)
+    if err != nil {
+        return 0, err
+    }
+    return int(binary.BigEndian.Uint16(value)), nil
+}
+
+func readUint32(data []byte, offset int) (int, error) {
+    value, err := slice(data, offset, 4)
+    if err != nil {
+        return 0, err
+    }
+    return int(binary.BigEndian.Uint32(value)), nil
+}
+
+// convert a med tempo to a protracker bpm, which is 2/5 of the ticks per second
+func (file *MEDFile) convertTempo(tempo int) int {
+    if tempo <= 0 {
+        return 0
+    }
+
+    if file.EightChannel {
+        tempo = eightChannelTempos[min(tempo, 10) - 1]
+    } else if !file.BPMMode && tempo <= 10 {
+        tempo = compatibleTempos[tempo - 1]
+    }
+
+    if file.BPMMode {
+        return tempo * file.LinesPerBeat / 4
+    }
+
+    // a tempo of 33 is 50 ticks a second
+    return int(math.Round(float64(tempo) * 125 / 33))
+}
+
+// the amiga period of a med note, where note 1 is C-1 at period 856
+func notePeriod(note int) uint16 {
+    return uint16(max(1, math.Round(856 / math.Pow(2, float64(note - 1) / 12))))
+}
+
+// the protracker pattern break parameter is in decimal
+func decimalParameter(value int) byte {
+    value = min(value, 99)
+    return byte(value / 10 << 4 | value % 10)
+}
+
+func extra(command int, value int) (byte, byte) {
+    return mod.EffectExtra, byte(command << 4 | value & 0xf)
+}
+
+// convert a med command to the protracker effect that does the same thing
+func (file *MEDFile) convertCommand(command int, parameter int) (byte, byte) {
+    switch command {
+        case 0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0xb:
+            return byte(command), byte(parameter)
+        case 0x9:
+            // the secondary tempo is the number of ticks per line
+            if parameter > 0 {
+                return mod.EffectSetSpeed, byte(min(parameter, 0x1f))
+            }
+        case 0xa, 0xd:
+            return mod.EffectVolumeSlide, byte(parameter)
+        case 0xc:
+            if !file.VolumeHex {
+                parameter = parameter >> 4 * 10 + parameter & 0xf
+            }
+            return mod.EffectSetVolume, byte(min(parameter, 64))
+        case 0xf:
+            switch {
+                case parameter == 0:
+                    // go to the next block
+                    return mod.EffectPatternBreak, 0
+                case parameter <= 0xf0:
+                    // the mod player treats speeds below 0x20 as ticks per line
+                    return mod.EffectSetSpeed, byte(max(0x20, min(file.convertTempo(parameter), 0xff)))
+                case parameter == 0xf8:
+                    return extra(0, 1)
+                case parameter == 0xf9:
+                    return extra(0, 0)
+                case parameter == 0xff:
+                    // stop the note
+                    return extra(0xc, 0)
+            }
+        case 0x11:
+            return extra(1, parameter)
+        case 0x12:
+            return extra(2, parameter)
+        case 0x14:
+            return mod.EffectVibrato, byte(parameter)
+        case 0x15:
+            return extra(5, parameter)
+        case 0x16:
+            return extra(6, parameter)
+        case 0x18:
+            return extra(0xc, parameter)
+        case 0x19:
+            return mod.EffectSampleOffset, byte(parameter)
+        case 0x1a:
+            return extra(0xa, parameter)
+        case 0x1b:
+            return extra(0xb, parameter)
+        case 0x1d:
+            return mod.EffectPatternBreak, decimalParameter(parameter)
+        case 0x1e:
+            return extra(0xe, parameter)
+        case 0x2e:
+            // the track panning goes from -16 on the left to 16 on the right
+            return mod.EffectPan, byte((max(-16, min(int(int8(parameter)), 16)) + 16) * 255 / 32)
+    }
+
+    // the commands that depend on the speed or the instrument are played by the med player, and
+    // the midi commands are not played
+    return 0, 0
+}
+
+// read a block, which is 3 bytes per note in MMD0 and 4 bytes per note in the later versions
+func readBlock(data []byte, offset int, version int) (Block, error) {
+    var result Block
+
+    headerSize := 8
+    noteSize := 4
+
+    if version == 0 {
+        header, err := slice(data, offset, 2)
+        if err != nil {
+            return result, err
+        }
+        result.Tracks = int(header[0])
+        result.Lines = int(header[1]) + 1
+        headerSize = 2
+        noteSize = 3
+    } else {
+        tracks, err := readUint16(data, offset)
+        if err != nil {
+            return result, err
+        }
+        lines, err := readUint16(data, offset + 2)
+        if err != nil {
+            return result, err
+        }
+        result.Tracks = tracks
+        result.Lines = lines + 1
+    }
+
+    if result.Tracks > MaxChannels {
+        return result, fmt.Errorf("block has %v tracks", result.Tracks)
+    }
+
+    notes, err := slice(data, offset + headerSize, result.Tracks * result.Lines * noteSize)
+    if err != nil {
+        return result, err
+    }
+
+    for i := range result.Tracks * result.Lines {
+        entry := notes[i * noteSize:]
+        if version == 0 {
+            // xynnnnnn iiiicccc pppppppp, where x and y are the high bits of the instrument
+            instrument := int(entry[0] & 0x80) >> 3 | int(entry[0] & 0x40) >> 1 | int(entry[1] >> 4)
+            result.Notes = append(result.Notes, Note{Note: int(entry[0] & 0x3f), Instrument: instrument, Command: int(entry[1] & 0xf), Parameter: int(entry[2])})
+        } else {
+            result.Notes = append(result.Notes, Note{Note: int(entry[0] & 0x7f), Instrument: int(entry[1] & 0x3f), Command: int(entry[2]), Parameter: int(entry[3])})
+        }
+    }
+
+    return result, nil
+}
+
+// the blocks that the song plays. MMD0 and MMD1 have one play sequence, MMD2 and MMD3 have
+// sections that each play one of several play sequences
+func readOrders(data []byte, song []byte, version int, blockCount int, logger *log.Logger) ([]int, error) {
+    songLength := int(binary.BigEndian.Uint16(song[506:]))
+
+    var orders []int
+
+    if version < 2 {
+        for _, order := range song[508:508 + min(songLength, 256)] {
+            orders = append(orders, int(order))
+        }
+    } else {
+        playSequences := int(binary.BigEndian.Uint32(song[508:]))
+        sections := int(binary.BigEndian.Uint32(song[512:]))
+        sequenceCount := int(binary.BigEndian.Uint16(song[522:]))
+
+        for i := range songLength {
+            sequence, err := readUint16(data, sections + i * 2)
+            if err != nil {
+                return nil, fmt.Errorf("Error reading section %v: %v", i, err)
+            }
+
+            if sequence >= sequenceCount {
+                logger.Printf("Section %v refers to missing play sequence %v", i, sequence)
+                continue
+            }
+
+            offset, err := readUint32(data, playSequences + sequence * 4)
+            if err != nil {
+                return nil, err
+            }
+
+            // a name of 32 bytes and 8 reserved bytes come before the length
+            length, err := readUint16(data, offset + 40)
+            if err != nil {
+                return nil, fmt.Errorf("Error reading play sequence %v: %v", sequence, err)
+            }
+
+            for j := range length {
+                entry, err := readUint16(data, offset + 42 + j * 2)
+                if err != nil {
+                    return nil, fmt.Errorf("Error reading play sequence %v: %v", sequence, err)
+                }
+
+                // entries from 0x8000 are commands rather than blocks
+                if entry < 0x8000 {
+                    orders = append(orders, entry)
+                }
+            }
+        }
+    }
+
+    var valid []int
+    for _, order := range orders {
+        if order >= blockCount {
+            logger.Printf("Order refers to missing block %v", order)
+            continue
+        }
+        valid = append(valid, order)
+    }
+
+    return valid, nil
+}
+
+// read an instrument. synth and hybrid instruments are returned as a synth and leave the sample empty
+func readSample(data []byte, offset int, sample *mod.Sample, logger *log.Logger) (*Synth, error) {
+    kind, err := readUint16(data, offset + 4)
+    if err != nil {
+        return nil, err
+    }
+
+    switch int(int16(kind)) {
+        case instrumentSynth:
+            return readSynth(data, offset, false, sample, logger)
+        case instrumentHybrid:
+            return readSynth(data, offset, true, sample, logger)
+    }
+
+    return nil, readSampleData(data, offset, sample, logger)
+}
+
+// read the data of a sampled instrument as a mod sample, with the volume applied like the mod loader does
+func readSampleData(data []byte, offset int, sample *mod.Sample, logger *log.Logger) error {
+    header, err := slice(data, offset, 6)
+    if err != nil {
+        return err
+    }
+
+    length := int(binary.BigEndian.Uint32(header))
+    kind := int(int16(binary.BigEndian.Uint16(header[4:])))
+
+    if kind < 0 {
+        return fmt.Errorf("not a sampled instrument, type %v", kind)
+    }
+
+    raw, err := slice(data, offset + 6, length)
+    if err != nil {
+        return err
+    }
+
+    if kind & instrumentStereo != 0 {
+        // the channels are stored one after the other, only the left one is played
+        logger.Printf("Stereo instrument '%v' is played in mono", sample.Name)
+        raw = raw[:len(raw) / 2]
+    }
+
+    // iff instruments store each octave, starting with the lowest which has the fewest samples.
+    // only the lowest octave is played
+    octaves := kind & 0xf
+    if octaves > 0 && octaves < len(iffOctaves) {
+        raw = raw[:len(raw) / (1 << iffOctaves[octaves] - 1)]
+    }
+
+    volume := float32(sample.Volume) / 64
+
+    if kind & instrument16Bit != 0 {
+        sample.Data = make([]float32, len(raw) / 2)
+        for i := range sample.Data {
+            sample.Data[i] = float32(int16(binary.BigEndian.Uint16(raw[i * 2:]))) / 32768 * volume
+        }
+
+        sample.LoopStart /= 2
+        sample.LoopLength /= 2
+    } else {
+        sample.Data = make([]float32, len(raw))
+        for i, value := range raw {
+            sample.Data[i] = float32(int8(value)) / 128 * volume
+        }
+    }
+
+    sample.Length = uint16(min(len(sample.Data), 0xffff))
+
+    if sample.LoopStart + sample.LoopLength > len(sample.Data) {
+        sample.LoopLength = max(0, len(sample.Data) - sample.LoopStart)
+    }
+
+    if sample.LoopLength <= 2 {
+        sample.LoopStart = 0
+        sample.LoopLength = 0
+    }
+
+    return nil
+}
+
+// the sequences and waveforms of a synth instrument. each waveform is a length in words followed by
+// the data, except the first waveform of a hybrid instrument, which is stored like a sample
+func readSynth(data []byte, offset int, hybrid bool, sample *mod.Sample, logger *log.Logger) (*Synth, error) {
+    header, err := slice(data, offset, 278 + 64 * 4)
+    if err != nil {
+        return nil, err
+    }
+
+    volumeLength := min(int(binary.BigEndian.Uint16(header[14:])), 128)
+    waveformLength := min(int(binary.BigEndian.Uint16(header[16:])), 128)
+    waveformCount := min(int(binary.BigEndian.Uint16(header[20:])), 64)
+
+    synth := Synth{
+        Hybrid: hybrid,
+        VolumeSpeed: int(header[18]),
+        WaveformSpeed: int(header[19]),
+        VolumeSequence: header[22:22 + volumeLength],
+        WaveformSequence: header[150:150 + waveformLength],
+    }
+
+    for i := range waveformCount {
+        // the offsets are from the start of the instrument
+        waveformOffset := offset + int(binary.BigEndian.Uint32(header[278 + i * 4:]))
+
+        if hybrid && i == 0 {
+            // the volume of the instrument is applied as the synth plays
+            wave := mod.Sample{
+                Name: sample.Name,
+                Volume: 64,
+                LoopStart: sample.LoopStart,
+                LoopLength: sample.LoopLength,
+            }
+
+            // only the sample data is read, so a hybrid that points at a synth, or at itself, is an error
+            err := readSampleData(data, waveformOffset, &wave, logger)
+            if err != nil {
+                return nil, fmt.Errorf("Error reading hybrid sample: %v", err)
+            }
+
+            synth.Waveforms = append(synth.Waveforms, wave)
+            continue
+        }
+
+        words, err := readUint16(data, waveformOffset)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading waveform %v: %v", i, err)
+        }
+
+        raw, err := slice(data, waveformOffset + 2, words * 2)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading waveform %v: %v", i, err)
+        }
+
+        // waveforms loop from start to end
+        wave := mod.Sample{
+            Volume: 64,
+            Length: uint16(len(raw)),
+            LoopLength: len(raw),
+            Data: make([]float32, len(raw)),
+        }
+
+        for j, value := range raw {
+            wave.Data[j] = float32(int8(value)) / 128
+        }
+
+        synth.Waveforms = append(synth.Waveforms, wave)
+    }
+
+    logger.Printf("Synth instrument '%v' hybrid %v waveforms %v volume sequence %v waveform sequence %v", sample.Name, hybrid, len(synth.Waveforms), volumeLength, waveformLength)
+
+    return &synth, nil
+}
+
+func Load(reader io.Reader, logger *log.Logger) (*MEDFile, error) {
+    data, err := io.ReadAll(reader)
+    if err != nil {
+        return nil, err
+    }
+
+    header, err := slice(data, 0, 52)
+    if err != nil {
+        return nil, err
+    }
+
+    if !bytes.Equal(header[:3], []byte("MMD")) || header[3] < '0' || header[3] > '3' {
+        return nil, fmt.Errorf("Not a med file, signature was %v", header[:4])
+    }
+
+    var file MEDFile
+    file.Version = int(header[3] - '0')
+
+    songOffset := int(binary.BigEndian.Uint32(header[8:]))
+    blockArray := int(binary.BigEndian.Uint32(header[16:]))
+    sampleArray := int(binary.BigEndian.Uint32(header[24:]))
+    expansion := int(binary.BigEndian.Uint32(header[32:]))
+
+    song, err := slice(data, songOffset, 788)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading song: %v", err)
+    }
+
+    blockCount := int(binary.BigEndian.Uint16(song[504:]))
+    tempo := int(binary.BigEndian.Uint16(song[764:]))
+    playTranspose := int(int8(song[766]))
+    flags := song[767]
+    flags2 := song[768]
+    sampleCount := min(int(song[787]), MaxSamples)
+
+    file.VolumeHex = flags & flagVolumeHex != 0
+    file.EightChannel = flags & flagEightChannel != 0
+    file.BPMMode = flags2 & flag2BPM != 0
+    file.LinesPerBeat = int(flags2 & 0x1f) + 1
+    file.InitialSpeed = max(1, min(int(song[769]), 0x1f))
+    file.InitialTempo = max(1, file.convertTempo(tempo))
+
+    logger.Printf("MMD%v blocks %v samples %v tempo %v speed %v bpm mode %v lines per beat %v", file.Version, blockCount, sampleCount, tempo, file.InitialSpeed, file.BPMMode, file.LinesPerBeat)
+
+    // the song name and instrument names are in the expansion
+    var instrumentNames [][]byte
+    var instrumentExtensions [][]byte
+
+    if expansion > 0 {
+        readEntries := func(offset int, countOffset int, sizeOffset int) [][]byte {
+            start, err1 := readUint32(data, expansion + offset)
+            count, err2 := readUint16(data, expansion + countOffset)
+            size, err3 := readUint16(data, expansion + sizeOffset)
+            if err1 != nil || err2 != nil || err3 != nil || start == 0 {
+                return nil
+            }
+
+            var entries [][]byte
+            for i := range count {
+                entry, err := slice(data, start + i * size, size)
+                if err != nil {
+                    break
+                }
+                entries = append(entries, entry)
+            }
+            return entries
+        }
+
+        instrumentExtensions = readEntries(4, 8, 10)
+        instrumentNames = readEntries(20, 24, 26)
+
+        nameOffset, err1 := readUint32(data, expansion + 44)
+        nameLength, err2 := readUint32(data, expansion + 48)
+        if err1 == nil && err2 == nil && nameOffset > 0 {
+            name, err := slice(data, nameOffset, nameLength)
+            if err == nil {
+                file.Name = string(bytes.TrimRight(name, "\x00"))
+            }
+        }
+    }
+
+    transposes := make([]int, sampleCount)
+
+    for i := range sampleCount {
+        // repeat and repeat length in words, midi channel and preset, volume and transpose
+        info := song[i * 8:]
+
+        sample := mod.Sample{
+            Volume: min(info[4], 64),
+            LoopStart: int(binary.BigEndian.Uint16(info[0:])) * 2,
+            LoopLength: int(binary.BigEndian.Uint16(info[2:])) * 2,
+        }
+
+        transposes[i] = int(int8(info[5]))
+
+        if i < len(instrumentNames) {
+            sample.Name = string(bytes.TrimRight(instrumentNames[i][:min(40, len(instrumentNames[i]))], "\x00"))
+        }
+
+        var instrument Instrument
+
+        if i < len(instrumentExtensions) && len(instrumentExtensions[i]) >= 4 {
+            // hold, decay, midi note off and finetune
+            instrument.Hold = int(instrumentExtensions[i][0])
+            instrument.Decay = int(instrumentExtensions[i][1])
+            sample.FineTune = instrumentExtensions[i][3] & 0xf
+        }
+
+        offset, err := readUint32(data, sampleArray + i * 4)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading sample %v: %v", i, err)
+        }
+
+        if offset > 0 {
+            instrument.Synth, err = readSample(data, offset, &sample, logger)
+            if err != nil {
+                return nil, fmt.Errorf("Error reading sample %v: %v", i, err)
+            }
+        }
+
+        file.Instruments = append(file.Instruments, instrument)
+
+        logger.Printf("Sample %v: Name='%v', Length=%v, Volume=%v, Transpose=%v, LoopStart=%v, LoopLength=%v", i, sample.Name, len(sample.Data), sample.Volume, transposes[i], sample.LoopStart, sample.LoopLength)
+
+        file.Samples = append(file.Samples, sample)
+    }
+
+    for i := range blockCount {
+        offset, err := readUint32(data, blockArray + i * 4)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading block %v: %v", i, err)
+        }
+
+        block, err := readBlock(data, offset, file.Version)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading block %v: %v", i, err)
+        }
+
+        file.Channels = max(file.Channels, block.Tracks)
+        file.Blocks = append(file.Blocks, block)
+    }
+
+    if file.Channels == 0 {
+        return nil, fmt.Errorf("Song has no tracks")
+    }
+
+    orders, err := readOrders(data, song, file.Version, blockCount, logger)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(orders) == 0 {
+        return nil, fmt.Errorf("Song has no orders")
+    }
+
+    for _, order := range orders {
+        // the mod player keeps its orders in bytes
+        if order > 0xff {
+            return nil, fmt.Errorf("Block %v is past the last block that can be played", order)
+        }
+        file.Orders = append(file.Orders, byte(order))
+    }
+    file.SongLength = len(file.Orders)
+
+    // blocks with fewer tracks than the song leave the other channels empty
+    for _, block := range file.Blocks {
+        var pattern mod.Pattern
+
+        for line := range block.Lines {
+            row := mod.Row{
+                Notes: make([]mod.Note, file.Channels),
+            }
+
+            for track := range block.Tracks {
+                entry, _ := block.GetNote(line, track)
+                note := &row.Notes[track]
+
+                instrument := entry.Instrument
+                if instrument > len(file.Samples) {
+                    instrument = 0
+                    entry.Instrument = 0
+                }
+
+                // an instrument without a note doesn't play anything, it only keeps a held note going.
+                // FFD changes the pitch of the note that is playing without playing it again
+                if entry.Note > 0 && !(entry.Command == 0xf && entry.Parameter == 0xfd) {
+                    note.SampleNumber = byte(instrument)
+                }
+
+                if entry.Note > 0 {
+                    transpose := playTranspose
+                    if instrument > 0 {
+                        transpose += transposes[instrument - 1]
+                    }
+                    note.PeriodFrequency = notePeriod(entry.Note + transpose)
+                }
+
+                note.EffectNumber, note.EffectParameter = file.convertCommand(entry.Command, entry.Parameter)
+            }
+
+            pattern.Rows = append(pattern.Rows, row)
+        }
+
+        file.Patterns = append(file.Patterns, pattern)
+    }
+
+    return &file, nil
+}
@@ -0,0 +1,154 @@
+package med
+
+import (
+    "testing"
+    "bytes"
+    "encoding/binary"
+    "io"
+    "log"
+
+    "github.com/kazzmir/tracker/mod"
+)
+
+// an instrument header with a single waveform at the given offset from the instrument
+func makeSynthInstrument(kind int16, waveformOffset uint32) []byte {
+    data := make([]byte, 278 + 64 * 4)
+    binary.BigEndian.PutUint16(data[4:], uint16(kind))
+    binary.BigEndian.PutUint16(data[20:], 1)
+    binary.BigEndian.PutUint32(data[278:], waveformOffset)
+    return data
+}
+
+func TestReadHybridSample(test *testing.T) {
+    logger := log.New(io.Discard, "", 0)
+
+    // a sample of 4 bytes after the hybrid instrument
+    hybrid := makeSynthInstrument(instrumentHybrid, 278 + 64 * 4)
+    hybrid = append(hybrid, 0, 0, 0, 4, 0, 0, 1, 2, 3, 4)
+
+    selfReference := makeSynthInstrument(instrumentHybrid, 0)
+
+    // the first waveform is another synth instrument
+    synthInside := makeSynthInstrument(instrumentHybrid, 278 + 64 * 4)
+    synthInside = append(synthInside, makeSynthInstrument(instrumentSynth, 0)...)
+
+    tests := []struct {
+        name string
+        data []byte
+        fails bool
+    }{
+        {name: "sample", data: hybrid, fails: false},
+        {name: "self reference", data: selfReference, fails: true},
+        {name: "synth inside", data: synthInside, fails: true},
+    }
+
+    for _, check := range tests {
+        var sample mod.Sample
+        synth, err := readSample(check.data, 0, &sample, logger)
+        if check.fails {
+            if err == nil {
+                test.Errorf("%v: expected an error", check.name)
+            }
+            continue
+        }
+
+        if err != nil {
+            test.Errorf("%v: %v", check.name, err)
+            continue
+        }
+
+        if synth == nil || !synth.Hybrid || len(synth.Waveforms) != 1 || len(synth.Waveforms[0].Data) != 4 {
+            test.Errorf("%v: expected a hybrid with one 4 byte waveform, got %+v", check.name, synth)
+        }
+    }
+}
+
+func TestReadSynth(test *testing.T) {
+    logger := log.New(io.Discard, "", 0)
+
+    data := makeSynthInstrument(instrumentSynth, 278 + 64 * 4)
+    // volume and waveform sequences of 2 steps, played every 3 and every 2 ticks
+    binary.BigEndian.PutUint16(data[14:], 2)
+    binary.BigEndian.PutUint16(data[16:], 2)
+    data[18] = 3
+    data[19] = 2
+    copy(data[22:], []byte{64, 0xff})
+    copy(data[150:], []byte{0, 0xff})
+    // a waveform of 2 words
+    data = append(data, 0, 2, 0, 0x40, 0xc0, 0x7f)
+
+    var sample mod.Sample
+    synth, err := readSample(data, 0, &sample, logger)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    if synth == nil || synth.Hybrid || synth.VolumeSpeed != 3 || synth.WaveformSpeed != 2 {
+        test.Fatalf("unexpected synth %+v", synth)
+    }
+
+    if !bytes.Equal(synth.VolumeSequence, []byte{64, 0xff}) || !bytes.Equal(synth.WaveformSequence, []byte{0, 0xff}) {
+        test.Errorf("unexpected sequences %v and %v", synth.VolumeSequence, synth.WaveformSequence)
+    }
+
+    if len(synth.Waveforms) != 1 || len(synth.Waveforms[0].Data) != 4 || synth.Waveforms[0].LoopLength != 4 || synth.Waveforms[0].Data[1] != 0.5 {
+        test.Errorf("unexpected waveforms %+v", synth.Waveforms)
+    }
+
+    // a waveform that is cut off is an error
+    _, err = readSample(data[:len(data) - 1], 0, &sample, logger)
+    if err == nil {
+        test.Errorf("expected an error for a waveform that is cut off")
+    }
+}
+
+func TestReadBlock(test *testing.T) {
+    // MMD0 with 2 tracks and 2 lines. the first note is C-1 of instrument 0x11 with command 0C
+    // and parameter 0x20, where the high bit of the instrument is the top bit of the note byte
+    mmd0 := []byte{
+        2, 1,
+        0x80 | 1, 0x1c, 0x20, 0, 0, 0,
+        0, 0, 0, 2, 0x20, 0,
+    }
+
+    block, err := readBlock(mmd0, 0, 0)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    if block.Tracks != 2 || block.Lines != 2 || len(block.Notes) != 4 {
+        test.Fatalf("unexpected block of %v tracks and %v lines with %v notes", block.Tracks, block.Lines, len(block.Notes))
+    }
+
+    if note, _ := block.GetNote(0, 0); *note != (Note{Note: 1, Instrument: 0x11, Command: 0xc, Parameter: 0x20}) {
+        test.Errorf("unexpected first note %+v", *note)
+    }
+
+    if note, _ := block.GetNote(1, 1); *note != (Note{Note: 2, Instrument: 2}) {
+        test.Errorf("unexpected last note %+v", *note)
+    }
+
+    if _, ok := block.GetNote(2, 0); ok {
+        test.Errorf("expected no note past the last line")
+    }
+
+    // MMD1 with 1 track and 1 line
+    mmd1 := []byte{0, 1, 0, 0, 0, 0, 0, 0, 37, 0x3f, 0x1f, 0x42}
+    block, err = readBlock(mmd1, 0, 1)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    if note, _ := block.GetNote(0, 0); *note != (Note{Note: 37, Instrument: 0x3f, Command: 0x1f, Parameter: 0x42}) {
+        test.Errorf("unexpected mmd1 note %+v", *note)
+    }
+
+    // blocks that are cut off, or have more tracks than can be played, are errors
+    if _, err := readBlock(mmd0[:len(mmd0) - 1], 0, 0); err == nil {
+        test.Errorf("expected an error for a block that is cut off")
+    }
+
+    if _, err := readBlock([]byte{0xff, 0xff, 0, 0}, 0, 1); err == nil {
+        test.Errorf("expected an error for a block with too many tracks")
+    }
+}
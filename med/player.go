@@ -0,0 +1,470 @@
+package med
+
+import (
+    "math"
+
+    "github.com/kazzmir/tracker/common"
+    "github.com/kazzmir/tracker/mod"
+)
+
+// the blocks are converted to mod patterns, so the mod player plays the song at the tempo of the med file.
+// the commands that depend on the speed, hold and decay, and synth instruments are played here
+type Player struct {
+    *mod.Player
+    File *MEDFile
+    Voices []*Voice
+}
+
+// what the med player keeps for each channel on top of the mod channel
+type Voice struct {
+    Instrument *Instrument
+
+    // ticks left until a held note decays
+    Hold int
+    HoldLeft int
+    Decay int
+    Decaying bool
+
+    Synth *Synth
+    // skip the tick of the row that the synth started on, since it already ran
+    started bool
+
+    Volume int
+    VolumeSpeed int
+    VolumeCount int
+    VolumeWait int
+    VolumePosition int
+    VolumeChange int
+
+    // a waveform used as the volume, instead of the volume sequence
+    envelope *mod.Sample
+    envelopeLoop bool
+    envelopePosition int
+
+    Waveform int
+    WaveformSpeed int
+    WaveformCount int
+    WaveformWait int
+    WaveformPosition int
+
+    // the period of the note and what the synth has added to it
+    BasePeriod int
+    PeriodChange int
+    PeriodOffset int
+    appliedOffset int
+
+    VibratoDepth int
+    VibratoSpeed int
+    VibratoPosition int
+    // the waveform of the vibrato, or -1 for a sine
+    VibratoWaveform int
+
+    // the position of the arpeggio in the waveform sequence, or -1 if there is none
+    ArpeggioStart int
+    ArpeggioPosition int
+
+    // the waveform with the volume applied, which the mod channel plays
+    sample mod.Sample
+    sampleWaveform int
+    sampleVolume float32
+}
+
+func MakePlayer(file *MEDFile, sampleRate int) *Player {
+    player := mod.MakePlayer(&file.ModFile, sampleRate)
+    player.Speed = file.InitialSpeed
+    player.BPM = file.InitialTempo
+
+    med := &Player{
+        Player: player,
+        File: file,
+    }
+
+    for range player.Channels {
+        med.Voices = append(med.Voices, &Voice{})
+    }
+
+    player.OnChannelRow = med.updateRow
+    player.OnChannelTick = med.updateTick
+
+    return med
+}
+
+// show the notes of the block rather than the mod notes they were converted to
+func (player *Player) GetRowNoteInfo(channel int, row int) (common.NoteInfo, bool) {
+    block := &player.File.Blocks[player.GetPattern()]
+    return block.GetNote(row, channel)
+}
+
+func (player *Player) updateRow(channel *mod.Channel) {
+    voice := player.Voices[channel.ChannelNumber]
+    note, ok := player.File.Blocks[player.GetPattern()].GetNote(player.CurrentRow, channel.ChannelNumber)
+    if !ok {
+        return
+    }
+
+    speed := player.Speed
+
+    if note.Note > 0 {
+        if note.Instrument > 0 && !(note.Command == 0xf && note.Parameter == 0xfd) {
+            voice.start(&player.File.Instruments[note.Instrument - 1], channel)
+        } else {
+            // the mod player changed the period without playing the note again
+            voice.BasePeriod = channel.CurrentFrequency
+            voice.appliedOffset = 0
+        }
+    } else if note.Instrument > 0 && voice.Hold > 0 && !voice.Decaying {
+        // an instrument on its own holds the note for longer
+        voice.HoldLeft = voice.Hold
+    }
+
+    switch note.Command {
+        case 0x8:
+            // the low nibble is the hold and the high nibble is the decay of the note
+            voice.Hold = note.Parameter & 0xf
+            voice.HoldLeft = voice.Hold
+            voice.Decay = note.Parameter >> 4
+        case 0xe:
+            // jump in the waveform sequence
+            voice.WaveformPosition = note.Parameter
+            voice.WaveformWait = 0
+        case 0xf:
+            switch note.Parameter {
+                case 0xf1:
+                    // play the note twice
+                    channel.RetriggerTicks = (speed + 1) / 2
+                case 0xf2:
+                    // delay the note by half a line
+                    channel.Delay = speed / 2
+                case 0xf3:
+                    // play the note three times
+                    channel.RetriggerTicks = (speed + 2) / 3
+                case 0xf4:
+                    channel.Delay = speed / 3
+                case 0xf5:
+                    channel.Delay = speed * 2 / 3
+                case 0xfe:
+                    player.Stop()
+            }
+        case 0x1f:
+            // the high nibble delays the note and the low nibble retriggers it
+            channel.Delay = note.Parameter >> 4
+            channel.RetriggerTicks = note.Parameter & 0xf
+    }
+}
+
+func (player *Player) updateTick(channel *mod.Channel, changeRow bool, ticks int) {
+    voice := player.Voices[channel.ChannelNumber]
+
+    if voice.started {
+        voice.started = false
+        ticks -= 1
+    }
+
+    for range ticks {
+        voice.tick(channel)
+    }
+}
+
+// a new note starts playing the instrument
+func (voice *Voice) start(instrument *Instrument, channel *mod.Channel) {
+    voice.Instrument = instrument
+    voice.Hold = instrument.Hold
+    voice.HoldLeft = instrument.Hold
+    voice.Decay = instrument.Decay
+    voice.Decaying = false
+
+    voice.BasePeriod = channel.CurrentFrequency
+    voice.appliedOffset = 0
+
+    voice.Synth = instrument.Synth
+    if voice.Synth == nil || len(voice.Synth.Waveforms) == 0 || channel.CurrentSample == nil {
+        voice.Synth = nil
+        return
+    }
+
+    synth := voice.Synth
+
+    voice.Volume = 64
+    voice.VolumeSpeed = synth.VolumeSpeed
+    voice.VolumeCount = 0
+    voice.VolumeWait = 0
+    voice.VolumePosition = 0
+    voice.VolumeChange = 0
+    voice.envelope = nil
+
+    voice.Waveform = 0
+    voice.WaveformSpeed = synth.WaveformSpeed
+    voice.WaveformCount = 0
+    voice.WaveformWait = 0
+    voice.WaveformPosition = 0
+
+    voice.PeriodChange = 0
+    voice.PeriodOffset = 0
+    voice.VibratoDepth = 0
+    voice.VibratoSpeed = 0
+    voice.VibratoPosition = 0
+    voice.VibratoWaveform = -1
+    voice.ArpeggioStart = -1
+
+    voice.sampleWaveform = -1
+    voice.sample.Name = channel.CurrentSample.Name
+    voice.sample.Volume = channel.CurrentSample.Volume
+    channel.CurrentSample = &voice.sample
+
+    // the first tick of the sequences happens with the note
+    voice.tick(channel)
+    voice.started = true
+}
+
+func (voice *Voice) tick(channel *mod.Channel) {
+    if voice.Instrument == nil {
+        return
+    }
+
+    if voice.Hold > 0 && !voice.Decaying {
+        voice.HoldLeft -= 1
+        if voice.HoldLeft <= 0 {
+            voice.Decaying = true
+
+            if voice.Synth != nil {
+                // synths decay by playing the volume sequence from the decay
+                voice.VolumePosition = voice.Decay
+                voice.VolumeWait = 0
+                voice.VolumeCount = 0
+            } else if voice.Decay == 0 {
+                channel.Volume = 0
+            }
+        }
+    } else if voice.Decaying && voice.Synth == nil {
+        channel.Volume = max(0, channel.Volume - float32(voice.Decay) / 64)
+    }
+
+    if voice.Synth != nil {
+        voice.runVolumeSequence()
+        voice.runWaveformSequence()
+        voice.updatePeriod(channel)
+        voice.updateSample()
+    }
+}
+
+// read the parameter of a sequence command
+func sequenceParameter(sequence []byte, position *int) int {
+    if *position >= len(sequence) {
+        return 0
+    }
+
+    value := sequence[*position]
+    *position += 1
+    return int(value)
+}
+
+func (voice *Voice) runVolumeSequence() {
+    synth := voice.Synth
+
+    if voice.envelope != nil && len(voice.envelope.Data) > 0 {
+        // the waveform goes from -1 to 1, which is a volume from 0 to 64
+        voice.Volume = int((voice.envelope.Data[voice.envelopePosition] + 1) * 32)
+        voice.envelopePosition += 1
+        if voice.envelopePosition >= len(voice.envelope.Data) {
+            voice.envelopePosition = 0
+            if !voice.envelopeLoop {
+                voice.envelope = nil
+            }
+        }
+    }
+
+    voice.VolumeCount -= 1
+    if voice.VolumeCount > 0 {
+        return
+    }
+    voice.VolumeCount = voice.VolumeSpeed
+
+    voice.Volume = max(0, min(voice.Volume + voice.VolumeChange, 64))
+
+    if voice.VolumeWait > 0 {
+        voice.VolumeWait -= 1
+        return
+    }
+
+    sequence := synth.VolumeSequence
+
+    // commands run until one that sets the volume or waits, and a loop of jumps stops eventually
+    for range len(sequence) + 1 {
+        if voice.VolumePosition >= len(sequence) {
+            return
+        }
+
+        command := sequence[voice.VolumePosition]
+        voice.VolumePosition += 1
+
+        switch command {
+            case 0xff, 0xfb:
+                // end and halt stay on the command
+                voice.VolumePosition -= 1
+                return
+            case 0xfe:
+                voice.VolumePosition = sequenceParameter(sequence, &voice.VolumePosition)
+            case 0xf0:
+                voice.VolumeSpeed = sequenceParameter(sequence, &voice.VolumePosition)
+            case 0xf1:
+                voice.VolumeWait = sequenceParameter(sequence, &voice.VolumePosition)
+                return
+            case 0xf2:
+                voice.VolumeChange = -sequenceParameter(sequence, &voice.VolumePosition)
+            case 0xf3:
+                voice.VolumeChange = sequenceParameter(sequence, &voice.VolumePosition)
+            case 0xf4, 0xf5:
+                // use a waveform as the volume, once or looped
+                waveform := sequenceParameter(sequence, &voice.VolumePosition)
+                if waveform < len(synth.Waveforms) {
+                    voice.envelope = &synth.Waveforms[waveform]
+                    voice.envelopeLoop = command == 0xf5
+                    voice.envelopePosition = 0
+                }
+            case 0xfa:
+                voice.WaveformPosition = sequenceParameter(sequence, &voice.VolumePosition)
+                voice.WaveformWait = 0
+            default:
+                if command <= 64 {
+                    voice.Volume = int(command)
+                    return
+                }
+        }
+    }
+}
+
+func (voice *Voice) runWaveformSequence() {
+    synth := voice.Synth
+
+    voice.WaveformCount -= 1
+    if voice.WaveformCount > 0 {
+        return
+    }
+    voice.WaveformCount = voice.WaveformSpeed
+
+    if voice.WaveformWait > 0 {
+        voice.WaveformWait -= 1
+        return
+    }
+
+    sequence := synth.WaveformSequence
+
+    for range len(sequence) + 1 {
+        if voice.WaveformPosition >= len(sequence) {
+            return
+        }
+
+        command := sequence[voice.WaveformPosition]
+        voice.WaveformPosition += 1
+
+        switch command {
+            case 0xff, 0xfb:
+                voice.WaveformPosition -= 1
+                return
+            case 0xfe:
+                voice.WaveformPosition = sequenceParameter(sequence, &voice.WaveformPosition)
+            case 0xf0:
+                voice.WaveformSpeed = sequenceParameter(sequence, &voice.WaveformPosition)
+            case 0xf1:
+                voice.WaveformWait = sequenceParameter(sequence, &voice.WaveformPosition)
+                return
+            case 0xf2:
+                // a larger period is a lower pitch
+                voice.PeriodChange = sequenceParameter(sequence, &voice.WaveformPosition)
+            case 0xf3:
+                voice.PeriodChange = -sequenceParameter(sequence, &voice.WaveformPosition)
+            case 0xf4:
+                voice.VibratoDepth = sequenceParameter(sequence, &voice.WaveformPosition)
+            case 0xf5:
+                voice.VibratoSpeed = sequenceParameter(sequence, &voice.WaveformPosition)
+            case 0xf6:
+                voice.PeriodOffset = 0
+            case 0xf7:
+                voice.VibratoWaveform = sequenceParameter(sequence, &voice.WaveformPosition)
+            case 0xfa:
+                voice.VolumePosition = sequenceParameter(sequence, &voice.WaveformPosition)
+                voice.VolumeWait = 0
+            case 0xfc:
+                // the semitones up to the end of the arpeggio are played one each tick
+                voice.ArpeggioStart = voice.WaveformPosition
+                voice.ArpeggioPosition = voice.WaveformPosition
+                for voice.WaveformPosition < len(sequence) && sequence[voice.WaveformPosition] != 0xfd {
+                    voice.WaveformPosition += 1
+                }
+                voice.WaveformPosition += 1
+            case 0xfd:
+            default:
+                if int(command) < len(synth.Waveforms) {
+                    voice.Waveform = int(command)
+                    return
+                }
+        }
+    }
+}
+
+// move the period of the channel by the pitch changes, vibrato and arpeggio of the synth
+func (voice *Voice) updatePeriod(channel *mod.Channel) {
+    synth := voice.Synth
+
+    voice.PeriodOffset += voice.PeriodChange
+    offset := voice.PeriodOffset
+
+    if voice.ArpeggioStart >= 0 {
+        sequence := synth.WaveformSequence
+        semitones := 0
+        if voice.ArpeggioPosition < len(sequence) && sequence[voice.ArpeggioPosition] != 0xfd {
+            semitones = int(sequence[voice.ArpeggioPosition])
+        }
+
+        voice.ArpeggioPosition += 1
+        if voice.ArpeggioPosition >= len(sequence) || sequence[voice.ArpeggioPosition] == 0xfd {
+            voice.ArpeggioPosition = voice.ArpeggioStart
+        }
+
+        offset += int(float64(voice.BasePeriod) / math.Pow(2, float64(semitones) / 12)) - voice.BasePeriod
+    }
+
+    if voice.VibratoDepth > 0 {
+        // a cycle of the vibrato is 256 steps
+        voice.VibratoPosition = (voice.VibratoPosition + voice.VibratoSpeed) & 0xff
+
+        value := math.Sin(float64(voice.VibratoPosition) * math.Pi * 2 / 256)
+        if voice.VibratoWaveform >= 0 && voice.VibratoWaveform < len(synth.Waveforms) {
+            wave := synth.Waveforms[voice.VibratoWaveform].Data
+            if len(wave) > 0 {
+                value = float64(wave[voice.VibratoPosition * len(wave) / 256])
+            }
+        }
+
+        offset += int(value * float64(voice.VibratoDepth))
+    }
+
+    channel.CurrentFrequency = max(1, channel.CurrentFrequency + offset - voice.appliedOffset)
+    voice.appliedOffset = offset
+}
+
+// make the sample that the channel plays from the current waveform and volume
+func (voice *Voice) updateSample() {
+    volume := float32(voice.Volume) / 64 * float32(voice.sample.Volume) / 64
+    if voice.Waveform == voice.sampleWaveform && volume == voice.sampleVolume {
+        return
+    }
+
+    voice.sampleWaveform = voice.Waveform
+    voice.sampleVolume = volume
+
+    wave := &voice.Synth.Waveforms[voice.Waveform]
+
+    voice.sample.Length = wave.Length
+    voice.sample.LoopStart = wave.LoopStart
+    voice.sample.LoopLength = wave.LoopLength
+
+    if cap(voice.sample.Data) < len(wave.Data) {
+        voice.sample.Data = make([]float32, len(wave.Data))
+    }
+    voice.sample.Data = voice.sample.Data[:len(wave.Data)]
+
+    for i, value := range wave.Data {
+        voice.sample.Data[i] = value * volume
+    }
+}
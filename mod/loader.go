@@ -65,6 +65,11 @@ func (note *Note) GetName() string {
     return "..."
 }
 
+// mod files do not have a volume column
+func (note *Note) GetVolumeName() string {
+    return ""
+}
+
 func (note *Note) GetSampleName() string {
     if note.SampleNumber > 0 {
         return fmt.Sprintf("%02d", note.SampleNumber)
@@ -86,6 +91,7 @@ type Sample struct {
     Length uint16
     FineTune byte // -128 to 127
     Volume byte // 0-64
+    // the loop in samples. mod files store it in words, and a loop of one word doesn't loop
     LoopStart int
     LoopLength int
     // Data []int8 // the raw sample data
@@ -111,11 +117,95 @@ func readByte(reader io.Reader) (byte, error) {
     return buf[0], nil
 }
 
+// the number of channels for a signature at offset 1080, or 0 if the signature is not known.
+// flt8 is true for startrekker 8 channel modules, which store each pattern as two halves
+func signatureChannels(kind []byte) (channels int, flt8 bool) {
+    isDigit := func(b byte) bool {
+        return b >= '0' && b <= '9'
+    }
+
+    switch string(kind) {
+        case "M.K.", "M!K!", "M&K!", "N.T.", "FLT4": return 4, false
+        case "FLT8": return 8, true
+        case "CD81", "OKTA", "OCTA": return 8, false
+    }
+
+    // xCHN, such as 6CHN or 8CHN from fasttracker
+    if isDigit(kind[0]) && string(kind[1:]) == "CHN" {
+        return int(kind[0] - '0'), false
+    }
+
+    // xxCH or xxCN, such as 10CH or 16CH
+    if isDigit(kind[0]) && isDigit(kind[1]) && (string(kind[2:]) == "CH" || string(kind[2:]) == "CN") {
+        return int(kind[0] - '0') * 10 + int(kind[1] - '0'), false
+    }
+
+    // TDZx from TakeTracker
+    if string(kind[:3]) == "TDZ" && isDigit(kind[3]) {
+        return int(kind[3] - '0'), false
+    }
+
+    return 0, false
+}
+
+// read one 4-byte note from the pattern data
+func readNote(reader io.Reader, patternBytes []byte) (Note, error) {
+    _, err := io.ReadFull(reader, patternBytes)
+    if err != nil {
+        return Note{}, fmt.Errorf("Could not read pattern data: %v", err)
+    }
+
+    sampleNumber := (patternBytes[0] & 0xf0) + (patternBytes[2] >> 4)
+    periodFrequency := (uint(patternBytes[0] & 0xf) << 8) + uint(patternBytes[1])
+    effectNumber := patternBytes[2] & 0xf
+    effectParameter := patternBytes[3]
+
+    // log.Printf("Pattern data: Sample=%d, PeriodFrequency=%d, EffectNumber=%d, EffectParameter=%d", sampleNumber, periodFrequency, effectNumber, effectParameter)
+
+    return Note{
+        SampleNumber: sampleNumber,
+        PeriodFrequency: uint16(periodFrequency),
+        EffectNumber: effectNumber,
+        EffectParameter: effectParameter,
+    }, nil
+}
+
+// read 64 rows of the given number of channels, appending the notes to the rows
+func readPatternRows(reader io.Reader, rows []Row, channels int) error {
+    patternBytes := make([]byte, 4)
+    for i := range rows {
+        for range channels {
+            note, err := readNote(reader, patternBytes)
+            if err != nil {
+                return err
+            }
+            rows[i].Notes = append(rows[i].Notes, note)
+        }
+    }
+
+    return nil
+}
+
 func Load(reader_ io.Reader) (*ModFile, error) {
     var err error
 
     reader := bufio.NewReader(reader_)
 
+    // the signature comes after the sample headers, but it decides how many sample headers
+    // there are. old soundtracker modules have no signature and only 15 samples
+    sampleCount := 31
+    channels := 0
+    flt8 := false
+    header, _ := reader.Peek(1084)
+    if len(header) == 1084 {
+        channels, flt8 = signatureChannels(header[1080:])
+    }
+    if channels == 0 {
+        log.Printf("No mod signature, trying a 15 sample soundtracker module")
+        sampleCount = 15
+        channels = 4
+    }
+
     name := make([]byte, 20)
     _, err = io.ReadFull(reader, name)
     if err != nil {
@@ -126,8 +216,7 @@ func Load(reader_ io.Reader) (*ModFile, error) {
 
     var samples []Sample
 
-    // read 31 samples
-    for i := range 31 {
+    for i := range sampleCount {
         sampleName := make([]byte, 22)
         _, err = io.ReadFull(reader, sampleName)
         if err != nil {
@@ -162,13 +251,21 @@ func Load(reader_ io.Reader) (*ModFile, error) {
 
         log.Printf("Sample %v: Name='%s', Length=%d, FineTune=%d, Volume=%d, LoopStart=%d, LoopLength=%d", i, string(sampleName), sampleLength, fineTune, volume, loopStart, loopLength)
 
+        if sampleCount == 15 {
+            // soundtracker has no finetune and stores the loop start in bytes rather than words
+            if volume > 64 || fineTune != 0 {
+                return nil, fmt.Errorf("Not a mod file: invalid sample %v", i)
+            }
+            loopStart /= 2
+        }
+
         samples = append(samples, Sample{
             Name: string(sampleName),
             Length: sampleLength,
             FineTune: fineTune,
             Volume: volume,
-            LoopStart: int(loopStart),
-            LoopLength: int(loopLength),
+            LoopStart: int(loopStart) * 2,
+            LoopLength: int(loopLength) * 2,
         })
     }
 
@@ -190,34 +287,37 @@ func Load(reader_ io.Reader) (*ModFile, error) {
         return nil, fmt.Errorf("Could not read orders: %v", err)
     }
 
+    if sampleCount == 15 && (orderCount == 0 || orderCount > 128) {
+        return nil, fmt.Errorf("Not a mod file: invalid song length %v", orderCount)
+    }
+
+    // flt8 orders count in 4 channel patterns, two of which make up each 8 channel pattern
+    if flt8 {
+        for i := range orders {
+            orders[i] /= 2
+        }
+    }
+
     for _, value := range orders {
+        if sampleCount == 15 && value >= 64 {
+            return nil, fmt.Errorf("Not a mod file: invalid pattern number %v", value)
+        }
         patternMax = max(patternMax, int(value))
     }
 
     log.Printf("Pattern max: %v", patternMax)
 
-    // read mod kind again
-    kind := make([]byte, 4)
-    io.ReadFull(reader, kind)
-
-    channels := 4
-
-    if bytes.Equal(kind, []byte{'M', '.', 'K', '.'}) {
-        channels = 4
-        log.Printf("Detected 4 channel mod")
-    } else if bytes.Equal(kind, []byte{'6', 'C', 'H', 'N'}) {
-        channels = 6
-        log.Printf("Detected 6 channel mod")
-    } else if bytes.Equal(kind, []byte{'8', 'C', 'H', 'N'}) {
-        channels = 8
-        log.Printf("Detected 8 channel mod")
-    } else if bytes.Equal(kind, []byte{'1', '0', 'C', 'H'}) {
-        channels = 10
-        log.Printf("Detected 10 channel mod")
-    } else {
-        return nil, fmt.Errorf("Not a mod file: %v '%v'", kind, string(kind))
+    if sampleCount == 31 {
+        // the signature was already checked
+        kind := make([]byte, 4)
+        _, err = io.ReadFull(reader, kind)
+        if err != nil {
+            return nil, err
+        }
     }
 
+    log.Printf("Detected %v channel mod", channels)
+
     /*
     position, err := reader.Seek(0, io.SeekCurrent)
     log.Printf("Position before patterns: %v", position)
@@ -226,36 +326,24 @@ func Load(reader_ io.Reader) (*ModFile, error) {
     // read patterns
     // a pattern consists of 64 rows where each row contains 'channels' number of notes
     // a note is a sample to play, combined with an effect and pitch
-    patternBytes := make([]byte, 4)
     var patterns []Pattern
     for i := range patternMax + 1 {
         log.Printf("Reading pattern %d", i)
 
-        var rows []Row
-        for range 64 {
-            var row Row
-            for range channels {
-                _, err = io.ReadFull(reader, patternBytes)
+        rows := make([]Row, 64)
+        if flt8 {
+            // channels 0-3 are stored first, then channels 4-7
+            for range 2 {
+                err = readPatternRows(reader, rows, 4)
                 if err != nil {
-                    return nil, fmt.Errorf("Could not read pattern data: %v", err)
+                    return nil, err
                 }
-
-                sampleNumber := (patternBytes[0] & 0xf0) + (patternBytes[2] >> 4)
-                periodFrequency := (uint(patternBytes[0] & 0xf) << 8) + uint(patternBytes[1])
-                effectNumber := patternBytes[2] & 0xf
-                effectParameter := patternBytes[3]
-
-                // log.Printf("Pattern data: Sample=%d, PeriodFrequency=%d, EffectNumber=%d, EffectParameter=%d", sampleNumber, periodFrequency, effectNumber, effectParameter)
-
-                row.Notes = append(row.Notes, Note{
-                    SampleNumber: sampleNumber,
-                    PeriodFrequency: uint16(periodFrequency),
-                    EffectNumber: effectNumber,
-                    EffectParameter: effectParameter,
-                })
             }
-
-            rows = append(rows, row)
+        } else {
+            err = readPatternRows(reader, rows, channels)
+            if err != nil {
+                return nil, err
+            }
         }
 
         patterns = append(patterns, Pattern{
@@ -269,7 +357,7 @@ func Load(reader_ io.Reader) (*ModFile, error) {
     */
 
     // read sample data
-    for i := range 31 {
+    for i := range samples {
         if samples[i].Length == 0 {
             continue
         }
@@ -0,0 +1,143 @@
+package mod
+
+import (
+    "math"
+    "fmt"
+    "strings"
+)
+
+// which amiga the player imitates. AmigaNone plays the samples without any hardware effects
+type AmigaModel int
+
+const (
+    AmigaNone AmigaModel = iota
+    AmigaA500
+    AmigaA1200
+)
+
+// parse a model name such as "a500", an empty string is AmigaNone
+func ParseAmigaModel(name string) (AmigaModel, error) {
+    switch strings.ToLower(name) {
+        case "", "none": return AmigaNone, nil
+        case "a500": return AmigaA500, nil
+        case "a1200": return AmigaA1200, nil
+    }
+
+    return AmigaNone, fmt.Errorf("Unknown amiga model '%v', use a500 or a1200", name)
+}
+
+// the range of periods that paula can play
+const (
+    minimumAmigaPeriod = 113
+    maximumAmigaPeriod = 856
+)
+
+// a 6db/octave filter
+type onePoleFilter struct {
+    coefficient float32
+    state float32
+}
+
+func makeOnePoleFilter(cutoff float64, sampleRate int) onePoleFilter {
+    // a cutoff above nyquist would make the filter unstable, and it wouldn't be audible anyway
+    cutoff = min(cutoff, float64(sampleRate) * 0.45)
+    return onePoleFilter{
+        coefficient: float32(1 - math.Exp(-2 * math.Pi * cutoff / float64(sampleRate))),
+    }
+}
+
+func (filter *onePoleFilter) LowPass(sample float32) float32 {
+    filter.state += (sample - filter.state) * filter.coefficient
+    return filter.state
+}
+
+func (filter *onePoleFilter) HighPass(sample float32) float32 {
+    return sample - filter.LowPass(sample)
+}
+
+// a 12db/octave low pass filter, which is what the LED filter is
+type biquadFilter struct {
+    b0, b1, b2, a1, a2 float32
+    x1, x2, y1, y2 float32
+}
+
+func makeLowPassBiquad(cutoff float64, q float64, sampleRate int) biquadFilter {
+    cutoff = min(cutoff, float64(sampleRate) * 0.45)
+    omega := 2 * math.Pi * cutoff / float64(sampleRate)
+    alpha := math.Sin(omega) / (2 * q)
+    cos := math.Cos(omega)
+    a0 := 1 + alpha
+
+    return biquadFilter{
+        b0: float32((1 - cos) / 2 / a0),
+        b1: float32((1 - cos) / a0),
+        b2: float32((1 - cos) / 2 / a0),
+        a1: float32(-2 * cos / a0),
+        a2: float32((1 - alpha) / a0),
+    }
+}
+
+func (filter *biquadFilter) Process(sample float32) float32 {
+    out := filter.b0 * sample + filter.b1 * filter.x1 + filter.b2 * filter.x2 - filter.a1 * filter.y1 - filter.a2 * filter.y2
+    filter.x2 = filter.x1
+    filter.x1 = sample
+    filter.y2 = filter.y1
+    filter.y1 = out
+    return out
+}
+
+// the analog output stage of one side of an amiga
+type paulaFilter struct {
+    lowPass onePoleFilter
+    highPass onePoleFilter
+    led biquadFilter
+}
+
+func makePaulaFilter(model AmigaModel, sampleRate int) paulaFilter {
+    // the a500 has a fixed filter around 4.4khz, the a1200 filter is far above what anyone can hear
+    cutoff := 4420.97
+    if model == AmigaA1200 {
+        cutoff = 34419.32
+    }
+
+    return paulaFilter{
+        lowPass: makeOnePoleFilter(cutoff, sampleRate),
+        highPass: makeOnePoleFilter(5.2, sampleRate),
+        led: makeLowPassBiquad(3090.5, 0.660, sampleRate),
+    }
+}
+
+func (filter *paulaFilter) Process(sample float32, led bool) float32 {
+    sample = filter.lowPass.LowPass(sample)
+    // keep the led filter running while it is off so that switching it on doesn't click
+    filtered := filter.led.Process(sample)
+    if led {
+        sample = filtered
+    }
+    return filter.highPass.HighPass(sample)
+}
+
+// band limits the steps that paula makes when it moves from one sample to the next.
+// each step is spread over the output sample before and after it, which needs one sample of delay
+type blepState struct {
+    last float32 // the value of the step that is currently held
+    pending float32 // the previous output sample, which may still be corrected
+}
+
+// add the next held value, where fraction is how long ago the step happened, in output samples.
+// returns the previous output sample
+func (blep *blepState) Process(value float32, fraction float32) float32 {
+    current := value
+
+    if value != blep.last {
+        delta := value - blep.last
+        t := max(0, min(1, fraction))
+        blep.pending += delta / 2 * t * t
+        current -= delta / 2 * (1 - t) * (1 - t)
+        blep.last = value
+    }
+
+    out := blep.pending
+    blep.pending = current
+    return out
+}
@@ -11,16 +11,7 @@ import (
 )
 
 type Vibrato struct {
-    Speed int
-    Depth int
-    position int
-}
-
-func (vibrato *Vibrato) Update() {
-    vibrato.position += vibrato.Speed
-    if vibrato.position >= 64 {
-        vibrato.position -= 64
-    }
+    common.Oscillator
 }
 
 func (vibrato *Vibrato) Apply(frequency int) int {
@@ -30,10 +21,23 @@ func (vibrato *Vibrato) Apply(frequency int) int {
 
     // Amiga vibrato is a sine wave with a period of 64
     // and a depth of 8, so we scale the position to that range
-    vibratoValue := int(float64(vibrato.Depth * 2) * math.Sin(float64(vibrato.position) * math.Pi * 360 / 64 / 180))
+    vibratoValue := int(float64(vibrato.Depth * 2) * vibrato.Value())
     return frequency + vibratoValue
 }
 
+type Tremolo struct {
+    common.Oscillator
+}
+
+// change a volume from 0-1 by the tremolo, which moves up to 4 * depth out of 64
+func (tremolo *Tremolo) Apply(volume float32) float32 {
+    delta := float32(float64(tremolo.Depth * 4) * tremolo.Value()) / 64
+    return max(0, min(1, volume + delta))
+}
+
+// the speeds of the EFx invert loop effect
+var funkTable = []int{0, 5, 6, 7, 8, 10, 11, 13, 16, 19, 22, 26, 32, 43, 64, 128}
+
 type Channel struct {
     Player *Player
     AudioBuffer *common.AudioBuffer
@@ -41,6 +45,12 @@ type Channel struct {
     ChannelNumber int
 
     Vibrato Vibrato
+    Tremolo Tremolo
+    // if true then tone portamento moves in whole semitones
+    Glissando bool
+    // -8 to 7, in eighths of a semitone
+    FineTune int
+    Panning int // 0-255, 0 is left and 255 is right
     TonePortamentoTarget int
     TonePortamentoSpeed int
     ArpeggioBase int
@@ -48,6 +58,21 @@ type Channel struct {
     Delay int
 
     VolumeCutTick int
+    RetriggerTicks int
+
+    // E6x pattern loop, protracker keeps these for each channel
+    LoopRow int
+    LoopCount int
+
+    // amiga mode output state
+    blep blepState
+    filters [2]paulaFilter
+    filterModel AmigaModel // the model that the filters were made for
+
+    // EFx invert loop
+    FunkSpeed int
+    FunkCounter int
+    FunkPosition int
 
     SampleOffset int
 
@@ -80,7 +105,8 @@ func (channel *Channel) Read(data []byte) (int, error) {
         return len(data), nil
     }
 
-    samples := len(data) / 4 / 2
+    // the audio buffer holds interleaved left and right samples
+    samples := min(len(data) / 4, len(channel.buffer))
 
     // sampleFrequency := 22050 / 2
     // samples = (samples * sampleFrequency) / channel.Engine.SampleRate
@@ -97,20 +123,15 @@ func (channel *Channel) Read(data []byte) (int, error) {
     for sampleIndex := range floatSamples {
         value := part[sampleIndex]
         bits := math.Float32bits(value)
-        data[i*8+0] = byte(bits)
-        data[i*8+1] = byte(bits >> 8)
-        data[i*8+2] = byte(bits >> 16)
-        data[i*8+3] = byte(bits >> 24)
-
-        data[i*8+4] = byte(bits)
-        data[i*8+5] = byte(bits >> 8)
-        data[i*8+6] = byte(bits >> 16)
-        data[i*8+7] = byte(bits >> 24)
+        data[i*4+0] = byte(bits)
+        data[i*4+1] = byte(bits >> 8)
+        data[i*4+2] = byte(bits >> 16)
+        data[i*4+3] = byte(bits >> 24)
 
         i += 1
     }
 
-    i *= 8
+    i *= 4
 
     // log.Printf("Empty sample data %v / %v", len(data) - i, len(data))
 
@@ -145,7 +166,7 @@ func (channel *Channel) Read(data []byte) (int, error) {
         return 8, nil
     } else {
         // on a normal os we can just return 0 if necessary
-        return floatSamples * 8, nil
+        return floatSamples * 4, nil
     }
 }
 
@@ -240,6 +261,12 @@ func computeInverseAmigaFrequency(frequency int) int {
     return int(float32(amigaFrequency / 2 / float32(frequency)))
 }
 
+// the pan position from 0 for left to 1 for right, after the player's stereo separation
+func (channel *Channel) getPanning() float32 {
+    pan := float32(channel.Panning) / 255
+    return 0.5 + (pan - 0.5) * channel.Player.StereoSeparation
+}
+
 func (channel *Channel) UpdateVolume() {
     up := channel.CurrentEffectParameter >> 4
     down := channel.CurrentEffectParameter & 0xf
@@ -278,15 +305,69 @@ func (channel *Channel) UpdatePortamento(ticks int) {
     }
 }
 
+// the period of a note after applying the channel finetune
+func (channel *Channel) finetunePeriod(period int) int {
+    if channel.FineTune == 0 {
+        return period
+    }
+
+    return int(math.Round(float64(period) * math.Pow(2, -float64(channel.FineTune) / (12 * 8))))
+}
+
+// round a period to the nearest semitone, used by glissando
+func (channel *Channel) roundPeriod(period int) int {
+    if period <= 0 {
+        return period
+    }
+
+    base := float64(channel.finetunePeriod(428))
+    semitones := math.Round(12 * math.Log2(base / float64(period)))
+    return int(math.Round(base / math.Pow(2, semitones / 12)))
+}
+
+// EFx slowly inverts the bytes of the sample loop, one at a time
+func (channel *Channel) updateFunk() {
+    sample := channel.CurrentSample
+    if channel.FunkSpeed == 0 || sample == nil || sample.LoopLength <= 2 {
+        return
+    }
+
+    channel.FunkCounter += funkTable[channel.FunkSpeed]
+    if channel.FunkCounter < 128 {
+        return
+    }
+    channel.FunkCounter = 0
+
+    channel.FunkPosition += 1
+    if channel.FunkPosition >= sample.LoopLength {
+        channel.FunkPosition = 0
+    }
+
+    position := sample.LoopStart + channel.FunkPosition
+    if position < len(sample.Data) {
+        // the data already has the sample volume applied, so scale the -1 of the byte inversion too
+        sample.Data[position] = -sample.Data[position] - float32(sample.Volume) / 64 / 128
+    }
+}
+
 func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
+    // the tick within the current row
+    tick := int(channel.Player.ticks)
+
     if channel.Delay > 0 {
         channel.Delay -= ticks
     }
 
-    if channel.VolumeCutTick > 0 && ticks == channel.VolumeCutTick {
+    if channel.VolumeCutTick > 0 && tick == channel.VolumeCutTick {
         channel.Volume = 0
     }
 
+    if channel.RetriggerTicks > 0 && tick > 0 && tick % channel.RetriggerTicks == 0 {
+        channel.startPosition = 0
+    }
+
+    channel.updateFunk()
+
     switch channel.CurrentEffect {
         case EffectPortamentoUp:
             if !changeRow {
@@ -318,6 +399,10 @@ func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
             if !changeRow {
                 channel.Vibrato.Update()
             }
+        case EffectTremolo:
+            if !changeRow {
+                channel.Tremolo.Update()
+            }
         case EffectArpeggio:
             tick1 := channel.CurrentEffectParameter >> 4
             tick2 := channel.CurrentEffectParameter & 0xf
@@ -350,16 +435,17 @@ func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
             depth := channel.CurrentEffectParameter & 0xf
             */
     }
+
+    if channel.Player.OnChannelTick != nil {
+        channel.Player.OnChannelTick(channel, changeRow, ticks)
+    }
 }
 
 func (channel *Channel) UpdateRow() {
     channel.CurrentEffect = 0
     channel.CurrentEffectParameter = 0
     channel.VolumeCutTick = 0
-    // FIXME: the default waveform is sine retrig, which should reset the position on each row
-    // but most players don't seem to do this, instead just letting the position be whatever it was
-    // on the last row
-    // channel.Vibrato.position = 0
+    channel.RetriggerTicks = 0
 
     note, row := channel.Player.GetNote(channel.ChannelNumber)
     if note.SampleNumber != 0 {
@@ -368,12 +454,6 @@ func (channel *Channel) UpdateRow() {
 
     newFrequency := channel.CurrentFrequency
 
-    if note.PeriodFrequency != 0 {
-        newFrequency = int(note.PeriodFrequency)
-    }
-
-    // var sample *mod.Sample
-
     // log.Printf("new row %v", row)
     channel.currentRow = row
     if note.SampleNumber != 0 {
@@ -381,6 +461,32 @@ func (channel *Channel) UpdateRow() {
         // channel.CurrentNote = note
         channel.startPosition = 0
         channel.Volume = 1.0
+
+        if channel.CurrentSample != nil {
+            channel.FineTune = int(channel.CurrentSample.FineTune & 0xf)
+            if channel.FineTune > 7 {
+                channel.FineTune -= 16
+            }
+        }
+    }
+
+    // E5x changes the finetune of the note that it is played with
+    if note.EffectNumber == EffectExtra && note.EffectParameter >> 4 == 5 {
+        channel.FineTune = int(note.EffectParameter & 0xf)
+        if channel.FineTune > 7 {
+            channel.FineTune -= 16
+        }
+    }
+
+    if note.PeriodFrequency != 0 {
+        newFrequency = channel.finetunePeriod(int(note.PeriodFrequency))
+
+        // a new note restarts the waveforms, but tone portamento only changes the target
+        if note.EffectNumber != EffectTonePortamento && note.EffectNumber != EffectPortamentoAndVolumeSlide {
+            channel.Vibrato.Retrigger()
+            channel.Tremolo.Retrigger()
+            channel.FunkPosition = 0
+        }
     }
 
     switch note.EffectNumber {
@@ -409,7 +515,7 @@ func (channel *Channel) UpdateRow() {
             if note.EffectParameter > 0 {
                 channel.CurrentEffectParameter = int(note.EffectParameter)
                 if note.PeriodFrequency != 0 {
-                    channel.TonePortamentoTarget = int(note.PeriodFrequency)
+                    channel.TonePortamentoTarget = channel.finetunePeriod(int(note.PeriodFrequency))
                 }
                 channel.TonePortamentoSpeed = int(note.EffectParameter)
             }
@@ -433,14 +539,15 @@ func (channel *Channel) UpdateRow() {
             channel.CurrentEffect = EffectVibratoAndVolumeSlide
             channel.CurrentEffectParameter = int(note.EffectParameter)
         case EffectPositionJump:
-            channel.Player.SetOrder(int(note.EffectParameter))
+            channel.Player.DoJump = true
+            channel.Player.JumpOrder = int(note.EffectParameter)
         case EffectPatternBreak:
-            channel.Player.NextOrder()
             value := int(note.EffectParameter >> 4) * 10 + int(note.EffectParameter & 0xf)
             if value > 63 {
                 value = 0
             }
-            channel.Player.CurrentRow = value
+            channel.Player.DoBreak = true
+            channel.Player.BreakRow = value
         case EffectVibrato:
             channel.CurrentEffect = EffectVibrato
             channel.CurrentEffectParameter = int(note.EffectParameter)
@@ -455,15 +562,60 @@ func (channel *Channel) UpdateRow() {
             if depth > 0 {
                 channel.Vibrato.Depth = int(depth)
             }
+        case EffectTremolo:
+            channel.CurrentEffect = EffectTremolo
+            channel.CurrentEffectParameter = int(note.EffectParameter)
+
+            speed := note.EffectParameter >> 4
+            depth := note.EffectParameter & 0xf
+
+            if speed > 0 {
+                channel.Tremolo.Speed = int(speed)
+            }
+
+            if depth > 0 {
+                channel.Tremolo.Depth = int(depth)
+            }
+        case EffectPan:
+            if channel.Player.PanningEffects {
+                channel.Panning = int(note.EffectParameter)
+            }
         case EffectExtra:
+            value := int(note.EffectParameter & 0xf)
             switch note.EffectParameter >> 4 {
                 case 0:
-                    // set hardware filter, ignore
+                    // E00 turns the filter on, E01 turns it off
+                    channel.Player.LEDFilter = value & 1 == 0
                 case 1:
-                    // fine portamento up
-                    channel.CurrentFrequency -= int(note.EffectParameter & 0xf)
+                    // fine portamento up. the slide goes on the new frequency, which is set at the end of the row
+                    newFrequency = max(newFrequency - value, 1)
                 case 2:
-                    channel.CurrentFrequency += int(note.EffectParameter & 0xf)
+                    newFrequency = min(newFrequency + value, 2000)
+                case 3:
+                    channel.Glissando = value != 0
+                case 4:
+                    channel.Vibrato.SetControl(value)
+                case 5:
+                    // finetune was already applied to the note
+                case 6:
+                    channel.doPatternLoop(value)
+                case 7:
+                    channel.Tremolo.SetControl(value)
+                case 8:
+                    if channel.Player.PanningEffects {
+                        channel.Panning = value * 17
+                    }
+                case 9:
+                    channel.RetriggerTicks = value
+                case 0xa:
+                    // fine volume slide up
+                    channel.Volume = min(channel.Volume + float32(value) / 64.0, 1.0)
+                case 0xe:
+                    if channel.Player.PatternDelay == 0 {
+                        channel.Player.PatternDelay = value
+                    }
+                case 0xf:
+                    channel.FunkSpeed = value
                 case 0xb:
                     // fine volume slide down
                     channel.Volume = max(channel.Volume - float32(note.EffectParameter & 0xf) / 64.0, 0.0)
@@ -487,6 +639,29 @@ func (channel *Channel) UpdateRow() {
     }
 
     channel.CurrentFrequency = newFrequency
+
+    if channel.Player.OnChannelRow != nil {
+        channel.Player.OnChannelRow(channel)
+    }
+}
+
+// E60 marks the start of a loop, E6x with x > 0 jumps back to it x times
+func (channel *Channel) doPatternLoop(value int) {
+    if value == 0 {
+        channel.LoopRow = channel.currentRow
+        return
+    }
+
+    if channel.LoopCount == 0 {
+        channel.LoopCount = value
+    } else {
+        channel.LoopCount -= 1
+    }
+
+    if channel.LoopCount > 0 {
+        channel.Player.DoLoop = true
+        channel.Player.LoopRow = channel.LoopRow
+    }
 }
 
 func (channel *Channel) Update(rate float32) error {
@@ -531,13 +706,32 @@ func (channel *Channel) Update(rate float32) error {
     channel.AudioBuffer.Lock()
     channel.ScopeBuffer.Lock()
 
+    leftPan, rightPan := channel.Player.PanLaw.Gains(channel.getPanning())
+    amiga := channel.Player.Amiga != AmigaNone
+    if amiga && channel.filterModel != channel.Player.Amiga {
+        channel.filterModel = channel.Player.Amiga
+        channel.filters[0] = makePaulaFilter(channel.filterModel, channel.Player.SampleRate)
+        channel.filters[1] = makePaulaFilter(channel.filterModel, channel.Player.SampleRate)
+    }
+
     if channel.CurrentSample != nil && int(channel.startPosition) < len(channel.CurrentSample.Data) && channel.CurrentFrequency > 0 && channel.Delay <= 0 {
         frequency := channel.CurrentFrequency
+        if channel.Glissando && (channel.CurrentEffect == EffectTonePortamento || channel.CurrentEffect == EffectPortamentoAndVolumeSlide) {
+            frequency = channel.roundPeriod(frequency)
+        }
         if channel.CurrentEffect == EffectVibrato {
             frequency = channel.Vibrato.Apply(frequency)
         }
+        if amiga {
+            frequency = max(minimumAmigaPeriod, min(maximumAmigaPeriod, frequency))
+        }
         incrementRate := computeAmigaFrequency(frequency) / float32(channel.Player.SampleRate)
 
+        volume := channel.Volume
+        if channel.CurrentEffect == EffectTremolo {
+            volume = channel.Tremolo.Apply(volume)
+        }
+
         // log.Printf("Write sample %v at %v/%v samples %v rate %v", channel.CurrentSample.Name, channel.startPosition, len(channel.CurrentSample.Data), samples, incrementRate)
 
         if incrementRate > 0 {
@@ -548,16 +742,25 @@ func (channel *Channel) Update(rate float32) error {
                     break
                 }
                 */
-                if position >= len(channel.CurrentSample.Data) || (channel.CurrentSample.LoopLength > 1 && position >= (channel.CurrentSample.LoopStart + channel.CurrentSample.LoopLength) * 2) {
-                    if channel.CurrentSample.LoopLength > 1 {
-                        channel.startPosition = float32(channel.CurrentSample.LoopStart * 2)
+                if position >= len(channel.CurrentSample.Data) || (channel.CurrentSample.LoopLength > 2 && position >= channel.CurrentSample.LoopStart + channel.CurrentSample.LoopLength) {
+                    if channel.CurrentSample.LoopLength > 2 {
+                        channel.startPosition = float32(channel.CurrentSample.LoopStart)
                         position = int(channel.startPosition)
                     } else {
                         break
                     }
                 }
-                channel.AudioBuffer.UnsafeWrite(channel.CurrentSample.Data[position] * channel.Volume)
-                channel.ScopeBuffer.UnsafeWrite(channel.CurrentSample.Data[position] * channel.Volume)
+                sample := channel.CurrentSample.Data[position] * volume
+                if amiga {
+                    // how long ago the sample started to be held, in output samples
+                    fraction := (channel.startPosition - float32(position)) / incrementRate
+                    channel.writeAmigaSample(sample, fraction, leftPan, rightPan)
+                } else {
+                    channel.AudioBuffer.UnsafeWrite(sample * leftPan)
+                    channel.AudioBuffer.UnsafeWrite(sample * rightPan)
+                    channel.ScopeBuffer.UnsafeWrite(sample * leftPan)
+                    channel.ScopeBuffer.UnsafeWrite(sample * rightPan)
+                }
                 channel.startPosition += incrementRate
                 samplesWritten += 1
             }
@@ -574,7 +777,15 @@ func (channel *Channel) Update(rate float32) error {
     }
 
     for range (samples - samplesWritten) {
+        if amiga {
+            // the filters still ring after the sample stops
+            channel.writeAmigaSample(0, 0, leftPan, rightPan)
+            continue
+        }
+
         channel.AudioBuffer.UnsafeWrite(0.0)
+        channel.AudioBuffer.UnsafeWrite(0.0)
+        channel.ScopeBuffer.UnsafeWrite(0.0)
         channel.ScopeBuffer.UnsafeWrite(0.0)
     }
 
@@ -584,14 +795,35 @@ func (channel *Channel) Update(rate float32) error {
     return nil
 }
 
+// write a sample the way paula would play it, with band limited steps and the output filters
+func (channel *Channel) writeAmigaSample(sample float32, fraction float32, leftPan float32, rightPan float32) {
+    sample = channel.blep.Process(sample, fraction)
+
+    led := channel.Player.LEDFilter
+    left := channel.filters[0].Process(sample * leftPan, led)
+    right := channel.filters[1].Process(sample * rightPan, led)
+
+    channel.AudioBuffer.UnsafeWrite(left)
+    channel.AudioBuffer.UnsafeWrite(right)
+    channel.ScopeBuffer.UnsafeWrite(left)
+    channel.ScopeBuffer.UnsafeWrite(right)
+}
+
 func MakeChannelVoice(channelNumber int, player *Player) *Channel {
+    // the amiga plays channels 0 and 3 on the left and 1 and 2 on the right, repeating every 4 channels
+    panning := 0
+    if channelNumber % 4 == 1 || channelNumber % 4 == 2 {
+        panning = 255
+    }
+
     channel := &Channel{
         Player: player,
         ChannelNumber: channelNumber,
-        AudioBuffer: common.MakeAudioBuffer(player.SampleRate),
-        ScopeBuffer: common.MakeAudioBuffer(player.SampleRate / 10),
+        AudioBuffer: common.MakeAudioBuffer(player.SampleRate * 2),
+        ScopeBuffer: common.MakeAudioBuffer(player.SampleRate * 2 / 10),
         Volume: 1.0,
-        buffer: make([]float32, player.SampleRate),
+        Panning: panning,
+        buffer: make([]float32, player.SampleRate * 2),
         // currentRow: -1,
     }
 
@@ -613,9 +845,37 @@ type Player struct {
     OnChangeOrder func(int, int)
     OnChangeSpeed func(int, int)
 
+    // formats that are played by the mod player can do more with a channel once the mod player
+    // has read its row, or has run the effects of a tick
+    OnChannelRow func(channel *Channel)
+    OnChannelTick func(channel *Channel, changeRow bool, ticks int)
+
     // count of the orders played
     OrdersPlayed int
 
+    // 0 plays every channel in the center, 1 keeps the full left/right placement
+    StereoSeparation float32
+    PanLaw common.PanLaw
+    // if true then 8xx and E8x change the panning of a channel
+    PanningEffects bool
+
+    // imitate the sound of an amiga
+    Amiga AmigaModel
+    // the low pass filter that E0x turns on and off, named after the power LED that it also controlled
+    LEDFilter bool
+
+    // what happens at the end of the current row
+    DoBreak bool
+    BreakRow int
+    DoJump bool
+    JumpOrder int
+    DoLoop bool
+    LoopRow int
+    // EEx repeats the current row this many more times
+    PatternDelay int
+    // the song was stopped, and nothing more is played
+    Stopped bool
+
     ticks float32
     // rowPosition float32
 }
@@ -627,6 +887,8 @@ func MakePlayer(modfile *ModFile, sampleRate int) *Player {
         Speed: 6,
         BPM: 125,
         CurrentRow: -1,
+        StereoSeparation: 1,
+        PanningEffects: true,
         // CurrentOrder: 0xa,
     }
 
@@ -637,6 +899,16 @@ func MakePlayer(modfile *ModFile, sampleRate int) *Player {
     return player
 }
 
+// imitate the sound of the given amiga model, or AmigaNone to play without it
+func (player *Player) SetAmiga(model AmigaModel) {
+    player.Amiga = model
+}
+
+// how far apart the left and right channels are, from 0 for mono to 1 for full separation
+func (player *Player) SetStereoSeparation(separation float32) {
+    player.StereoSeparation = max(0, min(1, separation))
+}
+
 func (player *Player) ToggleMuteChannel(channel int) bool {
     if channel < 0 || channel >= len(player.Channels) {
         return false
@@ -774,7 +1046,7 @@ func (player *Player) GetCurrentOrder() int {
 }
 
 func (player *Player) IsStereo() bool {
-    return false
+    return true
 }
 
 func (player *Player) GetChannelData(channel int, data []float32) int {
@@ -785,40 +1057,94 @@ func (player *Player) GetChannelData(channel int, data []float32) int {
     return player.Channels[channel].ScopeBuffer.Peek(data)
 }
 
+// move on to the given order as the song plays
+func (player *Player) advanceOrder(order int) {
+    player.CurrentOrder = order
+    player.OrdersPlayed += 1
+    if player.CurrentOrder >= player.ModFile.SongLength {
+        player.CurrentOrder = 0
+    }
+
+    if player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+    }
+
+    log.Printf("order %v next pattern: %v", player.CurrentOrder, player.GetPattern())
+}
+
+// go to the next row, following any loop, jump or break from the row that just finished
+func (player *Player) nextRow() {
+    if player.DoLoop {
+        player.CurrentRow = player.LoopRow
+    } else if player.DoJump || player.DoBreak {
+        if player.DoJump {
+            player.advanceOrder(player.JumpOrder)
+        } else {
+            player.advanceOrder(player.CurrentOrder + 1)
+        }
+
+        player.CurrentRow = 0
+        if player.DoBreak {
+            player.CurrentRow = player.BreakRow
+        }
+    } else {
+        player.CurrentRow += 1
+        // log.Printf("Row: %v", player.CurrentRow)
+
+        if player.CurrentRow > len(player.ModFile.Patterns[player.GetPattern()].Rows) - 1 {
+            // player.rowPosition = 0
+            player.CurrentRow = 0
+            player.advanceOrder(player.CurrentOrder + 1)
+        }
+    }
+
+    player.DoLoop = false
+    player.DoJump = false
+    player.DoBreak = false
+}
+
+// stop the song and silence every channel
+func (player *Player) Stop() {
+    player.Stopped = true
+    for _, channel := range player.Channels {
+        channel.CurrentSample = nil
+    }
+}
+
 func (player *Player) Update(timeDelta float32) {
-    oldRow := player.CurrentRow
+    if player.Stopped {
+        for _, channel := range player.Channels {
+            channel.Update(timeDelta)
+        }
+        return
+    }
+
     oldTicks := int(player.ticks)
 
+    // true if a new row starts, even if a pattern loop went back to the same row
+    rowChanged := false
+
     if player.CurrentRow < 0 {
         player.CurrentRow = 0
+        rowChanged = true
     }
 
     player.ticks += timeDelta * float32(player.BPM) * 2 / 5
     newTicks := int(player.ticks)
 
     if player.ticks >= float32(player.Speed) {
-        player.CurrentRow += 1
-        // log.Printf("Row: %v", player.CurrentRow)
         player.ticks -= float32(player.Speed)
-    }
 
-    if player.CurrentRow > len(player.ModFile.Patterns[0].Rows) - 1 {
-        // player.rowPosition = 0
-        player.CurrentRow = 0
-        player.CurrentOrder += 1
-        player.OrdersPlayed += 1
-        if player.CurrentOrder >= player.ModFile.SongLength {
-            player.CurrentOrder = 0
-        }
-
-        if player.OnChangeOrder != nil {
-            player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+        if player.PatternDelay > 0 {
+            // play the row again without triggering its notes
+            player.PatternDelay -= 1
+        } else {
+            player.nextRow()
+            rowChanged = true
         }
-
-        log.Printf("order %v next pattern: %v", player.CurrentOrder, player.GetPattern())
     }
 
-    if oldRow != player.CurrentRow {
+    if rowChanged {
         if player.OnChangeRow != nil {
             player.OnChangeRow(player.CurrentRow)
         }
@@ -826,7 +1152,7 @@ func (player *Player) Update(timeDelta float32) {
 
     for _, channel := range player.Channels {
         changeRow := false
-        if oldRow != channel.currentRow {
+        if rowChanged || player.CurrentRow != channel.currentRow {
             channel.UpdateRow()
             changeRow = true
         }
@@ -854,11 +1180,11 @@ func (player *Player) RenderToPCM() io.Reader {
     // make a buffer to hold 1/100th of a second of audio data, which is 4-bytes per sample
     // and 1 samples per channel
     rate := 100
-    buffer := make([]float32, player.SampleRate / rate)
+    buffer := make([]float32, player.SampleRate * 2 / rate)
     mix := make([]float32, player.SampleRate * 2 / rate)
 
     fillMix := func() bool {
-        if player.OrdersPlayed >= player.ModFile.SongLength {
+        if player.OrdersPlayed >= player.ModFile.SongLength || player.Stopped {
             return false
         }
 
@@ -876,10 +1202,7 @@ func (player *Player) RenderToPCM() io.Reader {
             if amount > 0 {
                 // copy the samples into the mix buffer
                 for i := range amount {
-                    // mono to stereo
-                    mixed := mix[i*2+0] + buffer[i]
-                    mix[i*2+0] = mixed
-                    mix[i*2+1] = mixed
+                    mix[i] = mix[i] + buffer[i]
                 }
             }
         }
@@ -898,7 +1221,7 @@ func (player *Player) RenderToPCM() io.Reader {
             return 0, nil
         }
 
-        if player.OrdersPlayed >= player.ModFile.SongLength {
+        if player.OrdersPlayed >= player.ModFile.SongLength || player.Stopped {
             return 0, io.EOF
         }
 
@@ -0,0 +1,271 @@
+package mtm
+
+import (
+    "io"
+    "bufio"
+    "bytes"
+    "fmt"
+    "log"
+    "math"
+    "slices"
+    "strings"
+    "encoding/binary"
+
+    "github.com/kazzmir/tracker/mod"
+)
+
+// every pattern refers to this many tracks, but only the first Channels of them are played
+const MaxChannels = 32
+
+// tracks are always stored with 64 rows, even if a song uses fewer rows per pattern
+const trackRows = 64
+
+// multitracker stores each channel of a pattern as a separate track that patterns can share.
+// the effects are the same as protracker, so the tracks are put back together into mod patterns
+type MTMFile struct {
+    mod.ModFile
+    Version byte // 0x10 is version 1.0
+    Comment string
+    // 0-15 for each channel, 0 is left and 15 is right
+    Panning [MaxChannels]byte
+    // the notes of every track, track number 0 is always empty and is not stored
+    Tracks [][]mod.Note
+    // the track that each channel of a pattern plays
+    PatternTracks [][MaxChannels]uint16
+}
+
+// the amiga period of a multitracker note, where note 0 is the same pitch as period 1712
+func notePeriod(note byte) uint16 {
+    return uint16(math.Round(1712 / math.Pow(2, float64(note) / 12)))
+}
+
+// a pattern of empty notes
+func makePattern(rows int, channels int) mod.Pattern {
+    pattern := mod.Pattern{
+        Rows: make([]mod.Row, rows),
+    }
+
+    for row := range pattern.Rows {
+        pattern.Rows[row].Notes = make([]mod.Note, channels)
+    }
+
+    return pattern
+}
+
+// read one track, which is 64 notes of 3 bytes each
+func readTrack(reader io.Reader) ([]mod.Note, error) {
+    data := make([]byte, trackRows * 3)
+    _, err := io.ReadFull(reader, data)
+    if err != nil {
+        return nil, err
+    }
+
+    notes := make([]mod.Note, trackRows)
+    for row := range notes {
+        // nnnnnnii iiiieeee pppppppp
+        entry := data[row * 3:]
+        note := entry[0] >> 2
+        if note > 0 {
+            notes[row].PeriodFrequency = notePeriod(note)
+        }
+        notes[row].SampleNumber = (entry[0] & 3) << 4 | entry[1] >> 4
+        notes[row].EffectNumber = entry[1] & 0xf
+        notes[row].EffectParameter = entry[2]
+    }
+
+    return notes, nil
+}
+
+// read sample data, which is unsigned for both 8 and 16-bit samples
+func readSampleData(reader io.Reader, sample *mod.Sample, length int, is16Bit bool) error {
+    // the length is 32 bits, so only what the file really has is read
+    raw, err := io.ReadAll(io.LimitReader(reader, int64(length)))
+    if err != nil {
+        return err
+    }
+    if len(raw) < length {
+        return io.ErrUnexpectedEOF
+    }
+
+    // the mod player expects the sample volume to already be applied
+    volume := float32(sample.Volume) / 64
+
+    if is16Bit {
+        sample.Data = make([]float32, length / 2)
+        for i := range sample.Data {
+            value := int(binary.LittleEndian.Uint16(raw[i * 2:])) - 32768
+            sample.Data[i] = float32(value) / 32768 * volume
+        }
+    } else {
+        sample.Data = make([]float32, length)
+        for i, value := range raw {
+            sample.Data[i] = float32(int(value) - 128) / 128 * volume
+        }
+    }
+
+    return nil
+}
+
+func Load(reader_ io.Reader, logger *log.Logger) (*MTMFile, error) {
+    reader := bufio.NewReader(reader_)
+
+    header := make([]byte, 66)
+    _, err := io.ReadFull(reader, header)
+    if err != nil {
+        return nil, err
+    }
+
+    if !bytes.Equal(header[:3], []byte("MTM")) {
+        return nil, fmt.Errorf("Not an mtm file, signature was %v", header[:3])
+    }
+
+    var file MTMFile
+    file.Version = header[3]
+    file.Name = string(bytes.TrimRight(header[4:24], "\x00"))
+
+    trackCount := int(binary.LittleEndian.Uint16(header[24:]))
+    lastPattern := int(header[26])
+    lastOrder := int(header[27])
+    commentLength := int(binary.LittleEndian.Uint16(header[28:]))
+    sampleCount := int(header[30])
+    rows := int(header[32])
+    file.Channels = int(header[33])
+    copy(file.Panning[:], header[34:66])
+
+    logger.Printf("MTM version 0x%x name '%v'", file.Version, file.Name)
+    logger.Printf("Tracks %v patterns %v orders %v samples %v rows %v channels %v", trackCount, lastPattern + 1, lastOrder + 1, sampleCount, rows, file.Channels)
+
+    if rows == 0 || rows > trackRows {
+        rows = trackRows
+    }
+
+    if file.Channels == 0 || file.Channels > MaxChannels {
+        return nil, fmt.Errorf("Invalid number of channels %v", file.Channels)
+    }
+
+    for i := range file.Panning {
+        file.Panning[i] &= 0xf
+    }
+
+    type sampleInfo struct {
+        length int
+        is16Bit bool
+    }
+
+    var infos []sampleInfo
+
+    for i := range sampleCount {
+        data := make([]byte, 37)
+        _, err = io.ReadFull(reader, data)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading sample %v: %v", i, err)
+        }
+
+        length := int(binary.LittleEndian.Uint32(data[22:]))
+        loopStart := int(binary.LittleEndian.Uint32(data[26:]))
+        loopEnd := int(binary.LittleEndian.Uint32(data[30:]))
+        is16Bit := data[36] & 1 != 0
+
+        sample := mod.Sample{
+            Name: string(bytes.TrimRight(data[:22], "\x00")),
+            FineTune: data[34] & 0xf,
+            Volume: min(data[35], 64),
+        }
+
+        // loops that are too short or don't fit in the sample are not used
+        loopEnd = min(loopEnd, length)
+        if loopEnd > loopStart + 2 {
+            sample.LoopStart = loopStart
+            sample.LoopLength = loopEnd - loopStart
+        }
+
+        if is16Bit {
+            sample.LoopStart /= 2
+            sample.LoopLength /= 2
+        }
+
+        logger.Printf("Sample %v: Name='%s', Length=%d, FineTune=%d, Volume=%d, LoopStart=%d, LoopLength=%d, 16-bit=%v", i, sample.Name, length, sample.FineTune, sample.Volume, sample.LoopStart, sample.LoopLength, is16Bit)
+
+        file.Samples = append(file.Samples, sample)
+        infos = append(infos, sampleInfo{length: length, is16Bit: is16Bit})
+    }
+
+    orders := make([]byte, 128)
+    _, err = io.ReadFull(reader, orders)
+    if err != nil {
+        return nil, fmt.Errorf("Could not read orders: %v", err)
+    }
+
+    file.Orders = orders
+    file.SongLength = min(lastOrder + 1, len(orders))
+
+    for i := range trackCount {
+        track, err := readTrack(reader)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading track %v: %v", i, err)
+        }
+        file.Tracks = append(file.Tracks, track)
+    }
+
+    for i := range lastPattern + 1 {
+        var tracks [MaxChannels]uint16
+        err = binary.Read(reader, binary.LittleEndian, &tracks)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading pattern %v: %v", i, err)
+        }
+        file.PatternTracks = append(file.PatternTracks, tracks)
+    }
+
+    comment := make([]byte, commentLength)
+    _, err = io.ReadFull(reader, comment)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading comment: %v", err)
+    }
+
+    // the comment is made of 40 character lines, padded with zeros
+    var lines []string
+    for line := range slices.Chunk(comment, 40) {
+        lines = append(lines, string(bytes.TrimRight(line, "\x00")))
+    }
+    file.Comment = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+
+    for i := range file.Samples {
+        err = readSampleData(reader, &file.Samples[i], infos[i].length, infos[i].is16Bit)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading data of sample %v: %v", i, err)
+        }
+    }
+
+    // put the tracks of each pattern together
+    for _, tracks := range file.PatternTracks {
+        pattern := makePattern(rows, file.Channels)
+
+        for channel := range file.Channels {
+            track := int(tracks[channel])
+            if track == 0 {
+                continue
+            }
+
+            if track > len(file.Tracks) {
+                logger.Printf("Pattern refers to missing track %v", track)
+                continue
+            }
+
+            for row := range pattern.Rows {
+                pattern.Rows[row].Notes[channel] = file.Tracks[track - 1][row]
+            }
+        }
+
+        file.Patterns = append(file.Patterns, pattern)
+    }
+
+    // orders past the last pattern play as empty patterns
+    for _, order := range file.Orders[:file.SongLength] {
+        for int(order) >= len(file.Patterns) {
+            file.PatternTracks = append(file.PatternTracks, [MaxChannels]uint16{})
+            file.Patterns = append(file.Patterns, makePattern(rows, file.Channels))
+        }
+    }
+
+    return &file, nil
+}
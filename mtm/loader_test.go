@@ -0,0 +1,28 @@
+package mtm
+
+import (
+    "testing"
+    "bytes"
+
+    "github.com/kazzmir/tracker/mod"
+)
+
+func TestReadSampleData(test *testing.T) {
+    sample := mod.Sample{Volume: 64}
+    err := readSampleData(bytes.NewReader([]byte{0x80, 0xc0, 0x40}), &sample, 3, false)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    for i, expected := range []float32{0, 0.5, -0.5} {
+        if sample.Data[i] != expected {
+            test.Errorf("sample %v: expected %v, got %v", i, expected, sample.Data[i])
+        }
+    }
+
+    // a length much larger than the data is an error, not an allocation of the whole length
+    err = readSampleData(bytes.NewReader([]byte{1, 2, 3}), &sample, 0x7ffffff0, true)
+    if err == nil {
+        test.Errorf("expected an error for a sample that is cut off")
+    }
+}
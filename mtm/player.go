@@ -0,0 +1,25 @@
+package mtm
+
+import (
+    "github.com/kazzmir/tracker/mod"
+)
+
+// multitracker plays its notes the same way as protracker, so the mod player does the work.
+// the difference is that every channel starts at the panning from the song
+type Player struct {
+    *mod.Player
+    File *MTMFile
+}
+
+func MakePlayer(file *MTMFile, sampleRate int) *Player {
+    player := mod.MakePlayer(&file.ModFile, sampleRate)
+
+    for i, channel := range player.Channels {
+        channel.Panning = int(file.Panning[i]) * 17
+    }
+
+    return &Player{
+        Player: player,
+        File: file,
+    }
+}
@@ -0,0 +1,339 @@
+package okt
+
+import (
+    "io"
+    "bufio"
+    "bytes"
+    "fmt"
+    "log"
+    "slices"
+    "encoding/binary"
+)
+
+const (
+    // the four amiga channels can each be split in two, so a song has between 4 and 8 channels
+    HardwareChannels = 4
+    MaxChannels = 8
+    MaxOrders = 128
+)
+
+const (
+    EffectNone = 0
+    // lower the period, which raises the pitch
+    EffectPortamentoDown = 1
+    EffectPortamentoUp = 2
+    // the three arpeggios play the note, the note plus the low nibble and the note minus the high nibble
+    // in different patterns
+    EffectArpeggio1 = 10
+    EffectArpeggio2 = 11
+    EffectArpeggio3 = 12
+    // move the note down by a number of semitones once
+    EffectNoteSlideDownOnce = 13
+    EffectFilter = 15
+    EffectNoteSlideUpOnce = 17
+    // move the note down by a number of semitones every tick
+    EffectNoteSlideDown = 21
+    EffectPositionJump = 25
+    // let the sample play past its loop
+    EffectRelease = 27
+    EffectSetSpeed = 28
+    EffectNoteSlideUp = 30
+    // set the volume, or slide it with the higher values
+    EffectVolume = 31
+)
+
+// the amiga periods of the 36 notes, C-1 to B-3
+var periods = []int{
+    856, 808, 762, 720, 678, 640, 604, 570, 538, 508, 480, 453,
+    428, 404, 381, 360, 339, 320, 302, 285, 269, 254, 240, 226,
+    214, 202, 190, 180, 170, 160, 151, 143, 135, 127, 120, 113,
+}
+
+type Note struct {
+    Note int // 1-36, 0 for no note
+    SampleNumber int // 1-36
+    Effect int
+    EffectParameter int
+}
+
+// the position and name of the note are the same as a protracker note with the same period
+func (note *Note) GetNotePosition() int {
+    if note.Note > 0 {
+        return note.Note - 1 + 36
+    }
+
+    return 0
+}
+
+func (note *Note) GetName() string {
+    if note.Note > 0 {
+        names := []string{"C-", "C#", "D-", "D#", "E-", "F-", "F#", "G-", "G#", "A-", "A#", "B-"}
+        return fmt.Sprintf("%v%v", names[(note.Note - 1) % 12], (note.Note - 1) / 12 + 3)
+    }
+
+    return "..."
+}
+
+func (note *Note) GetSampleName() string {
+    if note.Note > 0 {
+        return fmt.Sprintf("%02d", note.SampleNumber)
+    }
+
+    return ".."
+}
+
+// oktalyzer does not have a volume column
+func (note *Note) GetVolumeName() string {
+    return ""
+}
+
+func (note *Note) GetEffectName() string {
+    if note.Effect > 0 {
+        return fmt.Sprintf("%02d%02X", note.Effect, note.EffectParameter)
+    }
+
+    return "..."
+}
+
+type Pattern struct {
+    // each row has a note for every channel
+    Rows [][]Note
+}
+
+type Sample struct {
+    Name string
+    Volume int // 0-64
+    // the loop in samples, a loop length of 0 doesn't loop
+    LoopStart int
+    LoopLength int
+    Data []float32
+}
+
+type OKTFile struct {
+    Name string
+    // true for each of the four amiga channels that is split into two channels
+    Split [HardwareChannels]bool
+    Channels int
+    InitialSpeed int
+    Samples []Sample
+    Patterns []Pattern
+    Orders []byte
+}
+
+func (file *OKTFile) GetPattern(order int) *Pattern {
+    if order < 0 || order >= len(file.Orders) {
+        return nil
+    }
+
+    return &file.Patterns[file.Orders[order]]
+}
+
+// the amiga channel that a channel of the song is played on
+func (file *OKTFile) HardwareChannel(channel int) int {
+    for hardware := range HardwareChannels {
+        if channel == 0 || (channel == 1 && file.Split[hardware]) {
+            return hardware
+        }
+
+        channel -= 1
+        if file.Split[hardware] {
+            channel -= 1
+        }
+    }
+
+    return 0
+}
+
+func readPattern(data []byte, channels int) (Pattern, error) {
+    if len(data) < 2 {
+        return Pattern{}, fmt.Errorf("pattern is too short")
+    }
+
+    rowCount := int(binary.BigEndian.Uint16(data))
+    data = data[2:]
+
+    if len(data) < rowCount * channels * 4 {
+        return Pattern{}, fmt.Errorf("pattern has %v rows but only %v bytes", rowCount, len(data))
+    }
+
+    var pattern Pattern
+
+    for row := range rowCount {
+        notes := make([]Note, channels)
+        for channel := range channels {
+            // note, sample, effect, parameter
+            entry := data[(row * channels + channel) * 4:]
+            if entry[0] > 0 && int(entry[0]) <= len(periods) {
+                notes[channel].Note = int(entry[0])
+                notes[channel].SampleNumber = int(entry[1]) + 1
+            }
+            notes[channel].Effect = int(entry[2])
+            notes[channel].EffectParameter = int(entry[3])
+        }
+        pattern.Rows = append(pattern.Rows, notes)
+    }
+
+    return pattern, nil
+}
+
+func Load(reader_ io.Reader, logger *log.Logger) (*OKTFile, error) {
+    reader := bufio.NewReader(reader_)
+
+    signature := make([]byte, 8)
+    _, err := io.ReadFull(reader, signature)
+    if err != nil {
+        return nil, err
+    }
+
+    if !bytes.Equal(signature, []byte("OKTASONG")) {
+        return nil, fmt.Errorf("Not an okt file, signature was %v", signature)
+    }
+
+    var file OKTFile
+    file.InitialSpeed = 6
+
+    songLength := 0
+    // the sample bodies are only stored for samples that have a length, in the same order as the samples
+    var lengths []int
+    nextSample := 0
+
+    // the rest of the file is a list of chunks, each with a 4 letter name and a length
+    for {
+        header := make([]byte, 8)
+        _, err = io.ReadFull(reader, header)
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, fmt.Errorf("Error reading chunk: %v", err)
+        }
+
+        name := string(header[:4])
+        length := binary.BigEndian.Uint32(header[4:])
+
+        // the length is not trusted, only the bytes that are really in the file are kept
+        data, err := io.ReadAll(io.LimitReader(reader, int64(length)))
+        if err != nil {
+            return nil, fmt.Errorf("Error reading chunk %v: %v", name, err)
+        }
+
+        if len(data) < int(length) {
+            // some files are cut off in the middle of the last sample
+            if name == "SBOD" {
+                logger.Printf("Sample data is cut off")
+            } else {
+                return nil, fmt.Errorf("Error reading chunk %v: %v", name, io.ErrUnexpectedEOF)
+            }
+        }
+
+        switch name {
+            case "CMOD":
+                if len(data) < 8 {
+                    return nil, fmt.Errorf("Invalid channel modes")
+                }
+                for i := range HardwareChannels {
+                    file.Split[i] = binary.BigEndian.Uint16(data[i * 2:]) != 0
+                    file.Channels += 1
+                    if file.Split[i] {
+                        file.Channels += 1
+                    }
+                }
+            case "SAMP":
+                for entry := range slices.Chunk(data, 32) {
+                    if len(entry) < 32 {
+                        break
+                    }
+
+                    // name, length, loop start and length in words, volume and the mode
+                    sample := Sample{
+                        Name: string(bytes.TrimRight(entry[:20], "\x00")),
+                        Volume: min(int(entry[29]), 64),
+                    }
+
+                    loopStart := int(binary.BigEndian.Uint16(entry[24:])) * 2
+                    loopLength := int(binary.BigEndian.Uint16(entry[26:])) * 2
+                    if loopLength > 2 {
+                        sample.LoopStart = loopStart
+                        sample.LoopLength = loopLength
+                    }
+
+                    file.Samples = append(file.Samples, sample)
+                    lengths = append(lengths, int(binary.BigEndian.Uint32(entry[20:])))
+                }
+            case "SPEE":
+                if len(data) >= 2 {
+                    file.InitialSpeed = max(1, int(binary.BigEndian.Uint16(data)))
+                }
+            case "SLEN":
+                // the number of patterns, which the PBOD chunks also tell us
+            case "PLEN":
+                if len(data) >= 2 {
+                    songLength = int(binary.BigEndian.Uint16(data))
+                }
+            case "PATT":
+                file.Orders = data
+            case "PBOD":
+                if file.Channels == 0 {
+                    return nil, fmt.Errorf("Pattern comes before the channel modes")
+                }
+
+                pattern, err := readPattern(data, file.Channels)
+                if err != nil {
+                    return nil, fmt.Errorf("Error reading pattern %v: %v", len(file.Patterns), err)
+                }
+                file.Patterns = append(file.Patterns, pattern)
+            case "SBOD":
+                for nextSample < len(file.Samples) && lengths[nextSample] == 0 {
+                    nextSample += 1
+                }
+
+                if nextSample >= len(file.Samples) {
+                    logger.Printf("Sample data without a sample")
+                    continue
+                }
+
+                sample := &file.Samples[nextSample]
+                sample.Data = make([]float32, len(data))
+                for i, value := range data {
+                    sample.Data[i] = float32(int8(value)) / 128
+                }
+
+                nextSample += 1
+            default:
+                logger.Printf("Unknown chunk %v", name)
+        }
+    }
+
+    if file.Channels == 0 {
+        return nil, fmt.Errorf("Song has no channel modes")
+    }
+
+    if len(file.Patterns) == 0 {
+        return nil, fmt.Errorf("Song has no patterns")
+    }
+
+    for i := range file.Samples {
+        sample := &file.Samples[i]
+        if sample.LoopStart + sample.LoopLength > len(sample.Data) {
+            sample.LoopStart = 0
+            sample.LoopLength = 0
+        }
+    }
+
+    songLength = min(songLength, len(file.Orders), MaxOrders)
+
+    for _, order := range file.Orders[:songLength] {
+        if int(order) >= len(file.Patterns) {
+            return nil, fmt.Errorf("Order refers to missing pattern %v", order)
+        }
+    }
+
+    file.Orders = file.Orders[:songLength]
+    if len(file.Orders) == 0 {
+        return nil, fmt.Errorf("Song has no orders")
+    }
+
+    logger.Printf("OKT channels %v split %v speed %v samples %v patterns %v orders %v", file.Channels, file.Split, file.InitialSpeed, len(file.Samples), len(file.Patterns), len(file.Orders))
+
+    return &file, nil
+}
@@ -0,0 +1,73 @@
+package okt
+
+import (
+    "testing"
+    "bytes"
+    "encoding/binary"
+    "io"
+    "log"
+)
+
+func makeChunk(name string, length uint32, data []byte) []byte {
+    chunk := []byte(name)
+    chunk = binary.BigEndian.AppendUint32(chunk, length)
+    return append(chunk, data...)
+}
+
+// a song with 4 channels, one pattern of one row and one sample of the given length,
+// followed by the given sample body
+func makeSong(sampleLength int, body []byte) []byte {
+    sample := make([]byte, 32)
+    copy(sample, "sample")
+    binary.BigEndian.PutUint32(sample[20:], uint32(sampleLength))
+    sample[29] = 64
+
+    pattern := make([]byte, 2 + 4 * 4)
+    binary.BigEndian.PutUint16(pattern, 1)
+
+    song := []byte("OKTASONG")
+    song = append(song, makeChunk("CMOD", 8, make([]byte, 8))...)
+    song = append(song, makeChunk("SAMP", 32, sample)...)
+    song = append(song, makeChunk("SPEE", 2, []byte{0, 6})...)
+    song = append(song, makeChunk("PLEN", 2, []byte{0, 1})...)
+    song = append(song, makeChunk("PATT", 1, []byte{0})...)
+    song = append(song, makeChunk("PBOD", uint32(len(pattern)), pattern)...)
+    return append(song, body...)
+}
+
+func TestLoadSampleBody(test *testing.T) {
+    logger := log.New(io.Discard, "", 0)
+
+    tests := []struct {
+        name string
+        data []byte
+        samples int
+        fails bool
+    }{
+        {name: "whole sample", data: makeSong(4, makeChunk("SBOD", 4, []byte{1, 2, 3, 4})), samples: 4},
+        // only the bytes in the file are kept, not the declared length
+        {name: "cut off sample", data: makeSong(4, makeChunk("SBOD", 4, []byte{1, 2})), samples: 2},
+        {name: "huge sample", data: makeSong(4, makeChunk("SBOD", 0xfffffff0, []byte{1, 2, 3})), samples: 3},
+        // only sample data may be cut off
+        {name: "cut off pattern", data: makeSong(4, makeChunk("PBOD", 0xfffffff0, []byte{0, 1})), fails: true},
+    }
+
+    for _, check := range tests {
+        file, err := Load(bytes.NewReader(check.data), logger)
+        if check.fails {
+            if err == nil {
+                test.Errorf("%v: expected an error", check.name)
+            }
+            continue
+        }
+
+        if err != nil {
+            test.Errorf("%v: %v", check.name, err)
+            continue
+        }
+
+        if len(file.Samples) != 1 || len(file.Samples[0].Data) != check.samples {
+            test.Errorf("%v: expected one sample of %v values", check.name, check.samples)
+        }
+    }
+}
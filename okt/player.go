@@ -0,0 +1,607 @@
+package okt
+
+import (
+    "io"
+    "log"
+    "math"
+    "runtime"
+
+    "github.com/kazzmir/tracker/common"
+)
+
+// oktalyzer plays one tick every vertical blank, which is the same as 125 bpm
+const BPM = 125
+
+// the clock that the amiga divides by the period to get the rate of a sample
+const amigaClock = 7159090.5 / 2
+
+type Channel struct {
+    Player *Player
+    AudioBuffer *common.AudioBuffer
+    ScopeBuffer *common.AudioBuffer
+    Channel int
+    Volume float32
+    buffer []float32 // used for reading audio data
+    Mute bool
+
+    Pan float32 // 0 is left and 1 is right
+
+    CurrentSample int // -1 for no sample
+    // the note that the effects are based on, 1-36
+    CurrentNote int
+    CurrentPeriod int
+    CurrentVolume int // 0-64
+
+    // effects only last for the row they are on
+    CurrentEffect int
+    EffectParameter int
+
+    // the period that is played for this tick, which the arpeggios change without changing the note
+    playPeriod int
+    // after a release the sample plays to its end instead of looping
+    Released bool
+
+    // ticks since the start of the row
+    rowTick int
+
+    currentRow int
+    startPosition float32
+}
+
+// the period of a note, keeping the note in the range that oktalyzer can play
+func notePeriod(note int) int {
+    return periods[max(1, min(note, len(periods))) - 1]
+}
+
+func (channel *Channel) setNote(note int) {
+    channel.CurrentNote = max(1, min(note, len(periods)))
+    channel.CurrentPeriod = notePeriod(channel.CurrentNote)
+    channel.playPeriod = channel.CurrentPeriod
+}
+
+func (channel *Channel) UpdateRow() {
+    channel.currentRow = channel.Player.CurrentRow
+    channel.rowTick = 0
+
+    note, ok := channel.Player.GetRowNote(channel.Channel, channel.currentRow)
+    if !ok {
+        return
+    }
+
+    if note.Note > 0 {
+        channel.CurrentSample = note.SampleNumber - 1
+        channel.startPosition = 0
+        channel.Released = false
+        channel.setNote(note.Note)
+
+        sample := channel.Player.GetSample(channel.CurrentSample)
+        if sample != nil {
+            channel.CurrentVolume = sample.Volume
+        }
+    }
+
+    channel.CurrentEffect = note.Effect
+    channel.EffectParameter = note.EffectParameter
+    channel.playPeriod = channel.CurrentPeriod
+
+    parameter := note.EffectParameter
+
+    switch note.Effect {
+        case EffectNone, EffectPortamentoDown, EffectPortamentoUp, EffectNoteSlideDown, EffectNoteSlideUp:
+        case EffectArpeggio1, EffectArpeggio2, EffectArpeggio3:
+            channel.doArpeggio()
+        case EffectNoteSlideDownOnce:
+            channel.setNote(channel.CurrentNote - parameter)
+        case EffectNoteSlideUpOnce:
+            channel.setNote(channel.CurrentNote + parameter)
+        case EffectFilter:
+            // the amiga filter is not emulated
+        case EffectPositionJump:
+            channel.Player.DoJump = true
+            channel.Player.JumpOrder = parameter
+        case EffectRelease:
+            channel.Released = true
+        case EffectSetSpeed:
+            if parameter & 0xf > 0 {
+                channel.Player.Speed = parameter & 0xf
+                if channel.Player.OnChangeSpeed != nil {
+                    channel.Player.OnChangeSpeed(channel.Player.Speed, channel.Player.BPM)
+                }
+            }
+        case EffectVolume:
+            switch {
+                case parameter <= 0x40:
+                    channel.CurrentVolume = parameter
+                case parameter > 0x60 && parameter <= 0x70:
+                    // fine slides happen once on the row
+                    channel.CurrentVolume = max(0, channel.CurrentVolume - (parameter - 0x60))
+                case parameter > 0x70 && parameter <= 0x80:
+                    channel.CurrentVolume = min(64, channel.CurrentVolume + (parameter - 0x70))
+            }
+        default:
+            log.Printf("Channel %v unknown effect %v with parameter %v", channel.Channel, note.Effect, parameter)
+    }
+}
+
+// play the arpeggio for the current tick of the row
+func (channel *Channel) doArpeggio() {
+    down := channel.EffectParameter >> 4
+    up := channel.EffectParameter & 0xf
+
+    note := channel.CurrentNote
+
+    switch channel.CurrentEffect {
+        case EffectArpeggio1:
+            // down, base, up
+            switch channel.rowTick % 3 {
+                case 0: note -= down
+                case 2: note += up
+            }
+        case EffectArpeggio2:
+            // base, up, base, down
+            switch channel.rowTick % 4 {
+                case 1: note += up
+                case 3: note -= down
+            }
+        case EffectArpeggio3:
+            // up, up, base
+            switch channel.rowTick % 3 {
+                case 0, 1: note += up
+            }
+    }
+
+    channel.playPeriod = notePeriod(note)
+}
+
+func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
+    if changeRow {
+        return
+    }
+
+    channel.rowTick += ticks
+
+    parameter := channel.EffectParameter
+
+    switch channel.CurrentEffect {
+        case EffectPortamentoDown:
+            channel.CurrentPeriod = max(periods[len(periods) - 1], channel.CurrentPeriod - parameter * ticks)
+            channel.playPeriod = channel.CurrentPeriod
+        case EffectPortamentoUp:
+            channel.CurrentPeriod = min(periods[0], channel.CurrentPeriod + parameter * ticks)
+            channel.playPeriod = channel.CurrentPeriod
+        case EffectArpeggio1, EffectArpeggio2, EffectArpeggio3:
+            channel.doArpeggio()
+        case EffectNoteSlideDown:
+            channel.setNote(channel.CurrentNote - parameter * ticks)
+        case EffectNoteSlideUp:
+            channel.setNote(channel.CurrentNote + parameter * ticks)
+        case EffectVolume:
+            switch {
+                case parameter > 0x40 && parameter <= 0x50:
+                    channel.CurrentVolume = max(0, channel.CurrentVolume - (parameter - 0x40) * ticks)
+                case parameter > 0x50 && parameter <= 0x60:
+                    channel.CurrentVolume = min(64, channel.CurrentVolume + (parameter - 0x50) * ticks)
+            }
+    }
+}
+
+func (channel *Channel) Update(rate float32) {
+    samples := int(float32(channel.Player.SampleRate) * rate)
+    samplesWritten := 0
+
+    channel.AudioBuffer.Lock()
+    channel.ScopeBuffer.Lock()
+
+    sample := channel.Player.GetSample(channel.CurrentSample)
+    if sample != nil && channel.playPeriod > 0 {
+        incrementRate := amigaClock / float32(channel.playPeriod) / float32(channel.Player.SampleRate)
+        volume := channel.Volume * float32(channel.CurrentVolume) / 64
+        leftPan, rightPan := channel.Player.PanLaw.Gains(channel.Pan)
+
+        loopEnd := sample.LoopStart + sample.LoopLength
+
+        for range samples {
+            position := int(channel.startPosition)
+            if sample.LoopLength > 0 && !channel.Released && position >= loopEnd {
+                channel.startPosition = float32(sample.LoopStart) + float32(math.Mod(float64(channel.startPosition) - float64(loopEnd), float64(sample.LoopLength)))
+                position = int(channel.startPosition)
+            }
+
+            if position >= len(sample.Data) {
+                break
+            }
+
+            value := sample.Data[position] * volume
+
+            channel.AudioBuffer.UnsafeWrite(value * leftPan)
+            channel.AudioBuffer.UnsafeWrite(value * rightPan)
+            channel.ScopeBuffer.UnsafeWrite(value * leftPan)
+            channel.ScopeBuffer.UnsafeWrite(value * rightPan)
+
+            channel.startPosition += incrementRate
+            samplesWritten += 1
+        }
+    }
+
+    for range (samples - samplesWritten) {
+        channel.AudioBuffer.UnsafeWrite(0.0)
+        channel.AudioBuffer.UnsafeWrite(0.0)
+        channel.ScopeBuffer.UnsafeWrite(0.0)
+        channel.ScopeBuffer.UnsafeWrite(0.0)
+    }
+
+    channel.AudioBuffer.Unlock()
+    channel.ScopeBuffer.Unlock()
+}
+
+func (channel *Channel) Read(data []byte) (int, error) {
+    if channel.Mute {
+        for i := 0; i < len(data); i++ {
+            data[i] = 0
+        }
+        channel.AudioBuffer.Clear()
+        return len(data), nil
+    }
+
+    samples := len(data) / 4
+
+    if samples > len(channel.buffer) {
+        samples = len(channel.buffer)
+    }
+
+    part := channel.buffer[:samples]
+    floatSamples := channel.AudioBuffer.Read(part)
+
+    i := 0
+    for sampleIndex := range floatSamples {
+        value := part[sampleIndex]
+        bits := math.Float32bits(value)
+        data[i*4+0] = byte(bits)
+        data[i*4+1] = byte(bits >> 8)
+        data[i*4+2] = byte(bits >> 16)
+        data[i*4+3] = byte(bits >> 24)
+
+        i += 1
+    }
+
+    i *= 4
+
+    // in a browser we have to return something, so we generate some silence
+    if i == 0 && runtime.GOOS == "js" {
+        for i < 8 {
+            data[i] = 0
+            i += 1
+        }
+        return 8, nil
+    } else {
+        // on a normal os we can just return 0 if necessary
+        return floatSamples * 4, nil
+    }
+}
+
+type Player struct {
+    File *OKTFile
+    Channels []*Channel
+    SampleRate int
+
+    Speed int
+    BPM int
+
+    CurrentRow int
+    CurrentOrder int
+    OrdersPlayed int
+    // finds the point where the song starts repeating
+    loops common.LoopDetector
+    // true once the song is repeating
+    SongLooped bool
+    ticks float32
+
+    // set by a position jump, which happens at the end of the row
+    DoJump bool
+    JumpOrder int
+
+    PanLaw common.PanLaw
+
+    OnChangeRow func(row int)
+    OnChangeOrder func(order int, pattern int)
+    OnChangeSpeed func(speed int, bpm int)
+}
+
+func MakePlayer(file *OKTFile, sampleRate int) *Player {
+    player := &Player{
+        File: file,
+        SampleRate: sampleRate,
+        Speed: file.InitialSpeed,
+        BPM: BPM,
+        loops: common.MakeLoopDetector(),
+    }
+
+    for i := range file.Channels {
+        // the amiga plays channels 0 and 3 on the left and 1 and 2 on the right, and both halves
+        // of a split channel play on the same side
+        pan := float32(0)
+        hardware := file.HardwareChannel(i)
+        if hardware == 1 || hardware == 2 {
+            pan = 1
+        }
+
+        player.Channels = append(player.Channels, &Channel{
+            Player: player,
+            Channel: i,
+            AudioBuffer: common.MakeAudioBuffer(sampleRate * 2),
+            ScopeBuffer: common.MakeAudioBuffer(sampleRate * 2 / 10),
+            Volume: 1.0,
+            Pan: pan,
+            CurrentSample: -1,
+            buffer: make([]float32, sampleRate),
+            currentRow: -1,
+        })
+    }
+
+    return player
+}
+
+func (player *Player) GetSample(index int) *Sample {
+    if index < 0 || index >= len(player.File.Samples) {
+        return nil
+    }
+
+    return &player.File.Samples[index]
+}
+
+func (player *Player) GetPattern() int {
+    return int(player.File.Orders[player.CurrentOrder])
+}
+
+func (player *Player) GetSongLength() int {
+    return len(player.File.Orders)
+}
+
+func (player *Player) GetRowNoteInfo(channel int, row int) (common.NoteInfo, bool) {
+    note, ok := player.GetRowNote(channel, row)
+    if !ok {
+        return nil, false
+    }
+    return note, true
+}
+
+func (player *Player) GetRowNote(channel int, row int) (*Note, bool) {
+    pattern := player.File.GetPattern(player.CurrentOrder)
+    if pattern == nil || row < 0 || row >= len(pattern.Rows) || channel < 0 || channel >= player.File.Channels {
+        return nil, false
+    }
+
+    return &pattern.Rows[row][channel], true
+}
+
+// move on to the first row of the given order as the song plays, starting over at the end
+// of the song
+func (player *Player) advanceOrder(order int) {
+    if order >= len(player.File.Orders) {
+        order = 0
+    }
+
+    if player.loops.Visit(order, 0) {
+        player.SongLooped = true
+    }
+
+    player.CurrentOrder = order
+    player.CurrentRow = 0
+    player.OrdersPlayed += 1
+
+    if player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+    }
+}
+
+func (player *Player) Update(timeDelta float32) {
+    oldTicks := int(player.ticks)
+
+    // true if a new row starts, even if a jump went back to the same row
+    rowChanged := false
+
+    if player.CurrentRow < 0 {
+        player.CurrentRow = 0
+        rowChanged = true
+    }
+
+    player.ticks += timeDelta * float32(player.BPM) * 2 / 5
+    newTicks := int(player.ticks)
+
+    if player.ticks >= float32(player.Speed) {
+        player.ticks -= float32(player.Speed)
+
+        if player.DoJump {
+            player.advanceOrder(player.JumpOrder)
+            player.DoJump = false
+        } else {
+            player.CurrentRow += 1
+            if player.CurrentRow >= len(player.File.GetPattern(player.CurrentOrder).Rows) {
+                player.advanceOrder(player.CurrentOrder + 1)
+            }
+        }
+
+        rowChanged = true
+
+        if player.OnChangeRow != nil {
+            player.OnChangeRow(player.CurrentRow)
+        }
+    }
+
+    for _, channel := range player.Channels {
+        changeRow := false
+        if rowChanged || player.CurrentRow != channel.currentRow {
+            channel.UpdateRow()
+            changeRow = true
+        }
+
+        if newTicks != oldTicks {
+            channel.UpdateTick(changeRow, newTicks - oldTicks)
+        }
+
+        channel.Update(timeDelta)
+    }
+}
+
+func (player *Player) SetOnChangeRow(callback func(row int)) {
+    player.OnChangeRow = callback
+}
+
+func (player *Player) SetOnChangeOrder(callback func(order int, pattern int)) {
+    player.OnChangeOrder = callback
+}
+
+func (player *Player) SetOnChangeSpeed(callback func(speed int, bpm int)) {
+    player.OnChangeSpeed = callback
+}
+
+func (player *Player) GetChannelReaders() []io.Reader {
+    readers := make([]io.Reader, len(player.Channels))
+    for i, channel := range player.Channels {
+        readers[i] = channel
+    }
+    return readers
+}
+
+func (player *Player) ToggleMuteChannel(channel int) bool {
+    if channel < 0 || channel >= len(player.Channels) {
+        return false
+    }
+
+    player.Channels[channel].Mute = !player.Channels[channel].Mute
+    return player.Channels[channel].Mute
+}
+
+func (player *Player) NextOrder() {
+    player.CurrentOrder += 1
+    if player.CurrentOrder >= len(player.File.Orders) {
+        player.CurrentOrder = 0
+    }
+    player.CurrentRow = 0
+
+    if player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+    }
+}
+
+func (player *Player) PreviousOrder() {
+    player.CurrentOrder -= 1
+    if player.CurrentOrder < 0 {
+        player.CurrentOrder = 0
+    }
+    player.CurrentRow = 0
+
+    if player.OnChangeOrder != nil {
+        player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+    }
+}
+
+func (player *Player) GetSpeed() int {
+    return player.Speed
+}
+
+func (player *Player) GetBPM() int {
+    return player.BPM
+}
+
+func (player *Player) GetChannelCount() int {
+    return len(player.Channels)
+}
+
+func (player *Player) GetName() string {
+    return player.File.Name
+}
+
+func (player *Player) IsStereo() bool {
+    return true
+}
+
+func (player *Player) GetChannelData(channel int, data []float32) int {
+    if channel < len(player.Channels) {
+        return player.Channels[channel].ScopeBuffer.Peek(data)
+    }
+
+    return 0
+}
+
+func (player *Player) ResetRow() {
+    player.CurrentRow = 0
+}
+
+func (player *Player) GetCurrentOrder() int {
+    return player.CurrentOrder
+}
+
+func (player *Player) RenderToPCM() io.Reader {
+    // make a buffer to hold 1/100th of a second of audio data, which is 4-bytes per sample
+    // and 1 samples per channel
+    rate := 100
+    buffer := make([]float32, player.SampleRate * 2 / rate)
+    mix := make([]float32, player.SampleRate * 2 / rate)
+
+    fillMix := func() bool {
+        if player.SongLooped {
+            return false
+        }
+
+        player.Update(1.0 / float32(rate))
+
+        for i := range mix {
+            mix[i] = 0
+        }
+
+        for _, channel := range player.Channels {
+            amount := channel.AudioBuffer.Read(buffer)
+
+            if amount > 0 {
+                // copy the samples into the mix buffer
+                for i := range amount {
+                    mix[i] = mix[i] + buffer[i]
+                }
+            }
+        }
+
+        for i := range mix {
+            mix[i] = max(min(mix[i], 1), -1)
+        }
+
+        return true
+    }
+
+    mixPosition := len(mix)
+    reader := func(data []byte) (int, error) {
+        if len(data) == 0 {
+            return 0, nil
+        }
+
+        if player.SongLooped {
+            return 0, io.EOF
+        }
+
+        // wait for the music to be produced
+        if mixPosition < len(mix) {
+            part := mix[mixPosition:]
+
+            amount := common.CopyFloat32(data, part)
+            mixPosition += amount
+            return amount * 4, nil
+        }
+
+        mixPosition = 0
+
+        more := fillMix()
+        if !more {
+            return 0, io.EOF
+        }
+
+        // copy the mix into the data buffer
+        amount := common.CopyFloat32(data, mix)
+        mixPosition += amount
+
+        return amount * 4, nil
+    }
+
+    return &common.ReaderFunc{
+        Func: reader,
+    }
+}
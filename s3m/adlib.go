@@ -0,0 +1,324 @@
+package s3m
+
+import (
+    "math"
+)
+
+// a software version of one voice of the yamaha opl2 chip that adlib cards used. the operators,
+// envelopes and modulation follow the chip, but this is not a cycle exact emulation
+
+// the rate the opl2 generates samples at, which the frequency numbers are relative to
+const oplClock = 49716.0
+
+// the attenuation of an operator in db at which it can no longer be heard
+const oplSilence = 96.0
+
+var oplMultipliers = []float64{0.5, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 10, 12, 12, 15, 15}
+
+// seconds to go from silence to full volume for attack rates 0-15. rate 0 never moves
+var oplAttackTimes = []float64{0, 2.82624, 1.41312, 0.70656, 0.35328, 0.17664, 0.08832, 0.04416, 0.02208, 0.01104, 0.00552, 0.00276, 0.00138, 0.00069, 0.00034, 0}
+
+// seconds to fall through the whole 96db range for decay and release rates 0-15
+var oplDecayTimes = []float64{0, 39.28064, 19.64032, 9.82016, 4.91008, 2.45504, 1.22752, 0.61376, 0.30688, 0.15344, 0.07672, 0.03836, 0.01918, 0.00959, 0.00479, 0.00240}
+
+// the key scale attenuation in db for block 7, indexed by the top 4 bits of the frequency number.
+// each lower block is 3db quieter
+var oplKeyScaleLevels = []float64{0, 9, 12, 13.875, 15, 16.125, 16.875, 17.625, 18, 18.75, 19.125, 19.5, 19.875, 20.25, 20.625, 21}
+
+// the key scale level setting as a multiple of 3db per octave
+var oplKeyScaleFactors = []float64{0, 1, 0.5, 2}
+
+// the opl2 waveforms, where phase is in cycles
+func oplWave(wave byte, phase float64) float64 {
+    phase -= math.Floor(phase)
+    value := math.Sin(phase * 2 * math.Pi)
+
+    switch wave & 3 {
+        // half sine
+        case 1:
+            if phase >= 0.5 {
+                return 0
+            }
+        // absolute sine
+        case 2:
+            return math.Abs(value)
+        // the rising quarter of each half of the absolute sine
+        case 3:
+            if math.Mod(phase, 0.5) >= 0.25 {
+                return 0
+            }
+            return math.Abs(value)
+    }
+
+    return value
+}
+
+// split a frequency in hz into the block (octave) and 10-bit frequency number the chip uses
+func oplFrequencyNumber(frequency float64) (int, int) {
+    for block := range 8 {
+        fnum := int(frequency * float64(int(1) << (20 - block)) / oplClock)
+        if fnum < 1024 {
+            return block, fnum
+        }
+    }
+
+    return 7, 1023
+}
+
+// the time of an envelope phase once the key scale rate has sped it up
+func oplRateTime(times []float64, rate int, offset int) float64 {
+    if rate == 0 {
+        return math.Inf(1)
+    }
+
+    effective := min(60, rate * 4 + offset)
+    return times[rate] / math.Pow(2, float64(effective - rate * 4) / 4)
+}
+
+type oplEnvelopeStage int
+
+const (
+    oplEnvelopeOff oplEnvelopeStage = iota
+    oplEnvelopeAttack
+    oplEnvelopeDecay
+    oplEnvelopeSustain
+    oplEnvelopeRelease
+)
+
+type adlibOperator struct {
+    settings *AdlibOperator
+    stage oplEnvelopeStage
+    level float64 // envelope attenuation in db
+    phase float64 // position in the waveform in cycles
+}
+
+func (operator *adlibOperator) keyOn(settings *AdlibOperator) {
+    // the envelope attacks from wherever it currently is
+    if operator.stage == oplEnvelopeOff {
+        operator.level = oplSilence
+    }
+
+    operator.settings = settings
+    operator.stage = oplEnvelopeAttack
+    operator.phase = 0
+}
+
+func (operator *adlibOperator) keyOff() {
+    if operator.stage != oplEnvelopeOff {
+        operator.stage = oplEnvelopeRelease
+    }
+}
+
+func (operator *adlibOperator) release(dt float64, offset int) {
+    operator.level += dt * oplSilence / oplRateTime(oplDecayTimes, int(operator.settings.SustainRelease & 0xf), offset)
+}
+
+func (operator *adlibOperator) updateEnvelope(dt float64, block int, fnum int) {
+    settings := operator.settings
+
+    // higher notes have faster envelopes
+    offset := block >> 1
+    if settings.Characteristic & 0x10 != 0 {
+        offset = block * 2 + (fnum >> 9)
+    }
+
+    switch operator.stage {
+        case oplEnvelopeOff:
+            return
+        case oplEnvelopeAttack:
+            rate := int(settings.AttackDecay >> 4)
+            if rate == 15 {
+                operator.level = 0
+            } else {
+                // the attack is exponential, it approaches full volume quickly and then slows down
+                time := oplRateTime(oplAttackTimes, rate, offset)
+                operator.level -= dt * (operator.level + 2) * math.Log(49) / time
+            }
+
+            if operator.level <= 0 {
+                operator.level = 0
+                operator.stage = oplEnvelopeDecay
+            }
+        case oplEnvelopeDecay:
+            sustain := float64(settings.SustainRelease >> 4) * 3
+            if settings.SustainRelease >> 4 == 15 {
+                sustain = 93
+            }
+
+            operator.level += dt * oplSilence / oplRateTime(oplDecayTimes, int(settings.AttackDecay & 0xf), offset)
+            if operator.level >= sustain {
+                operator.level = sustain
+                operator.stage = oplEnvelopeSustain
+            }
+        case oplEnvelopeSustain:
+            // without the sustain bit the note keeps fading at the release rate
+            if settings.Characteristic & 0x20 == 0 {
+                operator.release(dt, offset)
+            }
+        case oplEnvelopeRelease:
+            operator.release(dt, offset)
+    }
+
+    if operator.level >= oplSilence {
+        operator.level = oplSilence
+        if operator.stage != oplEnvelopeAttack {
+            operator.stage = oplEnvelopeOff
+        }
+    }
+}
+
+// the linear gain of the operator given the extra attenuation in db on top of the envelope
+func (operator *adlibOperator) gain(attenuation float64) float64 {
+    if operator.stage == oplEnvelopeOff {
+        return 0
+    }
+
+    total := operator.level + attenuation
+    if total >= oplSilence {
+        return 0
+    }
+
+    return math.Pow(10, -total / 20)
+}
+
+// the total level and key scale attenuation of the operator, with the channel volume 0-64 applied
+// to the total level the way scream tracker does
+func (operator *adlibOperator) attenuation(volume int, block int, fnum int) float64 {
+    settings := operator.settings
+
+    totalLevel := int(settings.ScaleLevel & 0x3f)
+    if volume >= 0 {
+        totalLevel = 63 - (63 - totalLevel) * volume / 64
+    }
+
+    keyScale := max(0, oplKeyScaleLevels[fnum >> 6] - 3 * float64(7 - block)) * oplKeyScaleFactors[settings.ScaleLevel >> 6]
+
+    return float64(totalLevel) * 0.75 + keyScale
+}
+
+type adlibVoice struct {
+    // the opl channel, 0-8 are melodic and 9-13 are the rhythm channels
+    Index int
+
+    instrument *Instrument
+    // modulator and carrier
+    operators [2]adlibOperator
+    // the last two modulator outputs, which feed back into the modulator
+    feedback [2]float64
+    // seconds of playback, which drives the chip's tremolo and vibrato
+    lfoTime float64
+    noise uint32
+}
+
+func makeAdlibVoice(index int) *adlibVoice {
+    return &adlibVoice{
+        Index: index,
+        noise: 1,
+    }
+}
+
+func (voice *adlibVoice) KeyOn(instrument *Instrument) {
+    voice.instrument = instrument
+    voice.operators[0].keyOn(&instrument.Adlib.Modulator)
+    voice.operators[1].keyOn(&instrument.Adlib.Carrier)
+    voice.feedback = [2]float64{}
+}
+
+func (voice *adlibVoice) KeyOff() {
+    voice.operators[0].keyOff()
+    voice.operators[1].keyOff()
+}
+
+func (voice *adlibVoice) IsPlaying() bool {
+    return voice.instrument != nil && (voice.operators[0].stage != oplEnvelopeOff || voice.operators[1].stage != oplEnvelopeOff)
+}
+
+// the next noise bit of the rhythm section, as -1 or 1
+func (voice *adlibVoice) nextNoise() float64 {
+    // the same 23-bit lfsr as the chip
+    if voice.noise & 1 != 0 {
+        voice.noise ^= 0x800302
+    }
+    voice.noise >>= 1
+
+    if voice.noise & 1 != 0 {
+        return 1
+    }
+    return -1
+}
+
+// produce the next output sample of the voice. the frequency is in hz and the volume is 0-64
+func (voice *adlibVoice) Generate(frequency float64, volume int, sampleRate int) float32 {
+    if !voice.IsPlaying() {
+        return 0
+    }
+
+    dt := 1 / float64(sampleRate)
+    voice.lfoTime += dt
+
+    block, fnum := oplFrequencyNumber(frequency)
+
+    // the chip's vibrato is 7 cents at 6.1hz and the tremolo is 1db at 3.7hz
+    vibrato := math.Pow(2, 7.0 / 1200 * math.Sin(2 * math.Pi * 6.07 * voice.lfoTime))
+    tremolo := (1 - math.Cos(2 * math.Pi * 3.7 * voice.lfoTime)) / 2
+
+    adlib := &voice.instrument.Adlib
+    additive := adlib.Connection & 1 != 0
+
+    var gains [2]float64
+    for i := range voice.operators {
+        operator := &voice.operators[i]
+        settings := operator.settings
+
+        step := frequency * oplMultipliers[settings.Characteristic & 0xf] * dt
+        if settings.Characteristic & 0x40 != 0 {
+            step *= vibrato
+        }
+        operator.phase += step
+        operator.phase -= math.Floor(operator.phase)
+
+        operator.updateEnvelope(dt, block, fnum)
+
+        // the volume only changes the carrier, unless the modulator is also heard directly
+        operatorVolume := -1
+        if i == 1 || additive {
+            operatorVolume = volume
+        }
+
+        attenuation := operator.attenuation(operatorVolume, block, fnum)
+        if settings.Characteristic & 0x80 != 0 {
+            attenuation += tremolo
+        }
+
+        gains[i] = operator.gain(attenuation)
+    }
+
+    modulator := &voice.operators[0]
+    carrier := &voice.operators[1]
+
+    switch voice.instrument.Type {
+        case InstrumentAdlibSnare, InstrumentAdlibCymbal, InstrumentAdlibHihat:
+            // the single operator drums are mostly noise on top of the carrier
+            wave := oplWave(carrier.settings.Wave, carrier.phase)
+            return float32((wave + voice.nextNoise()) / 2 * gains[1])
+        case InstrumentAdlibTom:
+            return float32(oplWave(carrier.settings.Wave, carrier.phase) * gains[1])
+    }
+
+    // the feedback amount 1-7 adds a fraction of the modulator's own output to its phase
+    var feedback float64
+    if level := (adlib.Connection >> 1) & 7; level > 0 {
+        feedback = (voice.feedback[0] + voice.feedback[1]) * 4 / float64(int(1) << (9 - level))
+    }
+
+    modulatorOut := oplWave(modulator.settings.Wave, modulator.phase + feedback) * gains[0]
+    voice.feedback[0] = voice.feedback[1]
+    voice.feedback[1] = modulatorOut
+
+    if additive {
+        return float32(modulatorOut + oplWave(carrier.settings.Wave, carrier.phase) * gains[1])
+    }
+
+    // a full scale modulator moves the carrier by up to 4 cycles
+    return float32(oplWave(carrier.settings.Wave, carrier.phase + modulatorOut * 4) * gains[1])
+}
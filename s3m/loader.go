@@ -33,10 +33,40 @@ const (
 
     // not a defined effect in the s3m standard, but we use it for the extra delayed note effect
     EffectNoteDelay = 5000
+    // same for the SCx note cut
+    EffectNoteCut = 5001
 )
 
+const (
+    InstrumentEmpty uint8 = 0
+    InstrumentSample = 1
+    InstrumentAdlibMelody = 2
+    InstrumentAdlibBassDrum = 3
+    InstrumentAdlibSnare = 4
+    InstrumentAdlibTom = 5
+    InstrumentAdlibCymbal = 6
+    InstrumentAdlibHihat = 7
+)
+
+// the opl2 registers of one operator
+type AdlibOperator struct {
+    Characteristic uint8 // tremolo, vibrato, sustain, key scale rate and the frequency multiplier
+    ScaleLevel uint8 // key scale level and total level
+    AttackDecay uint8
+    SustainRelease uint8
+    Wave uint8
+}
+
+type AdlibInstrument struct {
+    Modulator AdlibOperator
+    Carrier AdlibOperator
+    Connection uint8 // feedback in bits 1-3, additive synthesis in bit 0
+}
+
 type Instrument struct {
     Name string
+    Type uint8
+    Adlib AdlibInstrument
     MiddleC uint16
     SampleFormat uint16
     Flags uint8
@@ -47,6 +77,10 @@ type Instrument struct {
     Data []float32
 }
 
+func (instrument *Instrument) IsAdlib() bool {
+    return instrument.Type >= InstrumentAdlibMelody && instrument.Type <= InstrumentAdlibHihat
+}
+
 type Note struct {
     SampleNumber int
     ChangeSample bool
@@ -97,6 +131,13 @@ func (note *Note) GetEffectName() string {
     return "..."
 }
 
+func (note *Note) GetVolumeName() string {
+    if note.ChangeVolume {
+        return fmt.Sprintf("%02d", note.Volume)
+    }
+    return ".."
+}
+
 func (note *Note) GetSampleName() string {
     if note.SampleNumber > 0 {
         return fmt.Sprintf("%02d", note.SampleNumber)
@@ -118,9 +159,95 @@ type S3MFile struct {
     InitialTempo uint8
     ChannelMap map[int]int // maps channel number to channel index
     ChannelPanning map[int]byte
+    // maps channel number to the opl channel for adlib channels, 0-8 are melodic and 9-13 are drums
+    AdlibChannels map[int]int
+    Stereo bool
+    // slides are limited to the protracker note range
+    AmigaLimits bool
+    // volume slides also happen on the first tick of a row, like scream tracker 3.00
+    FastVolumeSlides bool
+    // the initial tempo and Axx hold the speed in the high nibble and a tick rate factor in the
+    // low nibble, like scream tracker 2
+    CombinedTempo bool
     GlobalVolume uint8
 }
 
+// read the rest of an adlib instrument, after the type and the dos filename
+func readAdlibInstrument(reader *bufio.Reader) (Instrument, error) {
+    // 3 unused bytes
+    _, err := reader.Discard(3)
+    if err != nil {
+        return Instrument{}, err
+    }
+
+    var registers [12]byte
+    _, err = io.ReadFull(reader, registers[:])
+    if err != nil {
+        return Instrument{}, fmt.Errorf("Error reading adlib registers: %v", err)
+    }
+
+    volume, err := reader.ReadByte()
+    if err != nil {
+        return Instrument{}, err
+    }
+
+    // disk number and 2 unused bytes
+    _, err = reader.Discard(3)
+    if err != nil {
+        return Instrument{}, err
+    }
+
+    var middleC uint16
+    err = binary.Read(reader, binary.LittleEndian, &middleC)
+    if err != nil {
+        return Instrument{}, err
+    }
+
+    // the high word of the c2 speed and 12 unused bytes
+    _, err = reader.Discard(14)
+    if err != nil {
+        return Instrument{}, err
+    }
+
+    var name [28]byte
+    _, err = io.ReadFull(reader, name[:])
+    if err != nil {
+        return Instrument{}, err
+    }
+
+    var signature [4]byte
+    _, err = io.ReadFull(reader, signature[:])
+    if err != nil {
+        return Instrument{}, err
+    }
+
+    if !bytes.Equal(signature[:], []byte("SCRI")) {
+        return Instrument{}, fmt.Errorf("Unexpected adlib instrument signature: %v", signature)
+    }
+
+    // the registers alternate between the modulator and the carrier
+    operator := func(offset int) AdlibOperator {
+        return AdlibOperator{
+            Characteristic: registers[offset],
+            ScaleLevel: registers[offset + 2],
+            AttackDecay: registers[offset + 4],
+            SustainRelease: registers[offset + 6],
+            Wave: registers[offset + 8],
+        }
+    }
+
+    return Instrument{
+        Name: string(bytes.TrimRight(name[:], "\x00")),
+        MiddleC: middleC,
+        Volume: volume,
+        Adlib: AdlibInstrument{
+            Modulator: operator(0),
+            Carrier: operator(1),
+            Connection: registers[10],
+        },
+    }, nil
+}
+
 func Load(reader_ io.ReadSeeker, logger *log.Logger) (*S3MFile, error) {
     reader := bufio.NewReader(reader_)
 
@@ -263,26 +390,33 @@ func Load(reader_ io.ReadSeeker, logger *log.Logger) (*S3MFile, error) {
 
     channelMap := make(map[int]int)
     channelPanning := make(map[int]byte)
+    adlibChannels := make(map[int]int)
 
     channelCount := 0
     for i, setting := range channelSettings {
         logger.Printf("Channel %v setting: %v", i, setting < 16)
-        if setting < 16 {
+        // 16-24 are the adlib melody channels and 25-29 are the adlib drums
+        if setting >= 16 && setting < 30 {
             channelMap[i] = channelCount
-
             channelCount += 1
+            adlibChannels[i] = int(setting) - 16
 
-            logger.Printf("Channel %v default panning %v", i, setting)
+            logger.Printf("Channel %v is adlib channel %v", i, setting - 16)
+        }
 
-            channelPanning[i] = setting
+        if setting < 16 {
+            channelMap[i] = channelCount
 
-            /*
+            channelCount += 1
+
+            // channels 0-7 are on the left and 8-15 are on the right
             if setting <= 7 {
-                // pan left
+                channelPanning[i] = 0x3
             } else {
-                // pan right
+                channelPanning[i] = 0xc
             }
-            */
+
+            logger.Printf("Channel %v default panning %v", i, channelPanning[i])
         }
     }
 
@@ -341,24 +475,32 @@ func Load(reader_ io.ReadSeeker, logger *log.Logger) (*S3MFile, error) {
 
     if defaultPanning == 0xfc {
         var data [32]byte
-        for range channelCount {
-            _, err := io.ReadFull(reader, data[:])
-            if err != nil {
-                return nil, err
-            }
+        _, err := io.ReadFull(reader, data[:])
+        if err != nil {
+            return nil, fmt.Errorf("Error reading default panning: %v", err)
         }
-        for i := range data {
-            // only use lower 4 bits
-            data[i] = data[i] & 0xf
-            logger.Printf("Panning for channel %v: %v", i, data[i])
+
+        for i, pan := range data {
+            // the pan is only used if bit 5 is set, otherwise the channel keeps its L/R position
+            _, ok := channelMap[i]
+            if ok && pan & 0x20 != 0 {
+                channelPanning[i] = pan & 0xf
+                logger.Printf("Panning for channel %v: %v", i, channelPanning[i])
+            }
         }
-        // FIXME: do something with panning data
     }
 
-    mono := (masterVolume & 128 == 0) || (masterVolume >> 7 == 0)
+    // the top bit of the master volume is set for stereo songs
+    mono := masterVolume & 128 == 0
 
     logger.Printf("Mono: %v", mono)
 
+    if mono {
+        for i := range channelPanning {
+            channelPanning[i] = 8
+        }
+    }
+
     var instruments []Instrument
 
     for _, offset := range instrumentOffsets {
@@ -382,7 +524,7 @@ func Load(reader_ io.ReadSeeker, logger *log.Logger) (*S3MFile, error) {
         }
 
         // 1 is digital sample
-        if type_ == 1 {
+        if type_ == InstrumentSample {
             // read a 3 byte unsigned value
             var high uint8
             var low uint16
@@ -502,6 +644,7 @@ func Load(reader_ io.ReadSeeker, logger *log.Logger) (*S3MFile, error) {
 
             instruments = append(instruments, Instrument{
                 Name: string(sampleNameTrim),
+                Type: InstrumentSample,
                 MiddleC: middleC,
                 Volume: sampleVolume,
                 Flags: flags,
@@ -512,6 +655,14 @@ func Load(reader_ io.ReadSeeker, logger *log.Logger) (*S3MFile, error) {
             })
 
             // log.Printf("Instrument %v loop begin %v end %v", i, loopBegin, loopEnd)
+        } else if type_ >= InstrumentAdlibMelody && type_ <= InstrumentAdlibHihat {
+            instrument, err := readAdlibInstrument(buffer)
+            if err != nil {
+                return nil, err
+            }
+
+            instrument.Type = type_
+            instruments = append(instruments, instrument)
         } else {
             instruments = append(instruments, Instrument{
             })
@@ -652,6 +803,10 @@ func Load(reader_ io.ReadSeeker, logger *log.Logger) (*S3MFile, error) {
         Orders: orders,
         ChannelMap: channelMap,
         ChannelPanning: channelPanning,
+        AdlibChannels: adlibChannels,
+        Stereo: !mono,
+        AmigaLimits: flags & 16 != 0,
+        FastVolumeSlides: flags & 64 != 0 || trackerVersion == 0x1300,
         SongLength: len(orders),
         InitialSpeed: initialSpeed,
         InitialTempo: initialTempo,
@@ -24,48 +24,88 @@ var Octaves []int = []int{
     107,  101,   95,   90,   85,   80,   75,   71,   67,   63,   60,   56, 0, 0, 0, 0,
 }
 
-type Vibrato struct {
-    Speed int
-    Depth int
-    position int
+// the middle c speeds that S2x selects
+var FineTunes []int = []int{
+    8363, 8413, 8463, 8529, 8581, 8651, 8723, 8757,
+    7895, 7941, 7985, 8046, 8107, 8169, 8232, 8280,
 }
 
-func (vibrato *Vibrato) Update() {
-    vibrato.position += vibrato.Speed
-    if vibrato.position >= 64 {
-        vibrato.position -= 64
+// the tick rate factors of scream tracker 2 for each speed, scaled by the low nibble of the tempo
+var combinedTempoFactors []int = []int{140, 50, 25, 15, 10, 7, 6, 4, 3, 3, 2, 2, 2, 2, 1, 1}
+
+// the ticks per second of a scream tracker 2 tempo byte. scream tracker 2 counts the length of a
+// tick in samples at its highest mixing rate, which wraps around for the slowest settings
+func combinedTickRate(tempo int) float32 {
+    mixingRate := 23863
+    samplesPerTick := mixingRate / (49 - (combinedTempoFactors[tempo >> 4] * (tempo & 0xf)) >> 4)
+    if samplesPerTick <= 0 {
+        samplesPerTick += 65536
     }
+
+    return float32(mixingRate) / float32(samplesPerTick)
 }
 
-func (vibrato *Vibrato) Apply(frequency int) int {
-    if vibrato.Depth <= 0 || vibrato.Speed <= 0 {
-        return frequency
+// the period of the semitone closest to the given period
+func roundPeriod(period int) int {
+    best := period
+    distance := -1
+    for _, value := range Octaves {
+        if value == 0 {
+            continue
+        }
+
+        if distance == -1 || abs(value - period) < distance {
+            best = value
+            distance = abs(value - period)
+        }
     }
 
-    // Amiga vibrato is a sine wave with a period of 64
-    // and a depth of 8, so we scale the position to that range
-    vibratoValue := int(float64(vibrato.Depth * 6) * math.Sin(float64(vibrato.position) * math.Pi * 360 / 64 / 180))
-    return frequency + vibratoValue
+    return best
 }
 
-type Tremolo struct {
-    Speed int
-    Depth int
-    position int
+func abs(value int) int {
+    if value < 0 {
+        return -value
+    }
+    return value
 }
 
-func (tremolo *Tremolo) Update() {
-    tremolo.position += tremolo.Speed
-    if tremolo.position >= 64 {
-        tremolo.position -= 64
+type Vibrato struct {
+    common.Oscillator
+    // Uxy fine vibrato is 4 times smaller than Hxy
+    Fine bool
+}
+
+func (vibrato *Vibrato) Apply(period int) int {
+    if vibrato.Depth <= 0 || vibrato.Speed <= 0 {
+        return period
     }
+
+    // the sine wave moves the period by up to 8 * depth, or 2 * depth for fine vibrato
+    scale := 8
+    if vibrato.Fine {
+        scale = 2
+    }
+
+    vibratoValue := int(float64(vibrato.Depth * scale) * vibrato.Value())
+    return period + vibratoValue
+}
+
+type Tremolo struct {
+    common.Oscillator
 }
 
 func (tremolo *Tremolo) Apply(volume float32) float32 {
-    volumeValue := float64(tremolo.Depth) / 16 * math.Sin(float64(tremolo.position) * math.Pi * 360 / 64 / 180)
+    volumeValue := float64(tremolo.Depth) / 16 * tremolo.Value()
     return volume + float32(volumeValue)
 }
 
+// change a volume from 0-64 by the tremolo, which moves up to 4 * depth
+func (tremolo *Tremolo) ApplyVolume(volume int) int {
+    delta := int(float64(tremolo.Depth * 4) * tremolo.Value())
+    return max(0, min(64, volume + delta))
+}
+
 type Channel struct {
     Player *Player
     AudioBuffer *common.AudioBuffer
@@ -85,6 +125,8 @@ type Channel struct {
     Vibrato Vibrato
     NoteDelay int
     UpdateDelay func()
+    // ticks until the SCx note cut
+    NoteCut int
 
     Tremolo Tremolo
 
@@ -95,8 +137,29 @@ type Channel struct {
     PortamentoToNote uint8
     PortamentoNote int
 
+    // S1x, tone portamento moves in semitones
+    Glissando bool
+    // the middle c speed of the playing note, which S2x can override
+    MiddleC int
+
+    // the last parameter of the effects that share memory
+    EffectMemory uint8
+
+    // Ixy, the number of ticks the note is on and then off
+    TremorOn int
+    TremorOff int
+    TremorCount int
+
+    // ticks since the start of the row
+    rowTick int
+
     currentRow int
     startPosition float32
+
+    // the fm voice for adlib channels, nil for sample channels
+    adlib *adlibVoice
+    // start the adlib voice on the next update
+    adlibKeyOn bool
 }
 
 func (channel *Channel) GetLeftPan() float32 {
@@ -113,6 +176,7 @@ func (channel *Channel) GetRightPan() float32 {
 
 func (channel *Channel) UpdateRow() {
     channel.currentRow = channel.Player.CurrentRow
+    channel.rowTick = 0
 
     note, _ := channel.Player.GetRowNote(channel.Channel, channel.currentRow)
 
@@ -134,26 +198,60 @@ func (channel *Channel) UpdateRow() {
     if note.ChangeEffect {
         channel.CurrentEffect = int(note.EffectNumber)
         channel.EffectParameter = int(note.EffectParameter)
+
+        // most effects share one memory, a parameter of 0 reuses the last non-zero parameter
+        // of any of them
+        if sharesEffectMemory(channel.CurrentEffect) {
+            if channel.EffectParameter == 0 {
+                channel.EffectParameter = int(channel.EffectMemory)
+            } else {
+                channel.EffectMemory = uint8(channel.EffectParameter)
+            }
+        }
+    }
+
+    // a note without an instrument keeps playing the previous one
+    if note.ChangeSample && note.SampleNumber > 0 {
+        newSample = note.SampleNumber - 1
     }
 
     if note.ChangeNote {
-        if note.Note == 255 || note.Note == 254 {
+        if note.Note == 254 && channel.adlib != nil {
+            // on an adlib channel the note off releases the voice rather than cutting it
+            channel.adlib.KeyOff()
+        } else if note.Note == 255 || note.Note == 254 {
             // log.Printf("channel %v note %v", channel.Channel, note.Note)
             newSample = -1
         } else {
-            newPeriod = Octaves[note.Note]
+            // S2x changes the speed of a note on the same row
+            channel.MiddleC = 0
+            if channel.CurrentEffect == EffectSetExtra && channel.EffectParameter >> 4 == 0x2 {
+                channel.MiddleC = FineTunes[channel.EffectParameter & 0xf]
+            } else if instrument := channel.Player.GetInstrument(newSample); instrument != nil {
+                channel.MiddleC = int(instrument.MiddleC)
+            }
+
+            newPeriod = channel.notePeriod(note.Note)
             newStartPosition = 0.0
+            if channel.CurrentEffect != EffectPortamentoToNote && channel.CurrentEffect != EffectPortamentoAndVolumeSlide {
+                channel.Vibrato.Retrigger()
+                channel.Tremolo.Retrigger()
+                channel.adlibKeyOn = true
+            }
         }
     }
 
-    if note.ChangeSample {
-        newSample = note.SampleNumber - 1
-    }
-
     switch channel.CurrentEffect {
         case EffectNone:
         case EffectSetSpeed:
-            channel.Player.Speed = channel.EffectParameter
+            if channel.Player.S3M.CombinedTempo {
+                // a speed of 0 is ignored
+                if channel.EffectParameter >> 4 > 0 {
+                    channel.Player.setCombinedTempo(channel.EffectParameter)
+                }
+            } else {
+                channel.Player.Speed = channel.EffectParameter
+            }
             if channel.Player.OnChangeSpeed != nil {
                 channel.Player.OnChangeSpeed(channel.Player.Speed, channel.Player.BPM)
             }
@@ -171,70 +269,87 @@ func (channel *Channel) UpdateRow() {
             if channel.Player.JumpOrder >= len(channel.Player.S3M.Orders) {
                 channel.Player.JumpOrder = 0
             }
-        case EffectPortamentoToNote:
-            channel.CurrentEffect = EffectPortamentoToNote
-            if note.EffectParameter > 0 {
-                channel.PortamentoToNote = note.EffectParameter
+        case EffectPortamentoToNote, EffectPortamentoAndVolumeSlide:
+            // tone portamento has its own memory
+            if channel.CurrentEffect == EffectPortamentoToNote && channel.EffectParameter > 0 {
+                channel.PortamentoToNote = uint8(channel.EffectParameter)
             }
 
-            if note.ChangeNote {
-                channel.PortamentoNote = Octaves[note.Note]
+            if channel.CurrentEffect == EffectPortamentoAndVolumeSlide {
+                channel.VolumeSlide = uint8(channel.EffectParameter)
             }
 
-            newPeriod = channel.CurrentPeriod
+            // slide to the new note rather than playing it
+            if note.ChangeNote && channel.CurrentPeriod > 0 && newPeriod != channel.CurrentPeriod {
+                channel.PortamentoNote = newPeriod
+                newPeriod = channel.CurrentPeriod
+                newStartPosition = channel.startPosition
+            }
         case EffectPatternBreak:
             channel.Player.DoBreak = true
             channel.Player.BreakRow = channel.EffectParameter & 0x7f
         case EffectSampleOffset:
-            channel.startPosition = float32(channel.EffectParameter) * 0x100
+            newStartPosition = float32(channel.EffectParameter) * 0x100
         case EffectRetriggerAndVolumeSlide:
-            channel.CurrentEffect = EffectRetriggerAndVolumeSlide
-            channel.VolumeSlide = note.EffectParameter >> 4
-            channel.Retrigger = int(note.EffectParameter & 0xf)
-        case EffectPortamentoAndVolumeSlide:
-            channel.CurrentEffect = EffectPortamentoAndVolumeSlide
-            if channel.EffectParameter > 0 {
-                channel.VolumeSlide = note.EffectParameter >> 4
-            }
+            channel.Retrigger = channel.EffectParameter & 0xf
         case EffectPortamentoDown, EffectPortamentoUp:
-            // channel.CurrentEffect = EffectPortamentoDown
-
-            if note.EffectParameter > 0 {
-                channel.PortamentoNote = int(note.EffectParameter)
+            amount := channel.EffectParameter
+            direction := 1
+            if channel.CurrentEffect == EffectPortamentoUp {
+                direction = -1
             }
 
-            if channel.PortamentoNote >> 4 == 0xf {
-                newPeriod += int(channel.PortamentoNote & 0xf) * 4
-                channel.CurrentEffect = EffectNone
-            } else if channel.PortamentoNote >> 4 == 0xe {
-                newPeriod += int(channel.PortamentoNote & 0xf)
+            // Fx is a fine slide and Ex is an extra fine slide, both only happen once on the first tick
+            switch amount >> 4 {
+                case 0xf:
+                    newPeriod = channel.limitPeriod(newPeriod + direction * (amount & 0xf) * 4)
+                    channel.CurrentEffect = EffectNone
+                case 0xe:
+                    newPeriod = channel.limitPeriod(newPeriod + direction * (amount & 0xf))
+                    channel.CurrentEffect = EffectNone
             }
-
-        case EffectVibrato:
-            if note.EffectParameter > 0 {
-                channel.Vibrato.Speed = int(note.EffectParameter >> 4)
-                channel.Vibrato.Depth = int(note.EffectParameter & 0xf)
+        case EffectVibrato, EffectFineVibrato:
+            // each half of the parameter keeps its old value if it is 0
+            if channel.EffectParameter >> 4 > 0 {
+                channel.Vibrato.Speed = channel.EffectParameter >> 4
             }
-            channel.CurrentEffect = EffectVibrato
-        case EffectVibratoAndVolumeSlide:
-            if note.EffectParameter > 0 {
-                channel.VolumeSlide = note.EffectParameter
+            if channel.EffectParameter & 0xf > 0 {
+                channel.Vibrato.Depth = channel.EffectParameter & 0xf
             }
-
-            channel.CurrentEffect = EffectVibratoAndVolumeSlide
+            channel.Vibrato.Fine = channel.CurrentEffect == EffectFineVibrato
+        case EffectVibratoAndVolumeSlide:
+            channel.VolumeSlide = uint8(channel.EffectParameter)
+        case EffectTremor:
+            channel.TremorOn = channel.EffectParameter >> 4 + 1
+            channel.TremorOff = channel.EffectParameter & 0xf + 1
+        case EffectArpeggio:
         case EffectTremolo:
-            if note.EffectParameter > 0 {
-                channel.Tremolo.Speed = int(note.EffectParameter >> 4)
-                channel.Tremolo.Depth = int(note.EffectParameter & 0xf)
+            if channel.EffectParameter >> 4 > 0 {
+                channel.Tremolo.Speed = channel.EffectParameter >> 4
+            }
+            if channel.EffectParameter & 0xf > 0 {
+                channel.Tremolo.Depth = channel.EffectParameter & 0xf
             }
         case EffectGlobalVolume:
             channel.Player.GlobalVolume = uint8(channel.EffectParameter & 0x3f)
             log.Printf("Set global volume to %v", channel.Player.GlobalVolume)
         case EffectSetExtra:
             kind := channel.EffectParameter >> 4
+            value := channel.EffectParameter & 0xf
             switch kind {
+                case 0x1:
+                    channel.Glissando = value != 0
+                case 0x2:
+                    // the finetune was already applied to the note on this row
+                case 0x3:
+                    channel.Vibrato.SetControl(channel.EffectParameter & 0xf)
+                case 0x4:
+                    channel.Tremolo.SetControl(channel.EffectParameter & 0xf)
                 case 0x8:
-                    channel.Pan = channel.EffectParameter & 0xf
+                    // mono songs stay in the center
+                    if channel.Player.S3M.Stereo {
+                        channel.Pan = channel.EffectParameter & 0xf
+                    }
                 case 0xa:
                     // legacy pan, SAx but some songs use it
                     pan := channel.EffectParameter & 0xf
@@ -244,7 +359,21 @@ func (channel *Channel) UpdateRow() {
                         pan += 8
                     }
 
-                    channel.Pan = pan
+                    if channel.Player.S3M.Stereo {
+                        channel.Pan = pan
+                    }
+                case 0xb:
+                    channel.Player.doPatternLoop(value)
+                case 0xc:
+                    // scream tracker ignores SC0
+                    if value > 0 {
+                        channel.CurrentEffect = EffectNoteCut
+                        channel.NoteCut = value
+                    }
+                case 0xe:
+                    if channel.Player.PatternDelay == 0 {
+                        channel.Player.PatternDelay = value
+                    }
                 case 0xd:
                     // note delay
 
@@ -254,11 +383,14 @@ func (channel *Channel) UpdateRow() {
                     delayPeriod := newPeriod
                     delaySample := newSample
                     delayStartPosition := 0.0
+                    delayKeyOn := channel.adlibKeyOn
+                    channel.adlibKeyOn = false
                     channel.UpdateDelay = func() {
                         channel.CurrentVolume = delayVolume
                         channel.CurrentPeriod = delayPeriod
                         channel.CurrentSample = delaySample
                         channel.startPosition = float32(delayStartPosition)
+                        channel.adlibKeyOn = delayKeyOn
                     }
 
                     newVolume = channel.CurrentVolume
@@ -270,11 +402,7 @@ func (channel *Channel) UpdateRow() {
                     log.Printf("Unknown extra effect %v with parameter %v", kind, channel.EffectParameter)
             }
         case EffectVolumeSlide:
-            channel.CurrentEffect = EffectVolumeSlide
-
-            if note.EffectParameter > 0 {
-                channel.VolumeSlide = note.EffectParameter
-            }
+            channel.VolumeSlide = uint8(channel.EffectParameter)
         default:
             log.Printf("Channel %v unknown effect %v with parameter %v", channel.Channel, channel.CurrentEffect, channel.EffectParameter)
     }
@@ -285,30 +413,61 @@ func (channel *Channel) UpdateRow() {
     channel.startPosition = newStartPosition
 }
 
-func (channel *Channel) doVolumeSlide() {
+// the effects that share the same parameter memory in scream tracker 3
+func sharesEffectMemory(effect int) bool {
+    switch effect {
+        case EffectVolumeSlide, EffectPortamentoDown, EffectPortamentoUp, EffectTremor, EffectArpeggio,
+             EffectVibratoAndVolumeSlide, EffectPortamentoAndVolumeSlide, EffectRetriggerAndVolumeSlide, EffectSetExtra:
+            return true
+    }
+
+    return false
+}
+
+// the period of a note for the channel's middle c speed. slides work on this period, so they
+// are the same size relative to the pitch no matter what speed the sample was recorded at
+func (channel *Channel) notePeriod(note int) int {
+    period := Octaves[note]
+    if channel.MiddleC > 0 {
+        period = 8363 * period / channel.MiddleC
+    }
+
+    return channel.limitPeriod(period)
+}
+
+// keep a period inside the range scream tracker can play. with amiga limits the range is
+// the same as protracker, C-2 to B-4
+func (channel *Channel) limitPeriod(period int) int {
+    if channel.Player.S3M.AmigaLimits {
+        return max(452, min(3424, period))
+    }
+
+    return min(0x7fff, period)
+}
+
+// Dxy, where x slides up and y slides down. DxF and DFy are fine slides that only happen on the
+// first tick of the row. the other slides happen on every tick except the first, unless the
+// song uses fast slides
+func (channel *Channel) doVolumeSlide(firstTick bool) {
     volumeAmount := 0
 
     slideUp := int(channel.VolumeSlide >> 4)
     slideDown := int(channel.VolumeSlide & 0xf)
 
-    if slideUp == 0xf {
-        volumeAmount = -slideDown
-        if slideDown == 0xf {
+    if slideDown == 0xf && slideUp > 0 {
+        if firstTick {
             volumeAmount = slideUp
         }
-    } else if slideDown == 0xf {
-        if slideUp > 0 {
-            volumeAmount = slideUp
-        } else {
+    } else if slideUp == 0xf && slideDown > 0 {
+        if firstTick {
             volumeAmount = -slideDown
         }
-    } else if slideUp > 0 {
-        // FIXME: implement fast volume slides
-        // volumeAmount = slideUp * (channel.Player.Speed - 1)
-        volumeAmount = slideUp
-    } else if slideDown > 0 {
-        // volumeAmount = -slideDown * (channel.Player.Speed - 1)
-        volumeAmount = -slideDown
+    } else if !firstTick || channel.Player.S3M.FastVolumeSlides {
+        if slideDown > 0 {
+            volumeAmount = -slideDown
+        } else {
+            volumeAmount = slideUp
+        }
     }
 
     channel.CurrentVolume += volumeAmount
@@ -320,17 +479,22 @@ func (channel *Channel) doVolumeSlide() {
     }
 }
 
+// Gxx moves the period by 4*xx per tick towards the target note
 func (channel *Channel) doPortamentoToNote(ticks int) {
-    // FIXME: the rate of portamento is not correct. The docs say portamento*4, but that is too slow
+    amount := int(channel.PortamentoToNote) * ticks * 4
+
+    // log.Printf("portamento from %v to %v by %v", channel.CurrentPeriod, channel.PortamentoNote, amount)
+    if channel.PortamentoNote == 0 {
+        return
+    }
 
-    // log.Printf("portamento from %v to %v by %v", channel.CurrentPeriod, channel.PortamentoNote, int(channel.PortamentoToNote) * 4 * 2)
     if channel.CurrentPeriod < channel.PortamentoNote {
-        channel.CurrentPeriod += int(channel.PortamentoToNote) * ticks * 4
+        channel.CurrentPeriod += amount
         if channel.CurrentPeriod > channel.PortamentoNote {
             channel.CurrentPeriod = channel.PortamentoNote
         }
     } else if channel.CurrentPeriod > channel.PortamentoNote {
-        channel.CurrentPeriod -= int(channel.PortamentoToNote) * ticks * 4
+        channel.CurrentPeriod -= amount
         if channel.CurrentPeriod < channel.PortamentoNote {
             channel.CurrentPeriod = channel.PortamentoNote
         }
@@ -338,13 +502,17 @@ func (channel *Channel) doPortamentoToNote(ticks int) {
 }
 
 func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
+    if !changeRow {
+        channel.rowTick += ticks
+    }
+
     switch channel.CurrentEffect {
         case EffectVolumeSlide:
-            channel.doVolumeSlide()
+            channel.doVolumeSlide(changeRow)
         case EffectVibratoAndVolumeSlide:
-            channel.doVolumeSlide()
+            channel.doVolumeSlide(changeRow)
             channel.Vibrato.Update()
-        case EffectVibrato:
+        case EffectVibrato, EffectFineVibrato:
             channel.Vibrato.Update()
         case EffectNoteDelay:
             channel.NoteDelay -= ticks
@@ -353,11 +521,22 @@ func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
                 channel.UpdateDelay()
                 channel.CurrentEffect = EffectNone
             }
+        case EffectNoteCut:
+            if !changeRow {
+                channel.NoteCut -= ticks
+                if channel.NoteCut <= 0 {
+                    channel.CurrentVolume = 0
+                    channel.CurrentEffect = EffectNone
+                }
+            }
+        case EffectTremor:
+            // the tremor count keeps going from one row to the next
+            channel.TremorCount = (channel.TremorCount + ticks) % (channel.TremorOn + channel.TremorOff)
         case EffectTremolo:
             channel.Tremolo.Update()
         case EffectPortamentoAndVolumeSlide:
+            channel.doVolumeSlide(changeRow)
             if !changeRow {
-                channel.doVolumeSlide()
                 channel.doPortamentoToNote(ticks)
             }
         case EffectPortamentoToNote:
@@ -366,25 +545,30 @@ func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
             }
         case EffectPortamentoDown:
             if !changeRow {
-                channel.CurrentPeriod += int(channel.PortamentoNote) * ticks * 4
+                channel.CurrentPeriod = channel.limitPeriod(channel.CurrentPeriod + channel.EffectParameter * ticks * 4)
             }
         case EffectPortamentoUp:
             if !changeRow {
-                channel.CurrentPeriod -= int(channel.PortamentoNote) * ticks * 4
-                if channel.CurrentPeriod < 56 {
+                channel.CurrentPeriod -= channel.EffectParameter * ticks * 4
+                if channel.Player.S3M.AmigaLimits {
+                    channel.CurrentPeriod = channel.limitPeriod(channel.CurrentPeriod)
+                } else if channel.CurrentPeriod < 56 {
                     channel.CurrentSample = -1
                     // channel.CurrentPeriod = 56
                 }
             }
         case EffectRetriggerAndVolumeSlide:
-            if !changeRow && channel.Retrigger > 0 && ticks % channel.Retrigger == 0 {
+            if !changeRow && channel.Retrigger > 0 && channel.rowTick % channel.Retrigger == 0 {
                 // log.Printf("Retriggering channel %v", channel.Channel)
                 instrument := channel.Player.GetInstrument(channel.CurrentSample)
                 if instrument != nil && len(instrument.Data) > 0 {
                     channel.startPosition = 0.0
                 }
+                if instrument != nil && instrument.IsAdlib() {
+                    channel.adlibKeyOn = true
+                }
 
-                switch channel.VolumeSlide {
+                switch channel.EffectParameter >> 4 {
                     case 0:
                     case 1: channel.CurrentVolume -= 1
                     case 2: channel.CurrentVolume -= 2
@@ -413,6 +597,78 @@ func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
     }
 }
 
+// the volume 0-64 that the channel plays at, which tremor can silence
+func (channel *Channel) getVolume() int {
+    if channel.CurrentEffect == EffectTremor && channel.TremorCount >= channel.TremorOn {
+        return 0
+    }
+
+    return channel.CurrentVolume
+}
+
+// the playback rate of the instrument for the current period, including vibrato
+func (channel *Channel) getFrequency() float32 {
+    period := channel.CurrentPeriod
+
+    if channel.Glissando && channel.MiddleC > 0 && (channel.CurrentEffect == EffectPortamentoToNote || channel.CurrentEffect == EffectPortamentoAndVolumeSlide) {
+        // round in the note table, which is relative to a middle c of 8363
+        period = 8363 * roundPeriod(period * channel.MiddleC / 8363) / channel.MiddleC
+    }
+
+    switch channel.CurrentEffect {
+        case EffectVibrato, EffectFineVibrato, EffectVibratoAndVolumeSlide:
+            period = channel.Vibrato.Apply(period)
+        case EffectArpeggio:
+            // Jxy cycles between the note, the note + x semitones and the note + y semitones
+            semitones := 0
+            switch channel.rowTick % 3 {
+                case 1: semitones = channel.EffectParameter >> 4
+                case 2: semitones = channel.EffectParameter & 0xf
+            }
+            period = int(float64(period) / math.Pow(2, float64(semitones) / 12))
+    }
+
+    if period <= 0 {
+        return 0
+    }
+
+    return 14317056 / float32(period)
+}
+
+// render the adlib voice, returns the number of samples written
+func (channel *Channel) updateAdlib(instrument *Instrument, samples int) int {
+    voice := channel.adlib
+    if channel.adlibKeyOn {
+        voice.KeyOn(instrument)
+        channel.adlibKeyOn = false
+    }
+
+    // the playback rate of a sample at middle c is 8363hz, 32 times the pitch of middle c
+    frequency := float64(channel.getFrequency()) / 32
+
+    volume := channel.Volume * float32(channel.Player.GlobalVolume) / 64
+    leftPan := channel.GetLeftPan()
+    rightPan := channel.GetRightPan()
+
+    // the tremolo changes the volume of the fm voice
+    noteVolume := channel.getVolume()
+    if channel.CurrentEffect == EffectTremolo {
+        noteVolume = channel.Tremolo.ApplyVolume(noteVolume)
+    }
+
+    for range samples {
+        sample := voice.Generate(frequency, noteVolume, channel.Player.SampleRate) * volume
+
+        channel.AudioBuffer.UnsafeWrite(max(-1, min(1, sample * leftPan)))
+        channel.AudioBuffer.UnsafeWrite(max(-1, min(1, sample * rightPan)))
+
+        channel.ScopeBuffer.UnsafeWrite(max(-1, min(1, sample * leftPan)))
+        channel.ScopeBuffer.UnsafeWrite(max(-1, min(1, sample * rightPan)))
+    }
+
+    return samples
+}
+
 func (channel *Channel) Update(rate float32) {
     samples := int(float32(channel.Player.SampleRate) * rate)
     samplesWritten := 0
@@ -423,15 +679,14 @@ func (channel *Channel) Update(rate float32) {
     // if channel.CurrentNote != nil && int(channel.startPosition) < len(channel.CurrentSample.Data) && channel.CurrentFrequency > 0 && channel.Delay <= 0 {
     if channel.CurrentSample >= 0 && channel.CurrentPeriod > 0 {
         instrument := channel.Player.GetInstrument(channel.CurrentSample)
-        if instrument.MiddleC > 0 {
-
-            period := 8363 * channel.CurrentPeriod / int(instrument.MiddleC)
-
-            if channel.CurrentEffect == EffectVibrato || channel.CurrentEffect == EffectVibratoAndVolumeSlide {
-                period = channel.Vibrato.Apply(period)
+        if instrument != nil && instrument.IsAdlib() {
+            // adlib instruments only play on adlib channels
+            if channel.adlib != nil && instrument.MiddleC > 0 {
+                samplesWritten = channel.updateAdlib(instrument, samples)
             }
+        } else if instrument != nil && instrument.MiddleC > 0 {
 
-            frequency := 14317056 / float32(period)
+            frequency := channel.getFrequency()
             // frequency := amigaFrequency / float32(period * 2)
 
             // ???
@@ -442,7 +697,7 @@ func (channel *Channel) Update(rate float32) {
 
             incrementRate := frequency / float32(channel.Player.SampleRate)
 
-            noteVolume := float32(channel.CurrentVolume) / 64
+            noteVolume := float32(channel.getVolume()) / 64
 
             leftPan := channel.GetLeftPan()
             rightPan := channel.GetRightPan()
@@ -575,6 +830,8 @@ type Player struct {
 
     Speed int
     BPM int
+    // the scream tracker 2 tempo byte, for songs with combined tempos
+    CombinedTempo int
 
     GlobalVolume uint8
     CurrentRow int
@@ -588,6 +845,14 @@ type Player struct {
     DoBreak bool
     BreakRow int
 
+    // SBx pattern loop state
+    LoopRow int // the row that SB0 marked as the start of the loop
+    LoopCount int // how many more times to repeat the loop
+    DoLoop bool // jump back to LoopRow at the end of the current row
+
+    // SEx repeats the current row this many more times
+    PatternDelay int
+
     OnChangeRow func(row int)
     OnChangeOrder func(order int, pattern int)
     OnChangeSpeed func(speed int, bpm int)
@@ -604,6 +869,10 @@ func MakePlayer(file *S3MFile, sampleRate int) *Player {
         GlobalVolume: file.GlobalVolume,
     }
 
+    if file.CombinedTempo {
+        player.setCombinedTempo(int(file.InitialTempo))
+    }
+
     // player.BPM = 30
 
     for channelNum, index := range file.ChannelMap {
@@ -622,6 +891,10 @@ func MakePlayer(file *S3MFile, sampleRate int) *Player {
             buffer: make([]float32, sampleRate),
             currentRow: -1,
         }
+
+        if adlibChannel, ok := file.AdlibChannels[channelNum]; ok {
+            channels[index].adlib = makeAdlibVoice(adlibChannel)
+        }
     }
 
     for i, channel := range channels {
@@ -646,6 +919,23 @@ func (player *Player) GetPattern() int {
     return int(player.S3M.Orders[player.CurrentOrder])
 }
 
+// set the speed and tick rate from a scream tracker 2 tempo byte. the bpm is only
+// the closest value for showing to the user
+func (player *Player) setCombinedTempo(tempo int) {
+    player.CombinedTempo = tempo
+    player.Speed = tempo >> 4
+    player.BPM = int(math.Round(float64(combinedTickRate(tempo)) * 5 / 2))
+}
+
+// the number of ticks per second
+func (player *Player) tickRate() float32 {
+    if player.S3M.CombinedTempo {
+        return combinedTickRate(player.CombinedTempo)
+    }
+
+    return float32(player.BPM) * 2 / 5
+}
+
 func (player *Player) GetSongLength() int {
     return player.S3M.SongLength
 }
@@ -683,7 +973,7 @@ func (player *Player) Update(timeDelta float32) {
         player.CurrentRow = 0
     }
 
-    player.ticks += timeDelta * float32(player.BPM) * 2 / 5
+    player.ticks += timeDelta * player.tickRate()
     newTicks := int(player.ticks)
 
     /*
@@ -692,28 +982,44 @@ func (player *Player) Update(timeDelta float32) {
     }
     */
 
+    // true if the row advanced, even if it went back to the same row because of a pattern loop
+    rowChanged := false
+
     if player.ticks >= float32(player.Speed) {
-        player.CurrentRow += 1
-        // log.Printf("Row: %v", player.CurrentRow)
         player.ticks -= float32(player.Speed)
 
-        if player.DoBreak {
-            player.DoBreak = false
-            player.NextOrder()
-            player.CurrentRow = player.BreakRow
-        }
+        if player.PatternDelay > 0 {
+            // repeat the row without playing its notes again
+            player.PatternDelay -= 1
+        } else {
+            player.CurrentRow += 1
+            rowChanged = true
+            // log.Printf("Row: %v", player.CurrentRow)
+
+            if player.DoLoop {
+                player.DoLoop = false
+                player.CurrentRow = player.LoopRow
+            } else {
+                if player.DoBreak {
+                    player.DoBreak = false
+                    player.NextOrder()
+                    player.CurrentRow = player.BreakRow
+                }
 
-        if player.DoJump {
-            player.DoJump = false
-            player.CurrentRow = 0
-            player.CurrentOrder = player.JumpOrder
-            if player.OnChangeOrder != nil {
-                player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+                if player.DoJump {
+                    player.DoJump = false
+                    player.resetPatternLoop()
+                    player.CurrentRow = 0
+                    player.CurrentOrder = player.JumpOrder
+                    if player.OnChangeOrder != nil {
+                        player.OnChangeOrder(player.CurrentOrder, player.GetPattern())
+                    }
+                }
             }
-        }
 
-        if player.OnChangeRow != nil {
-            player.OnChangeRow(player.CurrentRow)
+            if player.OnChangeRow != nil {
+                player.OnChangeRow(player.CurrentRow)
+            }
         }
     }
 
@@ -722,6 +1028,7 @@ func (player *Player) Update(timeDelta float32) {
         player.CurrentRow = 0
         player.CurrentOrder += 1
         player.OrdersPlayed += 1
+        player.resetPatternLoop()
         if player.CurrentOrder >= player.S3M.SongLength {
             player.CurrentOrder = 0
         }
@@ -743,7 +1050,7 @@ func (player *Player) Update(timeDelta float32) {
 
     for _, channel := range player.Channels {
         changeRow := false
-        if player.CurrentRow != channel.currentRow {
+        if rowChanged || player.CurrentRow != channel.currentRow {
             channel.UpdateRow()
             changeRow = true
         }
@@ -792,7 +1099,36 @@ func (player *Player) ToggleMuteChannel(channel int) bool {
     return player.Channels[channel].Mute
 }
 
+// SB0 marks the start of a loop, SBx with x > 0 jumps back to it x times
+func (player *Player) doPatternLoop(value int) {
+    if value == 0 {
+        player.LoopRow = player.CurrentRow
+        return
+    }
+
+    if player.LoopCount == 0 {
+        player.LoopCount = value
+        player.DoLoop = true
+    } else {
+        player.LoopCount -= 1
+        if player.LoopCount > 0 {
+            player.DoLoop = true
+        } else {
+            // once a loop is done scream tracker starts the next one after it
+            player.LoopRow = player.CurrentRow + 1
+        }
+    }
+}
+
+// forget any pattern loop, used when a new pattern starts
+func (player *Player) resetPatternLoop() {
+    player.LoopRow = 0
+    player.LoopCount = 0
+    player.DoLoop = false
+}
+
 func (player *Player) NextOrder() {
+    player.resetPatternLoop()
     player.CurrentOrder += 1
     if player.CurrentOrder >= player.S3M.SongLength {
         player.CurrentOrder = 0
@@ -804,6 +1140,7 @@ func (player *Player) NextOrder() {
 }
 
 func (player *Player) PreviousOrder() {
+    player.resetPatternLoop()
     player.CurrentOrder -= 1
     if player.CurrentOrder < 0 {
         player.CurrentOrder = player.S3M.SongLength - 1
@@ -0,0 +1,269 @@
+package stm
+
+import (
+    "io"
+    "bufio"
+    "bytes"
+    "fmt"
+    "log"
+    "encoding/binary"
+
+    "github.com/kazzmir/tracker/s3m"
+)
+
+const (
+    Channels = 4
+    Samples = 31
+    Rows = 64
+)
+
+// scream tracker 2 is the predecessor of scream tracker 3 and its effects are a subset of the s3m
+// effects, so songs are converted to s3m files and played by the s3m player
+type STMFile struct {
+    s3m.S3MFile
+    // the program that wrote the file, such as !Scream! or BMOD2STM
+    Tracker string
+    VersionMajor byte
+    VersionMinor byte
+}
+
+// versions before 2.21 store tempos in decimal rather than as two nibbles
+func convertTempo(tempo byte, versionMinor byte) byte {
+    if versionMinor < 21 {
+        return (tempo / 10) << 4 + tempo % 10
+    }
+
+    return tempo
+}
+
+// read the 64 rows of a pattern. each note is 4 bytes, except for the empty notes and note cuts
+// that some programs store as a single byte
+func readPattern(reader *bufio.Reader, instruments []s3m.Instrument, versionMinor byte) (s3m.Pattern, error) {
+    var pattern s3m.Pattern
+
+    for range Rows {
+        row := make([]s3m.Note, Channels)
+
+        for channel := range Channels {
+            note := &row[channel]
+            note.Channel = channel
+
+            first, err := reader.ReadByte()
+            if err != nil {
+                return s3m.Pattern{}, err
+            }
+
+            switch first {
+                case 0xfb, 0xfc:
+                    continue
+                case 0xfd:
+                    note.ChangeNote = true
+                    note.Note = 254
+                    continue
+            }
+
+            var data [3]byte
+            _, err = io.ReadFull(reader, data[:])
+            if err != nil {
+                return s3m.Pattern{}, err
+            }
+
+            // the instrument and volume are packed as iiiiivvv vvvveeee
+            instrument := int(data[0] >> 3)
+            volume := int(data[0] & 7) | int(data[1] & 0xf0) >> 1
+            effect := data[1] & 0xf
+            parameter := data[2]
+
+            if first == 0xfe {
+                note.ChangeNote = true
+                note.Note = 254
+            } else if first < 0x60 {
+                // the octave is in the high nibble, and octave 2 plays at the speed of the sample
+                // which is octave 4 in scream tracker 3
+                note.ChangeNote = true
+                note.Note = int(first >> 4 + 2) << 4 | int(first & 0xf)
+            }
+
+            if instrument > 0 && instrument <= len(instruments) {
+                note.ChangeSample = true
+                note.SampleNumber = instrument
+            }
+
+            if volume <= 64 {
+                note.ChangeVolume = true
+                note.Volume = volume
+            } else if note.ChangeSample {
+                // a note without a volume plays at the volume of the sample
+                note.ChangeVolume = true
+                note.Volume = int(instruments[instrument - 1].Volume)
+            }
+
+            // the effects A to J are the same as in scream tracker 3
+            if effect >= s3m.EffectSetSpeed && effect <= s3m.EffectArpeggio {
+                switch int(effect) {
+                    case s3m.EffectSetSpeed:
+                        parameter = convertTempo(parameter, versionMinor)
+                    case s3m.EffectPatternBreak:
+                        // scream tracker 2 always breaks to the first row
+                        parameter = 0
+                }
+
+                note.ChangeEffect = true
+                note.EffectNumber = effect
+                note.EffectParameter = parameter
+            }
+        }
+
+        pattern.Rows = append(pattern.Rows, row)
+    }
+
+    return pattern, nil
+}
+
+func Load(reader_ io.ReadSeeker, logger *log.Logger) (*STMFile, error) {
+    _, err := reader_.Seek(0, io.SeekStart)
+    if err != nil {
+        return nil, err
+    }
+
+    reader := bufio.NewReader(reader_)
+
+    header := make([]byte, 48)
+    _, err = io.ReadFull(reader, header)
+    if err != nil {
+        return nil, err
+    }
+
+    // 2 is a module, 1 would be a song without samples
+    if header[28] != 0x1a || header[29] != 2 || header[30] != 2 {
+        return nil, fmt.Errorf("Not an stm file")
+    }
+
+    file := STMFile{
+        Tracker: string(header[20:28]),
+        VersionMajor: header[30],
+        VersionMinor: header[31],
+    }
+
+    file.Name = string(bytes.TrimRight(header[:20], "\x00"))
+    tempo := convertTempo(header[32], file.VersionMinor)
+    patternCount := int(header[33])
+    file.GlobalVolume = min(header[34], 64)
+
+    logger.Printf("STM '%v' made by '%v' version %v.%v", file.Name, file.Tracker, file.VersionMajor, file.VersionMinor)
+    logger.Printf("Tempo 0x%x patterns %v global volume %v", tempo, patternCount, file.GlobalVolume)
+
+    // a speed of 0 would never advance the song
+    if tempo >> 4 == 0 {
+        tempo = 0x60
+    }
+
+    file.CombinedTempo = true
+    file.InitialTempo = tempo
+    file.InitialSpeed = tempo >> 4
+
+    offsets := make([]int, Samples)
+    lengths := make([]int, Samples)
+
+    for i := range Samples {
+        data := make([]byte, 32)
+        _, err = io.ReadFull(reader, data)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading sample %v: %v", i, err)
+        }
+
+        // the sample data is stored at a multiple of 16 bytes
+        offsets[i] = int(binary.LittleEndian.Uint16(data[14:])) << 4
+        lengths[i] = int(binary.LittleEndian.Uint16(data[16:]))
+        loopStart := int(binary.LittleEndian.Uint16(data[18:]))
+        loopEnd := int(binary.LittleEndian.Uint16(data[20:]))
+
+        instrument := s3m.Instrument{
+            Name: string(bytes.TrimRight(data[:12], "\x00")),
+            Type: s3m.InstrumentSample,
+            Volume: min(data[22], 64),
+            MiddleC: binary.LittleEndian.Uint16(data[24:]),
+        }
+
+        // a loop end of 0xffff means the sample doesn't loop
+        if loopEnd != 0xffff && loopStart < loopEnd && loopEnd <= lengths[i] {
+            instrument.Loop = true
+            instrument.Flags = 1
+            instrument.LoopBegin = loopStart
+            instrument.LoopEnd = loopEnd
+        }
+
+        logger.Printf("Sample %v: Name='%v', Length=%v, Volume=%v, MiddleC=%v, Loop=%v", i, instrument.Name, lengths[i], instrument.Volume, instrument.MiddleC, instrument.Loop)
+
+        file.Instruments = append(file.Instruments, instrument)
+    }
+
+    // version 2.00 only has room for 64 orders
+    orderCount := 128
+    if file.VersionMinor == 0 {
+        orderCount = 64
+    }
+
+    orders := make([]byte, orderCount)
+    _, err = io.ReadFull(reader, orders)
+    if err != nil {
+        return nil, fmt.Errorf("Error reading orders: %v", err)
+    }
+
+    // the order list ends at 99, or at any other order that is not a pattern
+    for _, order := range orders {
+        if int(order) >= patternCount {
+            break
+        }
+        file.Orders = append(file.Orders, order)
+    }
+
+    if len(file.Orders) == 0 {
+        return nil, fmt.Errorf("Song has no orders")
+    }
+
+    file.SongLength = len(file.Orders)
+
+    for i := range patternCount {
+        pattern, err := readPattern(reader, file.Instruments, file.VersionMinor)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading pattern %v: %v", i, err)
+        }
+        file.Patterns = append(file.Patterns, pattern)
+    }
+
+    for i := range file.Instruments {
+        if lengths[i] == 0 || offsets[i] == 0 {
+            continue
+        }
+
+        _, err = reader_.Seek(int64(offsets[i]), io.SeekStart)
+        if err != nil {
+            return nil, err
+        }
+
+        data := make([]byte, lengths[i])
+        _, err = io.ReadFull(reader_, data)
+        if err != nil {
+            return nil, fmt.Errorf("Error reading data of sample %v: %v", i, err)
+        }
+
+        // the samples are signed
+        floatData := make([]float32, len(data))
+        for j, value := range data {
+            floatData[j] = float32(int8(value)) / 128
+        }
+
+        file.Instruments[i].Data = floatData
+    }
+
+    // scream tracker 2 plays every channel in the center
+    file.ChannelMap = make(map[int]int)
+    file.ChannelPanning = make(map[int]byte)
+    for i := range Channels {
+        file.ChannelMap[i] = i
+        file.ChannelPanning[i] = 8
+    }
+
+    return &file, nil
+}
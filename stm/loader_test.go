@@ -0,0 +1,90 @@
+package stm
+
+import (
+    "testing"
+    "bytes"
+    "encoding/binary"
+    "io"
+    "log"
+)
+
+// a song with one pattern that plays sample 1 at C-5 on the first channel
+func makeSong() []byte {
+    header := make([]byte, 48)
+    copy(header, "test")
+    copy(header[20:], "!Scream!")
+    header[28] = 0x1a
+    header[29] = 2
+    header[30] = 2
+    header[31] = 21
+    header[32] = 0x60
+    header[33] = 1
+    header[34] = 64
+
+    song := header
+
+    // the sample data goes after the headers, the orders and the pattern
+    dataOffset := 48 + Samples * 32 + 128 + 4 + (Rows * Channels - 1)
+    dataOffset = (dataOffset + 15) / 16 * 16
+
+    for i := range Samples {
+        sample := make([]byte, 32)
+        binary.LittleEndian.PutUint16(sample[20:], 0xffff)
+        binary.LittleEndian.PutUint16(sample[24:], 8363)
+        if i == 0 {
+            copy(sample, "sample")
+            binary.LittleEndian.PutUint16(sample[14:], uint16(dataOffset >> 4))
+            binary.LittleEndian.PutUint16(sample[16:], 4)
+            sample[22] = 64
+        }
+        song = append(song, sample...)
+    }
+
+    orders := make([]byte, 128)
+    orders[1] = 99
+    song = append(song, orders...)
+
+    // octave 3 note 0, instrument 1 with volume 32, no effect. the other notes are empty
+    song = append(song, 0x30, 1 << 3, 0x40, 0)
+    for range Rows * Channels - 1 {
+        song = append(song, 0xfb)
+    }
+
+    song = append(song, make([]byte, dataOffset - len(song))...)
+    return append(song, 0, 0x40, 0xc0, 0x7f)
+}
+
+func TestLoad(test *testing.T) {
+    logger := log.New(io.Discard, "", 0)
+
+    data := makeSong()
+    file, err := Load(bytes.NewReader(data), logger)
+    if err != nil {
+        test.Fatalf("%v", err)
+    }
+
+    if file.Name != "test" || len(file.Orders) != 1 || len(file.Patterns) != 1 || file.InitialSpeed != 6 {
+        test.Errorf("unexpected song '%v' orders %v patterns %v speed %v", file.Name, len(file.Orders), len(file.Patterns), file.InitialSpeed)
+    }
+
+    note := file.Patterns[0].Rows[0][0]
+    if !note.ChangeNote || note.Note != 0x50 || note.SampleNumber != 1 || note.Volume != 32 {
+        test.Errorf("unexpected note %+v", note)
+    }
+
+    if empty := file.Patterns[0].Rows[63][3]; empty.ChangeNote || empty.ChangeSample {
+        test.Errorf("expected an empty note, got %+v", empty)
+    }
+
+    if sample := file.Instruments[0].Data; len(sample) != 4 || sample[1] != 0.5 || sample[2] != -0.5 {
+        test.Errorf("unexpected sample data %v", sample)
+    }
+
+    // any file that is cut off is an error rather than a panic
+    for length := range len(data) {
+        _, err := Load(bytes.NewReader(data[:length]), logger)
+        if err == nil {
+            test.Errorf("expected an error for a file cut off at %v bytes", length)
+        }
+    }
+}
@@ -0,0 +1,18 @@
+package stm
+
+import (
+    "github.com/kazzmir/tracker/s3m"
+)
+
+// the s3m player handles the combined tempo of scream tracker 2, so it plays the song as it is
+type Player struct {
+    *s3m.Player
+    File *STMFile
+}
+
+func MakePlayer(file *STMFile, sampleRate int) *Player {
+    return &Player{
+        Player: s3m.MakePlayer(&file.S3MFile, sampleRate),
+        File: file,
+    }
+}
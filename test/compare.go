@@ -11,6 +11,13 @@ import (
     "github.com/kazzmir/tracker/mod"
     "github.com/kazzmir/tracker/s3m"
     "github.com/kazzmir/tracker/xm"
+    "github.com/kazzmir/tracker/it"
+    "github.com/kazzmir/tracker/mtm"
+    "github.com/kazzmir/tracker/stm"
+    "github.com/kazzmir/tracker/composer669"
+    "github.com/kazzmir/tracker/okt"
+    "github.com/kazzmir/tracker/med"
+    "github.com/kazzmir/tracker/ahx"
 
     "github.com/go-audio/wav"
     "github.com/go-audio/audio"
@@ -50,6 +57,76 @@ func tryLoadXM(path string) (*xm.XMFile, error) {
     return xm.Load(file, log.New(io.Discard, "", 0))
 }
 
+func tryLoadIT(path string) (*it.ITFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return it.Load(file, log.New(io.Discard, "", 0))
+}
+
+func tryLoadMTM(path string) (*mtm.MTMFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return mtm.Load(file, log.New(io.Discard, "", 0))
+}
+
+func tryLoadSTM(path string) (*stm.STMFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return stm.Load(file, log.New(io.Discard, "", 0))
+}
+
+func tryLoad669(path string) (*composer669.File669, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return composer669.Load(file, log.New(io.Discard, "", 0))
+}
+
+func tryLoadOKT(path string) (*okt.OKTFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return okt.Load(file, log.New(io.Discard, "", 0))
+}
+
+func tryLoadMED(path string) (*med.MEDFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return med.Load(file, log.New(io.Discard, "", 0))
+}
+
+func tryLoadAHX(path string) (*ahx.AHXFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return ahx.Load(file, log.New(io.Discard, "", 0))
+}
+
 type Renderer interface {
     RenderToPCM() io.Reader
 }
@@ -62,6 +139,13 @@ func TryLoad(path string, sampleRate int) (Renderer, error) {
 
     // log.Printf("Unable to load s3m: %v", err)
 
+    itFile, err := tryLoadIT(path)
+    if err == nil {
+        return it.MakePlayer(itFile, sampleRate), nil
+    }
+
+    // log.Printf("Unable to load it: %v", err)
+
     xmFile, err := tryLoadXM(path)
     if err == nil {
         return xm.MakePlayer(xmFile, sampleRate), nil
@@ -69,6 +153,48 @@ func TryLoad(path string, sampleRate int) (Renderer, error) {
 
     // log.Printf("Unable to load xm: %v", err)
 
+    mtmFile, err := tryLoadMTM(path)
+    if err == nil {
+        return mtm.MakePlayer(mtmFile, sampleRate), nil
+    }
+
+    // log.Printf("Unable to load mtm: %v", err)
+
+    stmFile, err := tryLoadSTM(path)
+    if err == nil {
+        return stm.MakePlayer(stmFile, sampleRate), nil
+    }
+
+    // log.Printf("Unable to load stm: %v", err)
+
+    file669, err := tryLoad669(path)
+    if err == nil {
+        return composer669.MakePlayer(file669, sampleRate), nil
+    }
+
+    // log.Printf("Unable to load 669: %v", err)
+
+    oktFile, err := tryLoadOKT(path)
+    if err == nil {
+        return okt.MakePlayer(oktFile, sampleRate), nil
+    }
+
+    // log.Printf("Unable to load okt: %v", err)
+
+    medFile, err := tryLoadMED(path)
+    if err == nil {
+        return med.MakePlayer(medFile, sampleRate), nil
+    }
+
+    // log.Printf("Unable to load med: %v", err)
+
+    ahxFile, err := tryLoadAHX(path)
+    if err == nil {
+        return ahx.MakePlayer(ahxFile, sampleRate), nil
+    }
+
+    // log.Printf("Unable to load ahx: %v", err)
+
     modFile, err := tryLoadMod(path)
     if err != nil {
         return nil, err
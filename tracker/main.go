@@ -18,6 +18,13 @@ import (
     "github.com/kazzmir/tracker/mod"
     "github.com/kazzmir/tracker/s3m"
     "github.com/kazzmir/tracker/xm"
+    "github.com/kazzmir/tracker/it"
+    "github.com/kazzmir/tracker/mtm"
+    "github.com/kazzmir/tracker/stm"
+    "github.com/kazzmir/tracker/composer669"
+    "github.com/kazzmir/tracker/okt"
+    "github.com/kazzmir/tracker/med"
+    "github.com/kazzmir/tracker/ahx"
     "github.com/kazzmir/tracker/data"
     "github.com/kazzmir/tracker/common"
     tracker_lib "github.com/kazzmir/tracker/lib"
@@ -44,6 +51,33 @@ type TrackerPlayer interface {
     RenderToPCM() io.Reader
 }
 
+// players that can sound like an amiga, which are the mod player and the formats that it plays
+type AmigaPlayer interface {
+    SetAmiga(mod.AmigaModel)
+}
+
+// players whose hard left/right panning can be brought closer to the center
+type SeparationPlayer interface {
+    SetStereoSeparation(float32)
+}
+
+// settings from the command line that apply to every song that is played
+type PlayerOptions struct {
+    Amiga mod.AmigaModel
+    // 0 to 1
+    StereoSeparation float32
+}
+
+func (options *PlayerOptions) Apply(player TrackerPlayer) {
+    if amigaPlayer, ok := player.(AmigaPlayer); ok {
+        amigaPlayer.SetAmiga(options.Amiga)
+    }
+
+    if separationPlayer, ok := player.(SeparationPlayer); ok {
+        separationPlayer.SetStereoSeparation(options.StereoSeparation)
+    }
+}
+
 type System struct {
     engine *Engine
 }
@@ -78,6 +112,7 @@ type Engine struct {
     AudioContext *audio.Context
     UI *ebitenui.UI
     UIHooks UIHooks
+    Options PlayerOptions
 
     volume float64
     fps int
@@ -90,9 +125,10 @@ type Engine struct {
     quit context.Context
 }
 
-func MakeEngine(player TrackerPlayer, audioContext *audio.Context, fps int, quit context.Context) (*Engine, error) {
+func MakeEngine(player TrackerPlayer, audioContext *audio.Context, fps int, options PlayerOptions, quit context.Context) (*Engine, error) {
     engine := &Engine{
         AudioContext: audioContext,
+        Options: options,
         fps: fps,
         volume: 0.6,
         quit: quit,
@@ -168,19 +204,158 @@ func (engine *Engine) LoadSongFromFilesystem(filesystem fs.FS, path string) {
         return xm.MakePlayer(loaded, engine.AudioContext.SampleRate()), nil
     }
 
+    loadIt := func() (TrackerPlayer, error) {
+        file, err := filesystem.Open(path)
+        if err != nil {
+            return nil, err
+        }
+        defer file.Close()
+
+        var buffer bytes.Buffer
+        _, err = io.Copy(&buffer, file)
+        if err != nil {
+            return nil, err
+        }
+
+        loaded, err := it.Load(bytes.NewReader(buffer.Bytes()), log.Default())
+        if err != nil {
+            return nil, err
+        }
+
+        return it.MakePlayer(loaded, engine.AudioContext.SampleRate()), nil
+    }
+
+    loadMtm := func() (TrackerPlayer, error) {
+        file, err := filesystem.Open(path)
+        if err != nil {
+            return nil, err
+        }
+        defer file.Close()
+
+        loaded, err := mtm.Load(file, log.Default())
+        if err != nil {
+            return nil, err
+        }
+
+        return mtm.MakePlayer(loaded, engine.AudioContext.SampleRate()), nil
+    }
+
+    loadStm := func() (TrackerPlayer, error) {
+        file, err := filesystem.Open(path)
+        if err != nil {
+            return nil, err
+        }
+        defer file.Close()
+
+        var buffer bytes.Buffer
+        _, err = io.Copy(&buffer, file)
+        if err != nil {
+            return nil, err
+        }
+
+        loaded, err := stm.Load(bytes.NewReader(buffer.Bytes()), log.Default())
+        if err != nil {
+            return nil, err
+        }
+
+        return stm.MakePlayer(loaded, engine.AudioContext.SampleRate()), nil
+    }
+
+    load669 := func() (TrackerPlayer, error) {
+        file, err := filesystem.Open(path)
+        if err != nil {
+            return nil, err
+        }
+        defer file.Close()
+
+        loaded, err := composer669.Load(file, log.Default())
+        if err != nil {
+            return nil, err
+        }
+
+        return composer669.MakePlayer(loaded, engine.AudioContext.SampleRate()), nil
+    }
+
+    loadOkt := func() (TrackerPlayer, error) {
+        file, err := filesystem.Open(path)
+        if err != nil {
+            return nil, err
+        }
+        defer file.Close()
+
+        loaded, err := okt.Load(file, log.Default())
+        if err != nil {
+            return nil, err
+        }
+
+        return okt.MakePlayer(loaded, engine.AudioContext.SampleRate()), nil
+    }
+
+    loadMed := func() (TrackerPlayer, error) {
+        file, err := filesystem.Open(path)
+        if err != nil {
+            return nil, err
+        }
+        defer file.Close()
+
+        loaded, err := med.Load(file, log.Default())
+        if err != nil {
+            return nil, err
+        }
+
+        return med.MakePlayer(loaded, engine.AudioContext.SampleRate()), nil
+    }
+
+    loadAhx := func() (TrackerPlayer, error) {
+        file, err := filesystem.Open(path)
+        if err != nil {
+            return nil, err
+        }
+        defer file.Close()
+
+        loaded, err := ahx.Load(file, log.Default())
+        if err != nil {
+            return nil, err
+        }
+
+        return ahx.MakePlayer(loaded, engine.AudioContext.SampleRate()), nil
+    }
+
     player, err := loadS3m()
     if err != nil {
-        player, err = loadXm()
+        player, err = loadIt()
         if err != nil {
-            player, err = loadMod()
+            player, err = loadXm()
+            if err != nil {
+                player, err = loadMtm()
+                if err != nil {
+                    player, err = loadStm()
+                    if err != nil {
+                        player, err = load669()
+                        if err != nil {
+                            player, err = loadOkt()
+                            if err != nil {
+                                player, err = loadMed()
+                                if err != nil {
+                                    player, err = loadAhx()
+                                    if err != nil {
+                                        player, err = loadMod()
+                                    }
+                                }
+                            }
+                        }
+                    }
+                }
+            }
         }
     }
 
     if err != nil {
-        log.Printf("Not an s3m or mod file %v: %v", path, err)
+        log.Printf("Not an s3m, it, xm, mtm, stm, 669, okt, med, ahx or mod file %v: %v", path, err)
         return
     }
 
+    engine.Options.Apply(player)
     engine.Initialize(player)
 }
 
@@ -359,6 +534,76 @@ func tryLoadXM(path string) (*xm.XMFile, error) {
     return xm.Load(file, log.Default())
 }
 
+func tryLoadIT(path string) (*it.ITFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return it.Load(file, log.Default())
+}
+
+func tryLoadMTM(path string) (*mtm.MTMFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return mtm.Load(file, log.Default())
+}
+
+func tryLoadSTM(path string) (*stm.STMFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return stm.Load(file, log.Default())
+}
+
+func tryLoad669(path string) (*composer669.File669, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return composer669.Load(file, log.Default())
+}
+
+func tryLoadOKT(path string) (*okt.OKTFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return okt.Load(file, log.Default())
+}
+
+func tryLoadMED(path string) (*med.MEDFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return med.Load(file, log.Default())
+}
+
+func tryLoadAHX(path string) (*ahx.AHXFile, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    return ahx.Load(file, log.Default())
+}
+
 func TryLoad(path string, sampleRate int) (TrackerPlayer, error) {
     s3mFile, err := tryLoadS3m(path)
     if err == nil {
@@ -367,6 +612,13 @@ func TryLoad(path string, sampleRate int) (TrackerPlayer, error) {
 
     log.Printf("Unable to load s3m: %v", err)
 
+    itFile, err := tryLoadIT(path)
+    if err == nil {
+        return it.MakePlayer(itFile, sampleRate), nil
+    }
+
+    log.Printf("Unable to load it: %v", err)
+
     xmFile, err := tryLoadXM(path)
     if err == nil {
         return xm.MakePlayer(xmFile, sampleRate), nil
@@ -374,6 +626,48 @@ func TryLoad(path string, sampleRate int) (TrackerPlayer, error) {
 
     log.Printf("Unable to load xm: %v", err)
 
+    mtmFile, err := tryLoadMTM(path)
+    if err == nil {
+        return mtm.MakePlayer(mtmFile, sampleRate), nil
+    }
+
+    log.Printf("Unable to load mtm: %v", err)
+
+    stmFile, err := tryLoadSTM(path)
+    if err == nil {
+        return stm.MakePlayer(stmFile, sampleRate), nil
+    }
+
+    log.Printf("Unable to load stm: %v", err)
+
+    file669, err := tryLoad669(path)
+    if err == nil {
+        return composer669.MakePlayer(file669, sampleRate), nil
+    }
+
+    log.Printf("Unable to load 669: %v", err)
+
+    oktFile, err := tryLoadOKT(path)
+    if err == nil {
+        return okt.MakePlayer(oktFile, sampleRate), nil
+    }
+
+    log.Printf("Unable to load okt: %v", err)
+
+    medFile, err := tryLoadMED(path)
+    if err == nil {
+        return med.MakePlayer(medFile, sampleRate), nil
+    }
+
+    log.Printf("Unable to load med: %v", err)
+
+    ahxFile, err := tryLoadAHX(path)
+    if err == nil {
+        return ahx.MakePlayer(ahxFile, sampleRate), nil
+    }
+
+    log.Printf("Unable to load ahx: %v", err)
+
     modFile, err := tryLoadMod(path)
     if err != nil {
         return nil, err
@@ -381,7 +675,7 @@ func TryLoad(path string, sampleRate int) (TrackerPlayer, error) {
     return mod.MakePlayer(modFile, sampleRate), nil
 }
 
-func runGui(player TrackerPlayer, sampleRate int, quit context.Context) error {
+func runGui(player TrackerPlayer, sampleRate int, options PlayerOptions, quit context.Context) error {
     fps := 30
 
     ebiten.SetTPS(fps)
@@ -398,7 +692,7 @@ func runGui(player TrackerPlayer, sampleRate int, quit context.Context) error {
     modPlayer.Channels[3].Mute = true
     */
 
-    engine, err := MakeEngine(player, audioContext, fps, quit)
+    engine, err := MakeEngine(player, audioContext, fps, options, quit)
     if err != nil {
         return err
     }
@@ -418,8 +712,26 @@ func main(){
     profile := flag.Bool("profile", false, "Enable profiling")
     wav := flag.String("wav", "", "Output wav file")
     cli := flag.Bool("cli", false, "Run in CLI mode without GUI")
+    amiga := flag.String("amiga", "", "Play mod files like an amiga would: a500 or a1200")
+    separation := flag.Int("separation", 100, "Stereo separation of mod files in percent, from 0 (mono) to 100 (hard left/right)")
     flag.Parse()
 
+    amigaModel, err := mod.ParseAmigaModel(*amiga)
+    if err != nil {
+        log.Printf("%v", err)
+        return
+    }
+
+    if *separation < 0 || *separation > 100 {
+        log.Printf("Invalid stereo separation %v, must be between 0 and 100", *separation)
+        return
+    }
+
+    options := PlayerOptions{
+        Amiga: amigaModel,
+        StereoSeparation: float32(*separation) / 100,
+    }
+
     if len(flag.Args()) == 0 && *wav != "" {
         log.Println("Usage: tracker [-wav <output-path>] <path to mod file>")
         return
@@ -458,6 +770,8 @@ func main(){
             log.Printf("Error loading module: %v", err)
             return
         }
+
+        options.Apply(player)
     } else {
         /*
         dataFile, name, err := data.FindMod()
@@ -497,7 +811,7 @@ func main(){
             log.Printf("Error: %v", err)
         }
     } else {
-        err := runGui(player, sampleRate, quit)
+        err := runGui(player, sampleRate, options, quit)
         if err != nil {
             log.Printf("Error: %v", err)
         }
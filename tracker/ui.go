@@ -385,6 +385,11 @@ func makeChannelView(player UIPlayer, face *text.Face) (UIHooks, *widget.Contain
                 }
                 */
 
+                noteText := fmt.Sprintf("%v %v %v", noteName, sampleName, effectName)
+                if volumeName := note.GetVolumeName(); volumeName != "" {
+                    noteText = fmt.Sprintf("%v %v %v %v", noteName, sampleName, volumeName, effectName)
+                }
+
                 textContainer := widget.NewContainer(
                     widget.ContainerOpts.Layout(widget.NewRowLayout(
                         widget.RowLayoutOpts.Direction(widget.DirectionVertical),
@@ -394,7 +399,7 @@ func makeChannelView(player UIPlayer, face *text.Face) (UIHooks, *widget.Contain
 
                 textContainer.AddChild(widget.NewText(
                     widget.TextOpts.Position(widget.TextPositionCenter, widget.TextPositionCenter),
-                    widget.TextOpts.Text(fmt.Sprintf("%v %v %v", noteName, sampleName, effectName), face, color.White),
+                    widget.TextOpts.Text(noteText, face, color.White),
                 ))
 
                 rowContainers[row] = append(rowContainers[row], textContainer)
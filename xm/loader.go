@@ -18,6 +18,8 @@ type XMFile struct {
     Channels int // number of channels in the song
     BPM uint16 // beats per minute
     Tempo uint16 // tempo in ticks per beat
+    LinearFrequency bool // true for the linear frequency table, false for amiga periods
+    RestartPosition int // the order to continue from once the song reaches the end of the order list
 }
 
 func (file *XMFile) GetPattern(order int) *Pattern {
@@ -59,6 +61,32 @@ func (note *Note) GetSampleName() string {
     return "--"
 }
 
+func (note *Note) GetVolumeName() string {
+    if !note.HasVolume || note.Volume < 0x10 {
+        return "--"
+    }
+
+    if note.Volume <= 0x50 {
+        return fmt.Sprintf("%02X", note.Volume - 0x10)
+    }
+
+    // the commands are shown the same way fasttracker 2 shows them, with ascii
+    // in place of the arrows for fine slides and panning slides
+    commands := []string{
+        "-", "+", "d", "u", "S", "V", "P", "<", ">", "M",
+    }
+
+    command := int(note.Volume >> 4) - 6
+    if command >= 0 && command < len(commands) {
+        return fmt.Sprintf("%s%X", commands[command], note.Volume & 0xf)
+    }
+
+    return "--"
+}
+
+// the note number that releases the current note instead of playing a new one
+const NoteKeyOff = 97
+
 func (note *Note) GetNoteName() string {
     // 1 is c-1
     // 12 is b-1
@@ -68,6 +96,10 @@ func (note *Note) GetNoteName() string {
         return "---"
     }
 
+    if note.NoteNumber == NoteKeyOff {
+        return "==="
+    }
+
     noteNames := []string{
         "C-", "C#","D-", "D#","E-","F-","F#","G-","G#","A-","A#","B-",
     }
@@ -132,10 +164,88 @@ type Pattern struct {
     parsedNotes []Note
 }
 
+type EnvelopePoint struct {
+    X uint16 // position in ticks
+    Y uint16 // value, 0-64
+}
+
+type Envelope struct {
+    Points []EnvelopePoint
+    SustainPoint int
+    LoopStart int
+    LoopEnd int
+    Enabled bool
+    Sustain bool
+    Loop bool
+}
+
+// the envelope type byte is a bitfield: 1 = on, 2 = sustain, 4 = loop
+func makeEnvelope(data []uint16, points uint8, sustainPoint uint8, loopStart uint8, loopEnd uint8, envelopeType uint8) Envelope {
+    var envelope Envelope
+
+    for i := range min(int(points), 12) {
+        envelope.Points = append(envelope.Points, EnvelopePoint{
+            X: data[i*2],
+            Y: data[i*2+1],
+        })
+    }
+
+    envelope.SustainPoint = int(sustainPoint)
+    envelope.LoopStart = int(loopStart)
+    envelope.LoopEnd = int(loopEnd)
+    envelope.Enabled = envelopeType & 1 != 0 && len(envelope.Points) > 0
+    envelope.Sustain = envelopeType & 2 != 0 && envelope.SustainPoint < len(envelope.Points)
+    envelope.Loop = envelopeType & 4 != 0 && envelope.LoopStart <= envelope.LoopEnd && envelope.LoopEnd < len(envelope.Points)
+
+    return envelope
+}
+
+const (
+    AutoVibratoSine = 0
+    AutoVibratoSquare = 1
+    AutoVibratoRampUp = 2
+    AutoVibratoRampDown = 3
+)
+
+// the vibrato that an instrument applies to every note it plays
+type AutoVibrato struct {
+    Type uint8 // one of the AutoVibrato* waveforms
+    Sweep uint8 // number of ticks for the depth to ramp up to its full value
+    Depth uint8
+    Rate uint8 // how far the waveform moves each tick, 256 is one cycle
+}
+
 type Instrument struct {
     Samples []Sample
+    // the sample to use for each note, where index 0 is C-0
+    Keymap [96]uint8
+    VolumeEnvelope Envelope
+    PanningEnvelope Envelope
+    AutoVibrato AutoVibrato
+    // how much the volume fades each tick after a key off, out of 32768
+    VolumeFadeout uint16
+}
+
+// get the sample that should play the given note, or nil if there isn't one
+func (instrument *Instrument) GetSample(note int) *Sample {
+    index := 0
+    if note >= 1 && note <= len(instrument.Keymap) {
+        index = int(instrument.Keymap[note - 1])
+    }
+
+    if index < len(instrument.Samples) {
+        return &instrument.Samples[index]
+    }
+
+    return nil
 }
 
+const (
+    SampleLoopNone = 0
+    SampleLoopForward = 1
+    SampleLoopPingPong = 2
+)
+
 type Sample struct {
     Name string
     Length uint32
@@ -151,6 +261,22 @@ type Sample struct {
     Data []float32
 }
 
+// the loop mode from the low bits of the sample type
+func (sample *Sample) LoopType() int {
+    switch sample.Type & 3 {
+        case 1: return SampleLoopForward
+        // fasttracker treats both bits set as a ping-pong loop
+        case 2, 3: return SampleLoopPingPong
+    }
+
+    return SampleLoopNone
+}
+
+// true if the sample has a loop that playback should use
+func (sample *Sample) IsLooped() bool {
+    return sample.LoopType() != SampleLoopNone && sample.LoopLength > 0
+}
+
 func (pattern *Pattern) ParseNotes() []Note {
     var notes []Note
 
@@ -431,6 +557,11 @@ func Load(reader_ io.ReadSeeker, logger *log.Logger) (*XMFile, error) {
 
     logger.Printf("Pattern Order Data: %v", orderData)
 
+    restart := int(restartPosition)
+    if restart >= len(orderData) {
+        restart = 0
+    }
+
     // pattern data
     reader_.Seek(int64(headerSize + 60), io.SeekStart)
 
@@ -476,6 +607,8 @@ func Load(reader_ io.ReadSeeker, logger *log.Logger) (*XMFile, error) {
         Channels: int(channelCount),
         BPM: bpm,
         Tempo: tempo,
+        LinearFrequency: flags & 1 == 1,
+        RestartPosition: restart,
     }, nil
 }
 
@@ -558,26 +691,29 @@ func readInstrument(logger *log.Logger, reader_ io.Reader, instrumentHeaderSize
         return nil, fmt.Errorf("Error reading sample count: %v", err)
     }
 
-    var sampleHeaderSizes []uint32
+    var sampleHeaderSize uint32
+    var keymap [96]uint8
+    var volumeEnvelope Envelope
+    var panningEnvelope Envelope
+    var autoVibrato AutoVibrato
+    var volumeFadeOut uint16
 
     logger.Printf("Sample Count: %d", samples)
-    for range samples {
-        var sampleHeaderSize uint32
+    // the rest of the instrument header is only present if the instrument has samples,
+    // and it is shared by all of them
+    if samples > 0 {
         err = binary.Read(instrumentReader, binary.LittleEndian, &sampleHeaderSize)
         if err != nil {
             return nil, fmt.Errorf("Error reading sample header size: %v", err)
         }
         logger.Printf("Sample Header Size: %d", sampleHeaderSize)
 
-        sampleHeaderSizes = append(sampleHeaderSizes, sampleHeaderSize)
-
-        keymapAssignments := make([]byte, 96)
-        _, err = io.ReadFull(instrumentReader, keymapAssignments)
+        _, err = io.ReadFull(instrumentReader, keymap[:])
         if err != nil {
             return nil, fmt.Errorf("Error reading keymap assignments: %v", err)
         }
 
-        // log.Printf("Keymap Assignments: %d", keymapAssignments)
+        // log.Printf("Keymap Assignments: %d", keymap)
 
         volumeEnvelopePoints := make([]uint16, 24)
         for i := range volumeEnvelopePoints {
@@ -662,6 +798,9 @@ func readInstrument(logger *log.Logger, reader_ io.Reader, instrumentHeaderSize
 
         logger.Printf("Panning Type: %d", panningType)
 
+        volumeEnvelope = makeEnvelope(volumeEnvelopePoints, volumePoints, volumeSustainPoint, volumeLoopStart, volumeLoopEnd, volumeType)
+        panningEnvelope = makeEnvelope(panningEnvelopePoints, panningPoints, panningSustainPoint, panningLoopStart, panningLoopEnd, panningType)
+
         vibratoType, err := instrumentReader.ReadByte()
         if err != nil {
             return nil, fmt.Errorf("Error reading vibrato type: %v", err)
@@ -688,13 +827,19 @@ func readInstrument(logger *log.Logger, reader_ io.Reader, instrumentHeaderSize
 
         logger.Printf("Vibrato Rate: %d", vibratoRate)
 
-        var volumeFadeOut uint16
+        autoVibrato = AutoVibrato{
+            Type: vibratoType & 3,
+            Sweep: vibratoSweep,
+            Depth: vibratoDepth,
+            Rate: vibratoRate,
+        }
+
         err = binary.Read(instrumentReader, binary.LittleEndian, &volumeFadeOut)
         if err != nil {
             return nil, fmt.Errorf("Error reading volume: %v", err)
         }
 
-        logger.Printf("Volume: %d", volumeFadeOut)
+        logger.Printf("Volume Fadeout: %d", volumeFadeOut)
 
         instrumentReader.Discard(22) // reserved 22 bytes
     }
@@ -710,9 +855,9 @@ func readInstrument(logger *log.Logger, reader_ io.Reader, instrumentHeaderSize
 
     var sampleData []Sample
 
-    for i := range samples {
+    for range samples {
 
-        sampleReader := bufio.NewReader(io.LimitReader(reader_, int64(sampleHeaderSizes[i])))
+        sampleReader := bufio.NewReader(io.LimitReader(reader_, int64(sampleHeaderSize)))
 
         var sampleLength uint32
         err = binary.Read(sampleReader, binary.LittleEndian, &sampleLength)
@@ -846,9 +991,27 @@ func readInstrument(logger *log.Logger, reader_ io.Reader, instrumentHeaderSize
 
         }
 
+        // loop points are stored in bytes, but playback indexes into the decoded samples
+        if !is8Bit {
+            sampleData[i].LoopStart /= 2
+            sampleData[i].LoopLength /= 2
+        }
+
+        dataLength := uint32(len(sampleData[i].Data))
+        if sampleData[i].LoopStart > dataLength {
+            sampleData[i].LoopStart = dataLength
+        }
+        if sampleData[i].LoopStart + sampleData[i].LoopLength > dataLength {
+            sampleData[i].LoopLength = dataLength - sampleData[i].LoopStart
+        }
     }
 
     return &Instrument{
         Samples: sampleData,
+        Keymap: keymap,
+        VolumeEnvelope: volumeEnvelope,
+        PanningEnvelope: panningEnvelope,
+        AutoVibrato: autoVibrato,
+        VolumeFadeout: volumeFadeOut,
     }, nil
 }
@@ -27,6 +27,7 @@ const (
     EffectSetSpeed = 15
     EffectSetGlobalVolume = 16
     EffectSetGlobalVolumeSlide = 17
+    EffectKeyOff = 20
     EffectEnvelopePosition = 21
     EffectPanningSlide = 25
     EffectMultiRetrigger = 27
@@ -49,48 +50,130 @@ const (
 )
 
 type Vibrato struct {
-    Speed int
-    Depth int
-    position int
+    common.Oscillator
 }
 
-func (vibrato *Vibrato) Update() {
-    vibrato.position += vibrato.Speed
-    if vibrato.position >= 64 {
-        vibrato.position -= 64
+// offset a period by the vibrato. the sine wave has an amplitude of 255 and the depth
+// scales it down by 32, the same in both frequency tables
+func (vibrato *Vibrato) Apply(period float32) float32 {
+    if vibrato.Depth <= 0 || vibrato.Speed <= 0 {
+        return period
     }
+
+    vibratoValue := float32(float64(vibrato.Depth * 255) / 32 * vibrato.Oscillator.Value())
+    return period + vibratoValue
 }
 
-func (vibrato *Vibrato) Apply(frequency float32) float32 {
-    if vibrato.Depth <= 0 || vibrato.Speed <= 0 {
-        return frequency
+type Tremolo struct {
+    common.Oscillator
+}
+
+func (tremolo *Tremolo) Value() float64 {
+    return float64(tremolo.Depth) / 40 * tremolo.Oscillator.Value()
+}
+
+func (tremolo *Tremolo) Apply(volume float32) float32 {
+    return volume + float32(tremolo.Value())
+}
+
+// the playback position of a channel within an instrument envelope
+type EnvelopeState struct {
+    Position int // in ticks
+}
+
+func (state *EnvelopeState) Reset() {
+    state.Position = 0
+}
+
+// advance the envelope by one tick. if the note is still held then the position stays
+// on the sustain point
+func (state *EnvelopeState) Update(envelope *Envelope, held bool) {
+    if !envelope.Enabled {
+        return
     }
 
-    // Amiga vibrato is a sine wave with a period of 64
-    // and a depth of 8, so we scale the position to that range
-    vibratoValue := float32(float64(vibrato.Depth * 40) * math.Sin(float64(vibrato.position) * math.Pi * 360 / 64 / 180))
-    return frequency + vibratoValue
+    if held && envelope.Sustain && state.Position == int(envelope.Points[envelope.SustainPoint].X) {
+        return
+    }
+
+    state.Position += 1
+
+    if envelope.Loop && state.Position >= int(envelope.Points[envelope.LoopEnd].X) {
+        state.Position = int(envelope.Points[envelope.LoopStart].X)
+    }
+
+    last := int(envelope.Points[len(envelope.Points) - 1].X)
+    if state.Position > last {
+        state.Position = last
+    }
 }
 
-type Tremolo struct {
-    Speed int
-    Depth int
-    position int
+// the envelope value at the current position, 0-64, linearly interpolated between points
+func (state *EnvelopeState) Value(envelope *Envelope) float32 {
+    points := envelope.Points
+
+    for i := range len(points) - 1 {
+        start := points[i]
+        end := points[i+1]
+        if state.Position >= int(start.X) && state.Position < int(end.X) {
+            span := float32(end.X) - float32(start.X)
+            amount := float32(state.Position - int(start.X)) / span
+            return float32(start.Y) + (float32(end.Y) - float32(start.Y)) * amount
+        }
+    }
+
+    return float32(points[len(points) - 1].Y)
+}
+
+// the playback state of an instrument's auto vibrato
+type AutoVibratoState struct {
+    Position int // 0-255 within the waveform
+    Amplitude int // the current depth, scaled by 256 so the sweep can ramp smoothly
 }
 
-func (tremolo *Tremolo) Update() {
-    tremolo.position += tremolo.Speed
-    if tremolo.position >= 64 {
-        tremolo.position -= 64
+func (state *AutoVibratoState) Reset(vibrato *AutoVibrato) {
+    state.Position = 0
+    if vibrato.Sweep > 0 {
+        state.Amplitude = 0
+    } else {
+        state.Amplitude = int(vibrato.Depth) << 8
     }
 }
 
-func (tremolo *Tremolo) Value() float64 {
-    return float64(tremolo.Depth) / 40 * math.Sin(float64(tremolo.position) * math.Pi * 360 / 64 / 180)
+// advance the waveform by one tick. the depth only ramps up while the note is held
+func (state *AutoVibratoState) Update(vibrato *AutoVibrato, held bool) {
+    state.Position = (state.Position + int(vibrato.Rate)) & 0xff
+
+    full := int(vibrato.Depth) << 8
+    if held && vibrato.Sweep > 0 && state.Amplitude < full {
+        state.Amplitude = min(full, state.Amplitude + full / int(vibrato.Sweep))
+    }
 }
 
-func (tremolo *Tremolo) Apply(volume float32) float32 {
-    return volume + float32(tremolo.Value())
+// the offset to add to the period
+func (state *AutoVibratoState) Value(vibrato *AutoVibrato) float32 {
+    if vibrato.Depth == 0 || vibrato.Rate == 0 {
+        return 0
+    }
+
+    // the waveforms range from -64 to 64
+    var wave float32
+    switch vibrato.Type {
+        case AutoVibratoSine:
+            wave = float32(64 * math.Sin(float64(state.Position) * 2 * math.Pi / 256))
+        case AutoVibratoSquare:
+            if state.Position > 127 {
+                wave = 64
+            } else {
+                wave = -64
+            }
+        case AutoVibratoRampUp:
+            wave = float32(((state.Position >> 1) + 64) & 127 - 64)
+        case AutoVibratoRampDown:
+            wave = float32((64 - (state.Position >> 1)) & 127 - 64)
+    }
+
+    return wave * float32(state.Amplitude) / (64 * 256)
 }
 
 type Channel struct {
@@ -104,43 +187,232 @@ type Channel struct {
     Mute bool
 
     startPosition float32
+    // true while a ping-pong loop is playing the sample in reverse
+    playBackwards bool
 
     CurrentEffect int
     CurrentEffectParameter int // The parameter of the current effect
     CurrentVolume float32 // The volume of the current note
     LastVolume float32
     CurrentNote float32
+    CurrentPeriod float32 // the pitch of the note after slides, in the units of the song's frequency table
     CurrentInstrument int
+    CurrentSample *Sample // the sample of the current instrument that the note maps to
+    FineTune int // the finetune of the current sample, unless E5x changed it
 
-    PortamentoTarget float32
+    PortamentoTarget float32 // a period
+    // if true then tone portamento moves in whole semitones
+    Glissando bool
+    TonePortamentoSpeed int
     VolumeSlide int
 
-    RetriggerValue int
+    // effect memory, an effect with a parameter of 0 reuses the last non-zero parameter
+    PortamentoUpSpeed int
+    PortamentoDownSpeed int
+    FinePortamentoUpSpeed int
+    FinePortamentoDownSpeed int
+    ExtraFinePortamentoUpSpeed int
+    ExtraFinePortamentoDownSpeed int
+    FineVolumeSlideUp int
+    FineVolumeSlideDown int
+    GlobalVolumeSlide int
+    SampleOffset int
+
+    RetriggerValue int // Rxy, x is the volume change and y is the interval in ticks
     RetriggerCount int
 
+    Tremor int // Txy, on for x+1 ticks and off for y+1 ticks
+    TremorCount int
+    TremorOff bool // true while tremor has muted the note
+
+    ArpeggioValue int
+    ArpeggioOffset int // semitones added to the note on the current tick
+
     Vibrato Vibrato
     Tremolo Tremolo
+
+    Panning int // 0-255, 0 is left, 128 is center, 255 is right
+    PanningSlide int
+    // the volume column byte, if it holds a command that runs on every tick
+    VolumeCommand uint8
+
+    VolumeEnvelope EnvelopeState
+    PanningEnvelope EnvelopeState
+    AutoVibrato AutoVibratoState
+    // true once the note has been released, which lets envelopes move past their sustain point
+    KeyOff bool
+    // the instrument fadeout that starts at key off, 1 is full volume
+    FadeoutVolume float32
+    KeyOffTick int // the tick of the row that the Kxx effect releases the note on
+    // a note held back by EDx, played once the delay tick is reached
+    DelayedNote *Note
+}
+
+// the panning of the channel, 0 is full left, 0.5 is center, 1 is full right
+func (channel *Channel) getPanning() float32 {
+    pan := float32(channel.Panning) / 255
+
+    instrument := channel.player.GetInstrument(channel.CurrentInstrument)
+    if instrument != nil && instrument.PanningEnvelope.Enabled {
+        // envelope value 32 is center. the envelope can only move the pan as far as the
+        // nearest edge allows
+        envelope := (channel.PanningEnvelope.Value(&instrument.PanningEnvelope) - 32) / 32
+        pan += envelope * (0.5 - float32(math.Abs(float64(pan - 0.5))))
+    }
+
+    return pan
 }
 
 func (channel *Channel) GetLeftPan() float32 {
-    // FIXME
-    return 0.5
+    left, _ := channel.player.PanLaw.Gains(channel.getPanning())
+    return left
 }
 
 func (channel *Channel) GetRightPan() float32 {
-    // FIXME
-    return 0.5
+    _, right := channel.player.PanLaw.Gains(channel.getPanning())
+    return right
+}
+
+// move the pitch by the given number of period units, negative is a higher pitch
+func (channel *Channel) slidePeriod(amount float32) {
+    channel.CurrentPeriod = max(1, channel.CurrentPeriod + amount)
+}
+
+func (channel *Channel) slidePanning(amount int) {
+    channel.Panning = max(0, min(255, channel.Panning + amount))
+}
+
+func (channel *Channel) slideVolume(amount float32) {
+    channel.CurrentVolume = max(0, min(64, channel.CurrentVolume + amount))
+}
+
+// true if tone portamento is active from either the effect or the volume column
+func (channel *Channel) isTonePortamento() bool {
+    return channel.CurrentEffect == EffectTonePortamento || channel.CurrentEffect == EffectTonePortamentoVolumeSlide || channel.VolumeCommand >> 4 == 0xf
+}
+
+// true if vibrato is active from either the effect or the volume column
+func (channel *Channel) isVibrato() bool {
+    return channel.CurrentEffect == EffectVibrato || channel.CurrentEffect == EffectVibratoVolumeSlide || channel.VolumeCommand >> 4 == 0xb
+}
+
+// the volume column commands that take effect on the first tick of the row
+func (channel *Channel) doVolumeCommandRow(command uint8) {
+    amount := int(command & 0xf)
+
+    switch command >> 4 {
+        // fine volume slide down
+        case 0x8:
+            channel.slideVolume(float32(-amount))
+        // fine volume slide up
+        case 0x9:
+            channel.slideVolume(float32(amount))
+        // set vibrato speed, but vibrato is not started
+        case 0xa:
+            if amount > 0 {
+                channel.Vibrato.Speed = amount
+            }
+        // vibrato with the given depth
+        case 0xb:
+            if amount > 0 {
+                channel.Vibrato.Depth = amount
+            }
+        case 0xc:
+            channel.Panning = amount << 4
+        // tone portamento, the speed is the parameter * 16
+        case 0xf:
+            if amount > 0 {
+                channel.TonePortamentoSpeed = amount << 4
+            }
+    }
+}
+
+// the volume column commands that act on every tick except the first
+func (channel *Channel) doVolumeCommand() {
+    amount := int(channel.VolumeCommand & 0xf)
+
+    switch channel.VolumeCommand >> 4 {
+        case 0x6:
+            channel.slideVolume(float32(-amount))
+        case 0x7:
+            channel.slideVolume(float32(amount))
+        case 0xb:
+            channel.Vibrato.Update()
+        case 0xd:
+            channel.slidePanning(-amount)
+        case 0xe:
+            channel.slidePanning(amount)
+        case 0xf:
+            channel.doTonePortamento()
+    }
+}
+
+// restart the instrument envelopes and auto vibrato, used when a new note is played
+func (channel *Channel) resetEnvelopes() {
+    channel.VolumeEnvelope.Reset()
+    channel.PanningEnvelope.Reset()
+    channel.KeyOff = false
+    channel.FadeoutVolume = 1
+
+    instrument := channel.player.GetInstrument(channel.CurrentInstrument)
+    if instrument != nil {
+        channel.AutoVibrato.Reset(&instrument.AutoVibrato)
+    }
+}
+
+// release the note. the envelopes continue past their sustain point and the instrument
+// starts to fade out. without a volume envelope the note is cut straight away
+func (channel *Channel) keyOff() {
+    channel.KeyOff = true
+
+    instrument := channel.player.GetInstrument(channel.CurrentInstrument)
+    if instrument == nil || !instrument.VolumeEnvelope.Enabled {
+        channel.CurrentVolume = 0
+    }
+}
+
+func (channel *Channel) updateEnvelopes() {
+    instrument := channel.player.GetInstrument(channel.CurrentInstrument)
+    if instrument == nil {
+        return
+    }
+
+    channel.VolumeEnvelope.Update(&instrument.VolumeEnvelope, !channel.KeyOff)
+    channel.PanningEnvelope.Update(&instrument.PanningEnvelope, !channel.KeyOff)
+    channel.AutoVibrato.Update(&instrument.AutoVibrato, !channel.KeyOff)
+
+    if channel.KeyOff {
+        channel.FadeoutVolume = max(0, channel.FadeoutVolume - float32(instrument.VolumeFadeout) / 32768)
+    }
 }
 
 func (channel *Channel) UpdateRow() {
     channel.currentRow = channel.player.CurrentRow
+    channel.DelayedNote = nil
 
     note, ok := channel.player.GetRowNote(channel.Channel, channel.currentRow)
     if !ok {
         return
     }
 
+    // EDx holds back the whole row for this channel until tick x
+    if note.HasEffectType && note.EffectType == EffectExtended && note.EffectParameter >> 4 == ExtendedEffectNoteDelay && note.EffectParameter & 0xf > 0 {
+        channel.DelayedNote = note
+        channel.CurrentEffect = EffectExtended
+        channel.CurrentEffectParameter = int(note.EffectParameter)
+        channel.VolumeCommand = 0
+        return
+    }
+
+    channel.playNote(note)
+}
+
+func (channel *Channel) playNote(note *Note) {
     resetStartingPosition := false
+    // set by E5x, overrides the finetune of the sample
+    fineTune := -1
+    // set by 9xx, where the new note starts playing
+    sampleOffset := 0
 
     newNote := channel.CurrentNote
     newInstrument := channel.CurrentInstrument
@@ -153,11 +425,10 @@ func (channel *Channel) UpdateRow() {
     }
     */
 
-    if note.HasNote {
+    keyOff := note.HasNote && note.NoteNumber == NoteKeyOff
+
+    if note.HasNote && !keyOff {
         newNote = float32(note.NoteNumber)
-        if note.NoteNumber == 97 {
-            newNote = 0 // No note
-        }
         resetStartingPosition = true
         channel.CurrentVolume = channel.LastVolume
     }
@@ -165,13 +436,52 @@ func (channel *Channel) UpdateRow() {
     if note.HasInstrument {
         newInstrument = int(note.Instrument - 1)
         channel.LastVolume = 64
+
+        // the default volume and panning come from whichever sample the note maps to
+        keyNote := int(channel.CurrentNote)
+        if note.HasNote && !keyOff {
+            keyNote = int(note.NoteNumber)
+        }
+        instrument := channel.player.GetInstrument(newInstrument)
+        if instrument != nil {
+            sample := instrument.GetSample(keyNote)
+            if sample != nil {
+                channel.LastVolume = float32(min(sample.Volume, 64))
+                channel.Panning = int(sample.Panning)
+            }
+        }
+
         channel.CurrentVolume = channel.LastVolume
         // log.Printf("Set instrument to %v", channel.CurrentInstrument)
     }
 
+    channel.VolumeCommand = 0
+
     if note.HasVolume {
-        channel.LastVolume = float32(note.Volume) - 16
-        channel.CurrentVolume = channel.LastVolume
+        if note.Volume >= 0x10 && note.Volume <= 0x50 {
+            channel.LastVolume = float32(note.Volume) - 16
+            channel.CurrentVolume = channel.LastVolume
+        } else if note.Volume >= 0x60 {
+            channel.VolumeCommand = note.Volume
+            channel.doVolumeCommandRow(note.Volume)
+        }
+    }
+
+    // tone portamento can come from either the effect or the volume column. the note
+    // becomes the target of the slide instead of being played
+    if (note.HasEffectType && (note.EffectType == EffectTonePortamento || note.EffectType == EffectTonePortamentoVolumeSlide)) || channel.VolumeCommand >> 4 == 0xf {
+        if note.HasNote && !keyOff {
+            instrument := channel.player.GetInstrument(channel.CurrentInstrument)
+            if instrument != nil {
+                sample := instrument.GetSample(int(note.NoteNumber))
+                if sample != nil {
+                    channel.PortamentoTarget = channel.player.NotePeriod(float32(note.NoteNumber), int(sample.RelativeNoteNumber), int(sample.FineTune))
+                }
+            }
+        }
+        newNote = channel.CurrentNote
+        newInstrument = channel.CurrentInstrument
+        resetStartingPosition = false
     }
 
     if note.HasEffectType {
@@ -185,18 +495,65 @@ func (channel *Channel) UpdateRow() {
                 if channel.player.OnChangeSpeed != nil {
                     channel.player.OnChangeSpeed(channel.player.Speed, channel.player.BPM)
                 }
+            case EffectArpeggio:
+                if note.EffectParameter > 0 {
+                    channel.CurrentEffect = EffectArpeggio
+                    channel.ArpeggioValue = int(note.EffectParameter)
+                }
             case EffectPortamentoUp:
                 channel.CurrentEffect = EffectPortamentoUp
                 if note.EffectParameter > 0 {
-                    channel.CurrentEffectParameter = int(note.EffectParameter)
+                    channel.PortamentoUpSpeed = int(note.EffectParameter)
                 }
             case EffectPortamentoDown:
                 channel.CurrentEffect = EffectPortamentoDown
                 if note.EffectParameter > 0 {
-                    channel.CurrentEffectParameter = int(note.EffectParameter)
+                    channel.PortamentoDownSpeed = int(note.EffectParameter)
+                }
+            case EffectTonePortamentoVolumeSlide:
+                channel.CurrentEffect = EffectTonePortamentoVolumeSlide
+                if note.EffectParameter > 0 {
+                    channel.VolumeSlide = int(note.EffectParameter)
                 }
+            case EffectSetVolume:
+                channel.LastVolume = float32(min(note.EffectParameter, 64))
+                channel.CurrentVolume = channel.LastVolume
+            case EffectPositionJump:
+                channel.player.DoJump = true
+                channel.player.JumpOrder = int(note.EffectParameter)
             case EffectSetGlobalVolume:
-                channel.player.GlobalVolume = int(note.EffectParameter)
+                channel.player.GlobalVolume = min(int(note.EffectParameter), 64)
+            case EffectSetGlobalVolumeSlide:
+                channel.CurrentEffect = EffectSetGlobalVolumeSlide
+                if note.EffectParameter > 0 {
+                    channel.GlobalVolumeSlide = int(note.EffectParameter)
+                }
+            case EffectTremor:
+                channel.CurrentEffect = EffectTremor
+                if note.EffectParameter > 0 {
+                    channel.Tremor = int(note.EffectParameter)
+                }
+            case EffectExtraFinePortamento:
+                value := int(note.EffectParameter & 0xf)
+                switch note.EffectParameter >> 4 {
+                    case 1:
+                        if value > 0 {
+                            channel.ExtraFinePortamentoUpSpeed = value
+                        }
+                        channel.slidePeriod(-float32(channel.ExtraFinePortamentoUpSpeed))
+                    case 2:
+                        if value > 0 {
+                            channel.ExtraFinePortamentoDownSpeed = value
+                        }
+                        channel.slidePeriod(float32(channel.ExtraFinePortamentoDownSpeed))
+                }
+            case EffectSetPanning:
+                channel.Panning = int(note.EffectParameter)
+            case EffectPanningSlide:
+                channel.CurrentEffect = EffectPanningSlide
+                if note.EffectParameter > 0 {
+                    channel.PanningSlide = int(note.EffectParameter)
+                }
             case EffectVolumeSlide:
                 channel.CurrentEffect = EffectVolumeSlide
                 if note.EffectParameter > 0 {
@@ -225,14 +582,8 @@ func (channel *Channel) UpdateRow() {
             case EffectTonePortamento:
                 channel.CurrentEffect = EffectTonePortamento
                 if note.EffectParameter > 0 {
-                    channel.CurrentEffectParameter = int(note.EffectParameter)
-                }
-                if note.HasNote {
-                    channel.PortamentoTarget = float32(note.NoteNumber)
+                    channel.TonePortamentoSpeed = int(note.EffectParameter)
                 }
-                newNote = channel.CurrentNote
-                newInstrument = channel.CurrentInstrument
-                resetStartingPosition = false
             case EffectVibrato:
                 channel.CurrentEffect = EffectVibrato
                 if note.EffectParameter > 0 {
@@ -247,39 +598,70 @@ func (channel *Channel) UpdateRow() {
                 }
             case EffectMultiRetrigger:
                 channel.CurrentEffect = EffectMultiRetrigger
-                if note.EffectParameter > 0 {
-                    channel.RetriggerValue = int(note.EffectParameter)
+                // each half of the parameter has its own memory
+                if note.EffectParameter >> 4 > 0 {
+                    channel.RetriggerValue = int(note.EffectParameter & 0xf0) | (channel.RetriggerValue & 0xf)
+                }
+                if note.EffectParameter & 0xf > 0 {
+                    channel.RetriggerValue = (channel.RetriggerValue & 0xf0) | int(note.EffectParameter & 0xf)
+                }
+                if note.HasNote {
+                    channel.RetriggerCount = 0
                 }
-                channel.RetriggerCount = 0
             case EffectSetSampleOffset:
-                channel.startPosition = float32(note.EffectParameter) * 0x100
+                if note.EffectParameter > 0 {
+                    channel.SampleOffset = int(note.EffectParameter)
+                }
+                sampleOffset = channel.SampleOffset * 0x100
+            case EffectKeyOff:
+                channel.CurrentEffect = EffectKeyOff
+                channel.KeyOffTick = int(note.EffectParameter)
+            case EffectEnvelopePosition:
+                channel.VolumeEnvelope.Position = int(note.EffectParameter)
+                channel.PanningEnvelope.Position = int(note.EffectParameter)
             case EffectExtended:
                 channel.CurrentEffect = EffectExtended
                 channel.CurrentEffectParameter = int(note.EffectParameter)
                 // log.Printf("Channel %v: Extended effect %v with parameter %v", channel.Channel, note.EffectParameter >> 4, note.EffectParameter & 0x0F)
 
+                value := int(note.EffectParameter & 0xf)
+
                 switch note.EffectParameter >> 4 {
                     case ExtendedEffectFinePortamentoUp:
                         resetStartingPosition = false
+                        if value > 0 {
+                            channel.FinePortamentoUpSpeed = value
+                        }
                     case ExtendedEffectFinePortamentoDown:
                         resetStartingPosition = false
+                        if value > 0 {
+                            channel.FinePortamentoDownSpeed = value
+                        }
                     case ExtendedEffectFineVolumeSlideUp:
+                        if value > 0 {
+                            channel.FineVolumeSlideUp = value
+                        }
                     case ExtendedEffectFineVolumeSlideDown:
-
-                    /*
-                    case ExtendedEffectFinePortamentoDown:
+                        if value > 0 {
+                            channel.FineVolumeSlideDown = value
+                        }
                     case ExtendedEffectGlissandoControl:
+                        channel.Glissando = value != 0
                     case ExtendedEffectVibratoControl:
+                        channel.Vibrato.SetControl(value)
+                    case ExtendedEffectTremoloControl:
+                        channel.Tremolo.SetControl(value)
                     case ExtendedEffectSetFinetune:
+                        fineTune = value * 16 - 128
                     case ExtendedEffectSetLoop:
-                    case ExtendedEffectTremoloControl:
+                        channel.player.doPatternLoop(value)
                     case ExtendedEffectRetriggerNote:
-                    case ExtendedEffectFineVolumeSlideUp:
-                    case ExtendedEffectFineVolumeSlideDown:
                     case ExtendedEffectNoteCut:
                     case ExtendedEffectNoteDelay:
                     case ExtendedEffectPatternDelay:
-                    */
+                        if channel.player.PatternDelay == 0 {
+                            channel.player.PatternDelay = value
+                        }
                     default: log.Printf("Channel %v: Unknown extended effect 0x%x", channel.Channel, note.EffectParameter >> 4)
                 }
 
@@ -291,108 +673,287 @@ func (channel *Channel) UpdateRow() {
 
     if newInstrument != channel.CurrentInstrument || resetStartingPosition {
         channel.startPosition = 0
+        channel.playBackwards = false
+
+        channel.CurrentSample = nil
+        instrument := channel.player.GetInstrument(newInstrument)
+        if instrument != nil {
+            channel.CurrentSample = instrument.GetSample(int(newNote))
+        }
+
+        if channel.CurrentSample != nil {
+            channel.FineTune = int(channel.CurrentSample.FineTune)
+        }
+    }
+
+    if resetStartingPosition {
+        if fineTune != -1 {
+            channel.FineTune = fineTune
+        }
+
+        if channel.CurrentSample != nil && newNote > 0 {
+            channel.CurrentPeriod = channel.player.NotePeriod(newNote, int(channel.CurrentSample.RelativeNoteNumber), channel.FineTune)
+        }
+
+        channel.startPosition = float32(sampleOffset)
+        // an offset past the end of the sample stops the note, even if it loops
+        if channel.CurrentSample != nil && sampleOffset >= len(channel.CurrentSample.Data) {
+            channel.CurrentVolume = 0
+        }
+
+        channel.TremorCount = 0
+        channel.TremorOff = false
+
+        channel.Vibrato.Retrigger()
+        channel.Tremolo.Retrigger()
     }
 
     channel.CurrentNote = newNote
     channel.CurrentInstrument = newInstrument
+
+    // a new note or an instrument number restarts the envelopes, but not if Lxx just moved them
+    if (resetStartingPosition || note.HasInstrument) && !keyOff && !(note.HasEffectType && note.EffectType == EffectEnvelopePosition) {
+        channel.resetEnvelopes()
+    }
+
+    if keyOff {
+        channel.keyOff()
+    }
 }
 
+// Axy slides the volume up by x or down by y on every tick but the first
 func (channel *Channel) doVolumeSlide() {
-    volumeAmount := 0
+    if channel.VolumeSlide >> 4 > 0 {
+        channel.slideVolume(float32(channel.VolumeSlide >> 4))
+    } else {
+        channel.slideVolume(-float32(channel.VolumeSlide & 0xf))
+    }
+}
 
-    slideUp := channel.VolumeSlide >> 4
-    slideDown := channel.VolumeSlide & 0xf
+// Hxy is the same as Axy but for the global volume
+func (channel *Channel) doGlobalVolumeSlide() {
+    player := channel.player
+    if channel.GlobalVolumeSlide >> 4 > 0 {
+        player.GlobalVolume = min(64, player.GlobalVolume + channel.GlobalVolumeSlide >> 4)
+    } else {
+        player.GlobalVolume = max(0, player.GlobalVolume - channel.GlobalVolumeSlide & 0xf)
+    }
+}
 
-    if slideUp == 0xf {
-        volumeAmount = -slideDown
-        if slideDown == 0xf {
-            volumeAmount = slideUp
-        }
-    } else if slideDown == 0xf {
-        if slideUp > 0 {
-            volumeAmount = slideUp
-        } else {
-            volumeAmount = -slideDown
-        }
-    } else if slideUp > 0 {
-        // FIXME: implement fast volume slides
-        // volumeAmount = slideUp * (channel.Player.Speed - 1)
-        volumeAmount = slideUp
-    } else if slideDown > 0 {
-        // volumeAmount = -slideDown * (channel.Player.Speed - 1)
-        volumeAmount = -slideDown
+// advance Rxy by one tick, retriggering the note and changing its volume every y ticks
+func (channel *Channel) doMultiRetrigger() {
+    interval := channel.RetriggerValue & 0xf
+    if interval == 0 {
+        return
     }
 
-    channel.CurrentVolume += float32(volumeAmount)
-    if channel.CurrentVolume < 0 {
-        channel.CurrentVolume = 0
+    channel.RetriggerCount += 1
+    if channel.RetriggerCount < interval {
+        return
+    }
+    channel.RetriggerCount = 0
+
+    volume := channel.CurrentVolume
+    switch channel.RetriggerValue >> 4 {
+        case 0x1: volume -= 1
+        case 0x2: volume -= 2
+        case 0x3: volume -= 4
+        case 0x4: volume -= 8
+        case 0x5: volume -= 16
+        case 0x6: volume = volume * 2 / 3
+        case 0x7: volume = volume / 2
+        case 0x9: volume += 1
+        case 0xa: volume += 2
+        case 0xb: volume += 4
+        case 0xc: volume += 8
+        case 0xd: volume += 16
+        case 0xe: volume = volume * 3 / 2
+        case 0xf: volume = volume * 2
+    }
+    channel.CurrentVolume = max(0, min(64, volume))
+
+    channel.retrigger()
+}
+
+// advance Txy by one tick
+func (channel *Channel) doTremor() {
+    on := channel.Tremor >> 4 + 1
+    off := channel.Tremor & 0xf + 1
+
+    channel.TremorCount = (channel.TremorCount + 1) % (on + off)
+    channel.TremorOff = channel.TremorCount >= on
+}
+
+// portamento parameters move the period by 4 units per step in both frequency tables
+const portamentoSlide = 4
+
+func (channel *Channel) doTonePortamento() {
+    if channel.PortamentoTarget <= 0 {
+        return
     }
-    if channel.CurrentVolume > 64 {
-        channel.CurrentVolume = 64
+
+    speed := float32(channel.TonePortamentoSpeed * portamentoSlide)
+
+    if channel.PortamentoTarget > channel.CurrentPeriod {
+        channel.CurrentPeriod = min(channel.CurrentPeriod + speed, channel.PortamentoTarget)
+    } else if channel.PortamentoTarget < channel.CurrentPeriod {
+        channel.CurrentPeriod = max(channel.CurrentPeriod - speed, channel.PortamentoTarget)
     }
+
+    // log.Printf("Channel %v: Portamento target %v, current %v", channel.Channel, channel.PortamentoTarget, channel.CurrentPeriod)
 }
 
 func (channel *Channel) UpdateTick(changeRow bool, ticks int) {
-    const portamentoSlide = 10.2
+    // the tick within the current row
+    tick := int(channel.player.ticks)
+
+    channel.updateEnvelopes()
+
+    if !changeRow {
+        channel.doVolumeCommand()
+    }
+
+    channel.ArpeggioOffset = 0
 
     switch channel.CurrentEffect {
+        case EffectArpeggio:
+            switch tick % 3 {
+                case 1: channel.ArpeggioOffset = channel.ArpeggioValue >> 4
+                case 2: channel.ArpeggioOffset = channel.ArpeggioValue & 0xf
+            }
         case EffectMultiRetrigger:
-            channel.RetriggerCount += ticks
-            if channel.RetriggerCount >= channel.RetriggerValue {
-                channel.startPosition = 0
-                channel.RetriggerCount -= channel.RetriggerValue
+            if !changeRow {
+                channel.doMultiRetrigger()
+            }
+        case EffectSetGlobalVolumeSlide:
+            if !changeRow {
+                channel.doGlobalVolumeSlide()
+            }
+        case EffectTremor:
+            if !changeRow {
+                channel.doTremor()
+            }
+        case EffectTonePortamentoVolumeSlide:
+            if !changeRow {
+                channel.doTonePortamento()
+                channel.doVolumeSlide()
+            }
+        case EffectKeyOff:
+            if int(channel.player.ticks) >= channel.KeyOffTick {
+                channel.keyOff()
+                channel.CurrentEffect = -1
             }
         case EffectVolumeSlide:
-            channel.doVolumeSlide()
+            if !changeRow {
+                channel.doVolumeSlide()
+            }
         case EffectVibrato:
             channel.Vibrato.Update()
         case EffectVibratoVolumeSlide:
-            channel.doVolumeSlide()
+            if !changeRow {
+                channel.doVolumeSlide()
+            }
             channel.Vibrato.Update()
         case EffectTremolo:
             channel.Tremolo.Update()
+        case EffectPanningSlide:
+            if !changeRow {
+                // the high nibble slides right, otherwise the low nibble slides left
+                if channel.PanningSlide >> 4 > 0 {
+                    channel.slidePanning(channel.PanningSlide >> 4)
+                } else {
+                    channel.slidePanning(-(channel.PanningSlide & 0xf))
+                }
+            }
         case EffectPortamentoUp:
-            channel.CurrentNote += float32(channel.CurrentEffectParameter) / portamentoSlide
+            if !changeRow {
+                channel.slidePeriod(-float32(channel.PortamentoUpSpeed * portamentoSlide))
+            }
         case EffectPortamentoDown:
-            channel.CurrentNote -= float32(channel.CurrentEffectParameter) / portamentoSlide
+            if !changeRow {
+                channel.slidePeriod(float32(channel.PortamentoDownSpeed * portamentoSlide))
+            }
         case EffectTonePortamento:
-            if channel.PortamentoTarget > channel.CurrentNote {
-                channel.CurrentNote += float32(channel.CurrentEffectParameter) / portamentoSlide
-                if channel.CurrentNote > channel.PortamentoTarget {
-                    channel.CurrentNote = channel.PortamentoTarget
-                }
-            } else {
-                if channel.PortamentoTarget < channel.CurrentNote {
-                    channel.CurrentNote -= float32(channel.CurrentEffectParameter) / portamentoSlide
-
-                    if channel.CurrentNote < channel.PortamentoTarget {
-                        channel.CurrentNote = channel.PortamentoTarget
-                    }
-                }
+            if !changeRow {
+                channel.doTonePortamento()
             }
-
-            // log.Printf("Channel %v: Portamento target %v, current %v", channel.Channel, channel.PortamentoTarget, channel.CurrentNote)
         case EffectExtended:
             switch channel.CurrentEffectParameter >> 4 {
                 case ExtendedEffectFinePortamentoUp:
-                    channel.CurrentNote += float32(channel.CurrentEffectParameter & 0x0F) / portamentoSlide
+                    if changeRow {
+                        channel.slidePeriod(-float32(channel.FinePortamentoUpSpeed * portamentoSlide))
+                    }
                 case ExtendedEffectFinePortamentoDown:
-                    channel.CurrentNote -= float32(channel.CurrentEffectParameter & 0x0F) / portamentoSlide
+                    if changeRow {
+                        channel.slidePeriod(float32(channel.FinePortamentoDownSpeed * portamentoSlide))
+                    }
                     // log.Printf("Channel fine tune %v", channel.Finetune)
                 case ExtendedEffectFineVolumeSlideUp:
-                    channel.CurrentVolume += float32(channel.CurrentEffectParameter & 0x0F) / 8
-                    if channel.CurrentVolume > 64 {
-                        channel.CurrentVolume = 64
+                    if changeRow {
+                        channel.slideVolume(float32(channel.FineVolumeSlideUp))
                     }
                 case ExtendedEffectFineVolumeSlideDown:
-                    channel.CurrentVolume -= float32(channel.CurrentEffectParameter & 0x0F) / 8
-                    if channel.CurrentVolume < 0 {
+                    if changeRow {
+                        channel.slideVolume(-float32(channel.FineVolumeSlideDown))
+                    }
+                case ExtendedEffectRetriggerNote:
+                    value := channel.CurrentEffectParameter & 0xf
+                    if tick > 0 && value > 0 && tick % value == 0 {
+                        channel.retrigger()
+                    }
+                case ExtendedEffectNoteCut:
+                    if tick == channel.CurrentEffectParameter & 0xf {
                         channel.CurrentVolume = 0
                     }
+                case ExtendedEffectNoteDelay:
+                    if channel.DelayedNote != nil && tick >= channel.CurrentEffectParameter & 0xf {
+                        note := channel.DelayedNote
+                        channel.DelayedNote = nil
+                        channel.playNote(note)
+                    }
             }
     }
 }
 
+// restart the sample and envelopes of the current note
+func (channel *Channel) retrigger() {
+    channel.startPosition = 0
+    channel.playBackwards = false
+    channel.resetEnvelopes()
+}
+
+// keep the playback position inside the sample, following its loop. returns false once
+// the sample has played to the end
+func (channel *Channel) wrapPosition(sample *Sample) bool {
+    if !sample.IsLooped() {
+        return channel.startPosition >= 0 && int(channel.startPosition) < len(sample.Data)
+    }
+
+    loopStart := float32(sample.LoopStart)
+    loopEnd := float32(sample.LoopStart + sample.LoopLength)
+    loopLength := float32(sample.LoopLength)
+
+    switch sample.LoopType() {
+        case SampleLoopForward:
+            if channel.startPosition >= loopEnd {
+                channel.startPosition = loopStart + float32(math.Mod(float64(channel.startPosition - loopEnd), float64(loopLength)))
+            }
+        case SampleLoopPingPong:
+            // bounce off either end of the loop, reflecting however far the position overshot
+            for channel.startPosition >= loopEnd || (channel.playBackwards && channel.startPosition < loopStart) {
+                if channel.startPosition >= loopEnd {
+                    channel.startPosition = max(loopStart, loopEnd - (channel.startPosition - loopEnd) - 1)
+                    channel.playBackwards = true
+                } else {
+                    channel.startPosition = min(loopEnd - 1, loopStart + (loopStart - channel.startPosition))
+                    channel.playBackwards = false
+                }
+            }
+    }
+
+    return channel.startPosition >= 0 && int(channel.startPosition) < len(sample.Data)
+}
+
 func (channel *Channel) Update(rate float32) {
     samples := int(float32(channel.player.SampleRate) * rate)
     samplesWritten := 0
@@ -403,29 +964,30 @@ func (channel *Channel) Update(rate float32) {
     // if channel.CurrentNote != nil && int(channel.startPosition) < len(channel.CurrentSample.Data) && channel.CurrentFrequency > 0 && channel.Delay <= 0 {
     if channel.CurrentInstrument >= 0 && channel.CurrentNote > 0 {
         instrument := channel.player.GetInstrument(channel.CurrentInstrument)
-        if len(instrument.Samples) > 0 {
-            sampleObject := &instrument.Samples[0]
+        if instrument != nil && channel.CurrentSample != nil {
+            sampleObject := channel.CurrentSample
 
 
-            /*
-            if channel.CurrentEffect == EffectVibrato || channel.CurrentEffect == EffectVibratoAndVolumeSlide {
-                period = channel.Vibrato.Apply(period)
+            period := channel.CurrentPeriod
+
+            // glissando plays the slide in semitone steps, but the slide itself is unchanged
+            if channel.Glissando && channel.isTonePortamento() {
+                relativeNote := int(channel.CurrentSample.RelativeNoteNumber)
+                note := float32(math.Round(float64(channel.player.PeriodNote(period, relativeNote, channel.FineTune))))
+                period = channel.player.NotePeriod(note, relativeNote, channel.FineTune)
             }
-            */
 
-            period := 10 * 12 * 16 * 4 - (channel.CurrentNote + float32(sampleObject.RelativeNoteNumber) - 1) * 16 * 4 - float32(sampleObject.FineTune)/2
-            frequency := float32(8373 * math.Pow(2, float64(6 * 12 * 16 * 4 - period) / (12 * 16 * 4)))
+            if channel.ArpeggioOffset != 0 {
+                period = channel.player.ShiftPeriod(period, channel.ArpeggioOffset)
+            }
 
-            if channel.CurrentEffect == EffectVibrato {
-                // log.Printf("Channel %v: Vibrato applied to frequency %v: %v", channel.Channel, frequency, channel.Vibrato.Apply(frequency))
-                frequency = channel.Vibrato.Apply(frequency)
+            if channel.isVibrato() {
+                period = channel.Vibrato.Apply(period)
             }
 
-            // frequency := float32(8373 * 1712) / float32(channel.CurrentPeriod)
-            // frequency := amigaFrequency / float32(period * 2)
+            period += channel.AutoVibrato.Value(&instrument.AutoVibrato)
 
-            // ???
-            // frequency /= 2
+            frequency := channel.player.PeriodFrequency(period)
 
             // log.Printf("Channel %v: Note %v, Period %v, Frequency %v, Finetune %v RelativeNote %v", channel.Channel, channel.CurrentNote, period, frequency, sampleObject.FineTune, sampleObject.RelativeNoteNumber)
 
@@ -440,6 +1002,16 @@ func (channel *Channel) Update(rate float32) {
 
             // log.Printf("Write sample %v at %v/%v samples %v rate %v", channel.CurrentSample.Name, channel.startPosition, len(channel.CurrentSample.Data), samples, incrementRate)
 
+            if instrument.VolumeEnvelope.Enabled {
+                noteVolume *= channel.VolumeEnvelope.Value(&instrument.VolumeEnvelope) / 64
+            }
+
+            noteVolume *= channel.FadeoutVolume
+
+            if channel.CurrentEffect == EffectTremor && channel.TremorOff {
+                noteVolume = 0
+            }
+
             if incrementRate > 0 {
                 volume := channel.Volume * noteVolume * float32(channel.player.GlobalVolume) / 64
 
@@ -447,29 +1019,17 @@ func (channel *Channel) Update(rate float32) {
                     volume = channel.Tremolo.Apply(volume)
                 }
 
-                // log.Printf("Channel %v: Write sample %v at %v/%v samples %v rate %v volume %v", channel.Channel, instrument.Samples[0].Name, channel.startPosition, len(instrument.Samples[0].Data), samples, incrementRate, volume)
+                // log.Printf("Channel %v: Write sample %v at %v/%v samples %v rate %v volume %v", channel.Channel, sampleObject.Name, channel.startPosition, len(sampleObject.Data), samples, incrementRate, volume)
                 for range samples {
-                    position := int(channel.startPosition)
-                    /*
-                    if position >= len(channel.CurrentSample.Data) {
+                    if !channel.wrapPosition(sampleObject) {
                         break
                     }
-                    */
-
 
-                    if position >= len(sampleObject.Data) || (sampleObject.LoopLength > 0 && position >= int(sampleObject.LoopStart + sampleObject.LoopLength)) {
-                        // log.Printf("Position %v loop begin %v loop end %v", position, instrument.LoopBegin, instrument.LoopEnd)
-                        if sampleObject.LoopLength > 0 && position >= int(sampleObject.LoopStart + sampleObject.LoopLength) {
-                            channel.startPosition = float32(sampleObject.LoopStart)
-                            position = int(channel.startPosition)
-                        } else {
-                            break
-                        }
-                    }
+                    position := int(channel.startPosition)
 
                     // noteVolume = 1
 
-                    sample := instrument.Samples[0].Data[position] * volume
+                    sample := sampleObject.Data[position] * volume
 
                     // log.Printf("Sample %v", sample)
 
@@ -479,7 +1039,11 @@ func (channel *Channel) Update(rate float32) {
                     channel.ScopeBuffer.UnsafeWrite(max(-1, min(1, sample * leftPan)))
                     channel.ScopeBuffer.UnsafeWrite(max(-1, min(1, sample * rightPan)))
 
-                    channel.startPosition += incrementRate
+                    if channel.playBackwards {
+                        channel.startPosition -= incrementRate
+                    } else {
+                        channel.startPosition += incrementRate
+                    }
                     samplesWritten += 1
                 }
             }
@@ -572,11 +1136,26 @@ type Player struct {
     BPM int
     Speed int
     OrdersPlayed int // How many orders have been played so far
+    // where playback has entered each order, used to tell when the song starts over
+    loops common.LoopDetector
+    // set once the song comes back to a place it already played
+    SongLooped bool
 
     GlobalVolume int
+    PanLaw common.PanLaw
 
     DoBreak bool
     BreakRow int // The row to break at, if DoBreak is true
+    DoJump bool
+    JumpOrder int // The order to jump to at the end of the row, if DoJump is true
+
+    // E6x pattern loop state
+    LoopRow int // the row that E60 marked as the start of the loop
+    LoopCount int // how many more times to repeat the loop
+    DoLoop bool // jump back to LoopRow at the end of the current row
+
+    // EEx repeats the current row this many more times
+    PatternDelay int
 
     Channels []*Channel
 
@@ -585,6 +1164,51 @@ type Player struct {
     OnChangeSpeed func(speed int, bpm int)
 }
 
+// the period of a note played with the given sample. linear periods are 64 units per
+// semitone, amiga periods are 4 times the protracker periods so that C-4 is 1712
+func (player *Player) NotePeriod(note float32, relativeNote int, fineTune int) float32 {
+    semitones := note - 1 + float32(relativeNote) + float32(fineTune) / 128
+
+    if player.XMFile.LinearFrequency {
+        return 10 * 12 * 16 * 4 - semitones * 16 * 4
+    }
+
+    return float32(1712 * 16 * math.Pow(2, -float64(semitones) / 12))
+}
+
+// the inverse of NotePeriod, the note number that plays at the given period
+func (player *Player) PeriodNote(period float32, relativeNote int, fineTune int) float32 {
+    var semitones float32
+    if player.XMFile.LinearFrequency {
+        semitones = (10 * 12 * 16 * 4 - period) / (16 * 4)
+    } else {
+        semitones = float32(-12 * math.Log2(float64(period) / (1712 * 16)))
+    }
+
+    return semitones + 1 - float32(relativeNote) - float32(fineTune) / 128
+}
+
+// move a period by a number of semitones, used by arpeggio
+func (player *Player) ShiftPeriod(period float32, semitones int) float32 {
+    if player.XMFile.LinearFrequency {
+        return period - float32(semitones * 16 * 4)
+    }
+
+    return float32(float64(period) * math.Pow(2, -float64(semitones) / 12))
+}
+
+func (player *Player) PeriodFrequency(period float32) float32 {
+    if period <= 0 {
+        return 0
+    }
+
+    if player.XMFile.LinearFrequency {
+        return float32(8363 * math.Pow(2, float64(6 * 12 * 16 * 4 - period) / (12 * 16 * 4)))
+    }
+
+    return 8363 * 1712 / period
+}
+
 func MakePlayer(file *XMFile, sampleRate int) *Player {
 
     /*
@@ -615,6 +1239,7 @@ func MakePlayer(file *XMFile, sampleRate int) *Player {
         Speed: int(file.Tempo),
         SampleRate: sampleRate,
         GlobalVolume: 64,
+        loops: common.MakeLoopDetector(),
     }
 
     for channelNum := range file.Channels {
@@ -626,6 +1251,8 @@ func MakePlayer(file *XMFile, sampleRate int) *Player {
             Volume: 1.0,
             CurrentVolume: 64,
             CurrentInstrument: -1,
+            Panning: 128,
+            FadeoutVolume: 1,
             buffer: make([]float32, sampleRate),
             currentRow: -1,
         })
@@ -658,34 +1285,54 @@ func (player *Player) Update(timeDelta float32) {
     player.ticks += timeDelta * float32(player.BPM) * 2 / 5
     newTicks := int(player.ticks)
 
+    // true if the row advanced, even if it went back to the same row because of a pattern loop
+    rowChanged := false
+
     if player.ticks >= float32(player.Speed) {
-        player.CurrentRow += 1
-        // log.Printf("Row: %v", player.CurrentRow)
         player.ticks -= float32(player.Speed)
 
-        if player.DoBreak {
-            player.NextOrder()
-            player.CurrentRow = player.BreakRow
-            player.DoBreak = false
+        if player.PatternDelay > 0 {
+            // repeat the row without playing its notes again
+            player.PatternDelay -= 1
+        } else {
+            player.CurrentRow += 1
+            rowChanged = true
+            // log.Printf("Row: %v", player.CurrentRow)
+
+            if player.DoLoop {
+                player.CurrentRow = player.LoopRow
+                player.DoLoop = false
+            } else if player.DoJump || player.DoBreak {
+                // Bxx on its own starts the new pattern from the top
+                row := 0
+                if player.DoBreak {
+                    row = player.BreakRow
+                }
+
+                if player.DoJump {
+                    player.advanceOrder(player.JumpOrder, row)
+                } else {
+                    player.advanceOrder(player.Order + 1, row)
+                }
+
+                player.DoJump = false
+                player.DoBreak = false
 
-            if player.OnChangeOrder != nil {
-                player.OnChangeOrder(player.Order, player.GetPattern())
+                if player.OnChangeOrder != nil {
+                    player.OnChangeOrder(player.Order, player.GetPattern())
+                }
             }
-        }
 
-        if player.OnChangeRow != nil {
-            player.OnChangeRow(player.CurrentRow)
+            if player.OnChangeRow != nil {
+                player.OnChangeRow(player.CurrentRow)
+            }
         }
     }
 
-    if player.CurrentRow >= int(player.XMFile.Patterns[0].Rows) {
+    pattern := player.XMFile.GetPattern(player.Order)
+    if pattern != nil && player.CurrentRow >= int(pattern.Rows) {
         // player.rowPosition = 0
-        player.CurrentRow = 0
-        player.Order += 1
-        player.OrdersPlayed += 1
-        if player.Order >= player.GetSongLength() {
-            player.Order = 0
-        }
+        player.advanceOrder(player.Order + 1, 0)
 
         if player.OnChangeOrder != nil {
             player.OnChangeOrder(player.Order, player.GetPattern())
@@ -697,7 +1344,7 @@ func (player *Player) Update(timeDelta float32) {
 
     for _, channel := range player.Channels {
         changeRow := false
-        if player.CurrentRow != channel.currentRow {
+        if rowChanged || player.CurrentRow != channel.currentRow {
             channel.UpdateRow()
             changeRow = true
         }
@@ -801,7 +1448,50 @@ func (player *Player) ToggleMuteChannel(channel int) bool {
     return player.Channels[channel].Mute
 }
 
+// E60 marks the start of a loop, E6x with x > 0 jumps back to it x times
+func (player *Player) doPatternLoop(value int) {
+    if value == 0 {
+        player.LoopRow = player.CurrentRow
+        return
+    }
+
+    if player.LoopCount == 0 {
+        player.LoopCount = value
+        player.DoLoop = true
+    } else {
+        player.LoopCount -= 1
+        if player.LoopCount > 0 {
+            player.DoLoop = true
+        }
+    }
+}
+
+// move playback to the given row of the given order as the song progresses. past the end of
+// the order list the song continues from its restart position
+func (player *Player) advanceOrder(order int, row int) {
+    if order < 0 || order >= player.GetSongLength() {
+        order = player.XMFile.RestartPosition
+    }
+
+    if player.loops.Visit(order, row) {
+        player.SongLooped = true
+    }
+
+    player.Order = order
+    player.CurrentRow = row
+    player.OrdersPlayed += 1
+    player.resetPatternLoop()
+}
+
+// forget any pattern loop, used when a new pattern starts
+func (player *Player) resetPatternLoop() {
+    player.LoopRow = 0
+    player.LoopCount = 0
+    player.DoLoop = false
+}
+
 func (player *Player) NextOrder() {
+    player.resetPatternLoop()
     player.Order += 1
     if player.Order >= player.GetSongLength() {
         player.Order = 0
@@ -813,6 +1503,7 @@ func (player *Player) NextOrder() {
 }
 
 func (player *Player) PreviousOrder() {
+    player.resetPatternLoop()
     player.Order -= 1
     if player.Order < 0 {
         player.Order = player.GetSongLength() - 1
@@ -844,7 +1535,7 @@ func (player *Player) RenderToPCM() io.Reader {
     mix := make([]float32, player.SampleRate * 2 / rate)
 
     fillMix := func() bool {
-        if player.OrdersPlayed >= player.GetSongLength() {
+        if player.SongLooped {
             return false
         }
 
@@ -881,7 +1572,7 @@ func (player *Player) RenderToPCM() io.Reader {
             return 0, nil
         }
 
-        if player.OrdersPlayed >= player.GetSongLength() {
+        if player.SongLooped {
             return 0, io.EOF
         }
 